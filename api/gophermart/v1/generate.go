@@ -0,0 +1,4 @@
+// Package gophermartv1 сгенерированный код gRPC API гофермарта.
+package gophermartv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gophermart.proto
@@ -0,0 +1,1010 @@
+// gRPC API гофермарта для внутренних сервисов. Повторяет HTTP API /api/user
+// и работает поверх тех же storage.Repository и правил.
+//
+// Авторизация - access токен из AuthService в метаданных: authorization: Bearer <token>.
+// Коды ответа соответствуют кодам HTTP API:
+// 400, 422 - INVALID_ARGUMENT; 401 - UNAUTHENTICATED; 402 - FAILED_PRECONDITION;
+// 403 - PERMISSION_DENIED; 404 - NOT_FOUND; 409 - ALREADY_EXISTS; 429 - RESOURCE_EXHAUSTED;
+// 503 - UNAVAILABLE; 500 - INTERNAL.
+//
+// Код генерируется командой go generate ./api/...
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.28.3
+// source: gophermart.proto
+
+package gophermartv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Credentials struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Login    string                 `protobuf:"bytes,1,opt,name=login,proto3" json:"login,omitempty"`
+	Password string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	// referral_code необязательный код пригласившего пользователя, учитывается только в Register
+	ReferralCode  string `protobuf:"bytes,3,opt,name=referral_code,json=referralCode,proto3" json:"referral_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Credentials) Reset() {
+	*x = Credentials{}
+	mi := &file_gophermart_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Credentials) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Credentials) ProtoMessage() {}
+
+func (x *Credentials) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Credentials.ProtoReflect.Descriptor instead.
+func (*Credentials) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Credentials) GetLogin() string {
+	if x != nil {
+		return x.Login
+	}
+	return ""
+}
+
+func (x *Credentials) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *Credentials) GetReferralCode() string {
+	if x != nil {
+		return x.ReferralCode
+	}
+	return ""
+}
+
+type TokenPair struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AccessToken   string                 `protobuf:"bytes,1,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	TokenType     string                 `protobuf:"bytes,2,opt,name=token_type,json=tokenType,proto3" json:"token_type,omitempty"`
+	ExpiresIn     int64                  `protobuf:"varint,3,opt,name=expires_in,json=expiresIn,proto3" json:"expires_in,omitempty"`
+	RefreshToken  string                 `protobuf:"bytes,4,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenPair) Reset() {
+	*x = TokenPair{}
+	mi := &file_gophermart_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenPair) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenPair) ProtoMessage() {}
+
+func (x *TokenPair) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenPair.ProtoReflect.Descriptor instead.
+func (*TokenPair) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TokenPair) GetAccessToken() string {
+	if x != nil {
+		return x.AccessToken
+	}
+	return ""
+}
+
+func (x *TokenPair) GetTokenType() string {
+	if x != nil {
+		return x.TokenType
+	}
+	return ""
+}
+
+func (x *TokenPair) GetExpiresIn() int64 {
+	if x != nil {
+		return x.ExpiresIn
+	}
+	return 0
+}
+
+func (x *TokenPair) GetRefreshToken() string {
+	if x != nil {
+		return x.RefreshToken
+	}
+	return ""
+}
+
+type RefreshTokenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RefreshToken  string                 `protobuf:"bytes,1,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RefreshTokenRequest) Reset() {
+	*x = RefreshTokenRequest{}
+	mi := &file_gophermart_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RefreshTokenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RefreshTokenRequest) ProtoMessage() {}
+
+func (x *RefreshTokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RefreshTokenRequest.ProtoReflect.Descriptor instead.
+func (*RefreshTokenRequest) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RefreshTokenRequest) GetRefreshToken() string {
+	if x != nil {
+		return x.RefreshToken
+	}
+	return ""
+}
+
+type UploadOrderRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        string                 `protobuf:"bytes,1,opt,name=number,proto3" json:"number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadOrderRequest) Reset() {
+	*x = UploadOrderRequest{}
+	mi := &file_gophermart_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadOrderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadOrderRequest) ProtoMessage() {}
+
+func (x *UploadOrderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadOrderRequest.ProtoReflect.Descriptor instead.
+func (*UploadOrderRequest) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UploadOrderRequest) GetNumber() string {
+	if x != nil {
+		return x.Number
+	}
+	return ""
+}
+
+type UploadOrderResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// created false - номер уже был загружен этим пользователем (HTTP 200), true - принят в обработку (HTTP 202)
+	Created       bool `protobuf:"varint,1,opt,name=created,proto3" json:"created,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadOrderResponse) Reset() {
+	*x = UploadOrderResponse{}
+	mi := &file_gophermart_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadOrderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadOrderResponse) ProtoMessage() {}
+
+func (x *UploadOrderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadOrderResponse.ProtoReflect.Descriptor instead.
+func (*UploadOrderResponse) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UploadOrderResponse) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+type Order struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        string                 `protobuf:"bytes,1,opt,name=number,proto3" json:"number,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Accrual       *float64               `protobuf:"fixed64,3,opt,name=accrual,proto3,oneof" json:"accrual,omitempty"`
+	UploadedAt    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=uploaded_at,json=uploadedAt,proto3" json:"uploaded_at,omitempty"`
+	ProcessedAt   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=processed_at,json=processedAt,proto3" json:"processed_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Order) Reset() {
+	*x = Order{}
+	mi := &file_gophermart_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Order) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Order) ProtoMessage() {}
+
+func (x *Order) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Order.ProtoReflect.Descriptor instead.
+func (*Order) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Order) GetNumber() string {
+	if x != nil {
+		return x.Number
+	}
+	return ""
+}
+
+func (x *Order) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Order) GetAccrual() float64 {
+	if x != nil && x.Accrual != nil {
+		return *x.Accrual
+	}
+	return 0
+}
+
+func (x *Order) GetUploadedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UploadedAt
+	}
+	return nil
+}
+
+func (x *Order) GetProcessedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ProcessedAt
+	}
+	return nil
+}
+
+type ListOrdersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Orders        []*Order               `protobuf:"bytes,1,rep,name=orders,proto3" json:"orders,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListOrdersResponse) Reset() {
+	*x = ListOrdersResponse{}
+	mi := &file_gophermart_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListOrdersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListOrdersResponse) ProtoMessage() {}
+
+func (x *ListOrdersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListOrdersResponse.ProtoReflect.Descriptor instead.
+func (*ListOrdersResponse) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListOrdersResponse) GetOrders() []*Order {
+	if x != nil {
+		return x.Orders
+	}
+	return nil
+}
+
+type GetOrderRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Number        string                 `protobuf:"bytes,1,opt,name=number,proto3" json:"number,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetOrderRequest) Reset() {
+	*x = GetOrderRequest{}
+	mi := &file_gophermart_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetOrderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetOrderRequest) ProtoMessage() {}
+
+func (x *GetOrderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetOrderRequest.ProtoReflect.Descriptor instead.
+func (*GetOrderRequest) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetOrderRequest) GetNumber() string {
+	if x != nil {
+		return x.Number
+	}
+	return ""
+}
+
+type OrderStatusChange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Accrual       *float64               `protobuf:"fixed64,2,opt,name=accrual,proto3,oneof" json:"accrual,omitempty"`
+	ChangedAt     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=changed_at,json=changedAt,proto3" json:"changed_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderStatusChange) Reset() {
+	*x = OrderStatusChange{}
+	mi := &file_gophermart_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderStatusChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderStatusChange) ProtoMessage() {}
+
+func (x *OrderStatusChange) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderStatusChange.ProtoReflect.Descriptor instead.
+func (*OrderStatusChange) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *OrderStatusChange) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *OrderStatusChange) GetAccrual() float64 {
+	if x != nil && x.Accrual != nil {
+		return *x.Accrual
+	}
+	return 0
+}
+
+func (x *OrderStatusChange) GetChangedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ChangedAt
+	}
+	return nil
+}
+
+type OrderDetail struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Order         *Order                 `protobuf:"bytes,1,opt,name=order,proto3" json:"order,omitempty"`
+	History       []*OrderStatusChange   `protobuf:"bytes,2,rep,name=history,proto3" json:"history,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OrderDetail) Reset() {
+	*x = OrderDetail{}
+	mi := &file_gophermart_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OrderDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OrderDetail) ProtoMessage() {}
+
+func (x *OrderDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OrderDetail.ProtoReflect.Descriptor instead.
+func (*OrderDetail) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *OrderDetail) GetOrder() *Order {
+	if x != nil {
+		return x.Order
+	}
+	return nil
+}
+
+func (x *OrderDetail) GetHistory() []*OrderStatusChange {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+type WatchOrdersRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// numbers отслеживать только эти заказы, пусто - все заказы пользователя
+	Numbers []string `protobuf:"bytes,1,rep,name=numbers,proto3" json:"numbers,omitempty"`
+	// skip_current не присылать текущее состояние, только изменения
+	SkipCurrent   bool `protobuf:"varint,2,opt,name=skip_current,json=skipCurrent,proto3" json:"skip_current,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchOrdersRequest) Reset() {
+	*x = WatchOrdersRequest{}
+	mi := &file_gophermart_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchOrdersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchOrdersRequest) ProtoMessage() {}
+
+func (x *WatchOrdersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchOrdersRequest.ProtoReflect.Descriptor instead.
+func (*WatchOrdersRequest) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *WatchOrdersRequest) GetNumbers() []string {
+	if x != nil {
+		return x.Numbers
+	}
+	return nil
+}
+
+func (x *WatchOrdersRequest) GetSkipCurrent() bool {
+	if x != nil {
+		return x.SkipCurrent
+	}
+	return false
+}
+
+type Balance struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Current       float64                `protobuf:"fixed64,1,opt,name=current,proto3" json:"current,omitempty"`
+	Withdrawn     float64                `protobuf:"fixed64,2,opt,name=withdrawn,proto3" json:"withdrawn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Balance) Reset() {
+	*x = Balance{}
+	mi := &file_gophermart_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Balance) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Balance) ProtoMessage() {}
+
+func (x *Balance) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Balance.ProtoReflect.Descriptor instead.
+func (*Balance) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Balance) GetCurrent() float64 {
+	if x != nil {
+		return x.Current
+	}
+	return 0
+}
+
+func (x *Balance) GetWithdrawn() float64 {
+	if x != nil {
+		return x.Withdrawn
+	}
+	return 0
+}
+
+type WithdrawRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Order         string                 `protobuf:"bytes,1,opt,name=order,proto3" json:"order,omitempty"`
+	Sum           float64                `protobuf:"fixed64,2,opt,name=sum,proto3" json:"sum,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WithdrawRequest) Reset() {
+	*x = WithdrawRequest{}
+	mi := &file_gophermart_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WithdrawRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WithdrawRequest) ProtoMessage() {}
+
+func (x *WithdrawRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WithdrawRequest.ProtoReflect.Descriptor instead.
+func (*WithdrawRequest) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *WithdrawRequest) GetOrder() string {
+	if x != nil {
+		return x.Order
+	}
+	return ""
+}
+
+func (x *WithdrawRequest) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+type Withdrawal struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Order         string                 `protobuf:"bytes,1,opt,name=order,proto3" json:"order,omitempty"`
+	Sum           float64                `protobuf:"fixed64,2,opt,name=sum,proto3" json:"sum,omitempty"`
+	ProcessedAt   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=processed_at,json=processedAt,proto3" json:"processed_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Withdrawal) Reset() {
+	*x = Withdrawal{}
+	mi := &file_gophermart_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Withdrawal) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Withdrawal) ProtoMessage() {}
+
+func (x *Withdrawal) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Withdrawal.ProtoReflect.Descriptor instead.
+func (*Withdrawal) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Withdrawal) GetOrder() string {
+	if x != nil {
+		return x.Order
+	}
+	return ""
+}
+
+func (x *Withdrawal) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+func (x *Withdrawal) GetProcessedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ProcessedAt
+	}
+	return nil
+}
+
+type ListWithdrawalsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Withdrawals   []*Withdrawal          `protobuf:"bytes,1,rep,name=withdrawals,proto3" json:"withdrawals,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWithdrawalsResponse) Reset() {
+	*x = ListWithdrawalsResponse{}
+	mi := &file_gophermart_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWithdrawalsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWithdrawalsResponse) ProtoMessage() {}
+
+func (x *ListWithdrawalsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gophermart_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWithdrawalsResponse.ProtoReflect.Descriptor instead.
+func (*ListWithdrawalsResponse) Descriptor() ([]byte, []int) {
+	return file_gophermart_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ListWithdrawalsResponse) GetWithdrawals() []*Withdrawal {
+	if x != nil {
+		return x.Withdrawals
+	}
+	return nil
+}
+
+var File_gophermart_proto protoreflect.FileDescriptor
+
+const file_gophermart_proto_rawDesc = "" +
+	"\n" +
+	"\x10gophermart.proto\x12\rgophermart.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"d\n" +
+	"\vCredentials\x12\x14\n" +
+	"\x05login\x18\x01 \x01(\tR\x05login\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\x12#\n" +
+	"\rreferral_code\x18\x03 \x01(\tR\freferralCode\"\x91\x01\n" +
+	"\tTokenPair\x12!\n" +
+	"\faccess_token\x18\x01 \x01(\tR\vaccessToken\x12\x1d\n" +
+	"\n" +
+	"token_type\x18\x02 \x01(\tR\ttokenType\x12\x1d\n" +
+	"\n" +
+	"expires_in\x18\x03 \x01(\x03R\texpiresIn\x12#\n" +
+	"\rrefresh_token\x18\x04 \x01(\tR\frefreshToken\":\n" +
+	"\x13RefreshTokenRequest\x12#\n" +
+	"\rrefresh_token\x18\x01 \x01(\tR\frefreshToken\",\n" +
+	"\x12UploadOrderRequest\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\tR\x06number\"/\n" +
+	"\x13UploadOrderResponse\x12\x18\n" +
+	"\acreated\x18\x01 \x01(\bR\acreated\"\xde\x01\n" +
+	"\x05Order\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\tR\x06number\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x1d\n" +
+	"\aaccrual\x18\x03 \x01(\x01H\x00R\aaccrual\x88\x01\x01\x12;\n" +
+	"\vuploaded_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"uploadedAt\x12=\n" +
+	"\fprocessed_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\vprocessedAtB\n" +
+	"\n" +
+	"\b_accrual\"B\n" +
+	"\x12ListOrdersResponse\x12,\n" +
+	"\x06orders\x18\x01 \x03(\v2\x14.gophermart.v1.OrderR\x06orders\")\n" +
+	"\x0fGetOrderRequest\x12\x16\n" +
+	"\x06number\x18\x01 \x01(\tR\x06number\"\x91\x01\n" +
+	"\x11OrderStatusChange\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1d\n" +
+	"\aaccrual\x18\x02 \x01(\x01H\x00R\aaccrual\x88\x01\x01\x129\n" +
+	"\n" +
+	"changed_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tchangedAtB\n" +
+	"\n" +
+	"\b_accrual\"u\n" +
+	"\vOrderDetail\x12*\n" +
+	"\x05order\x18\x01 \x01(\v2\x14.gophermart.v1.OrderR\x05order\x12:\n" +
+	"\ahistory\x18\x02 \x03(\v2 .gophermart.v1.OrderStatusChangeR\ahistory\"Q\n" +
+	"\x12WatchOrdersRequest\x12\x18\n" +
+	"\anumbers\x18\x01 \x03(\tR\anumbers\x12!\n" +
+	"\fskip_current\x18\x02 \x01(\bR\vskipCurrent\"A\n" +
+	"\aBalance\x12\x18\n" +
+	"\acurrent\x18\x01 \x01(\x01R\acurrent\x12\x1c\n" +
+	"\twithdrawn\x18\x02 \x01(\x01R\twithdrawn\"9\n" +
+	"\x0fWithdrawRequest\x12\x14\n" +
+	"\x05order\x18\x01 \x01(\tR\x05order\x12\x10\n" +
+	"\x03sum\x18\x02 \x01(\x01R\x03sum\"s\n" +
+	"\n" +
+	"Withdrawal\x12\x14\n" +
+	"\x05order\x18\x01 \x01(\tR\x05order\x12\x10\n" +
+	"\x03sum\x18\x02 \x01(\x01R\x03sum\x12=\n" +
+	"\fprocessed_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vprocessedAt\"V\n" +
+	"\x17ListWithdrawalsResponse\x12;\n" +
+	"\vwithdrawals\x18\x01 \x03(\v2\x19.gophermart.v1.WithdrawalR\vwithdrawals2\xa7\x02\n" +
+	"\vAuthService\x12@\n" +
+	"\bRegister\x12\x1a.gophermart.v1.Credentials\x1a\x18.gophermart.v1.TokenPair\x12=\n" +
+	"\x05Login\x12\x1a.gophermart.v1.Credentials\x1a\x18.gophermart.v1.TokenPair\x12L\n" +
+	"\fRefreshToken\x12\".gophermart.v1.RefreshTokenRequest\x1a\x18.gophermart.v1.TokenPair\x12I\n" +
+	"\vRevokeToken\x12\".gophermart.v1.RefreshTokenRequest\x1a\x16.google.protobuf.Empty2\xbf\x02\n" +
+	"\fOrderService\x12T\n" +
+	"\vUploadOrder\x12!.gophermart.v1.UploadOrderRequest\x1a\".gophermart.v1.UploadOrderResponse\x12G\n" +
+	"\n" +
+	"ListOrders\x12\x16.google.protobuf.Empty\x1a!.gophermart.v1.ListOrdersResponse\x12F\n" +
+	"\bGetOrder\x12\x1e.gophermart.v1.GetOrderRequest\x1a\x1a.gophermart.v1.OrderDetail\x12H\n" +
+	"\vWatchOrders\x12!.gophermart.v1.WatchOrdersRequest\x1a\x14.gophermart.v1.Order0\x012N\n" +
+	"\x0eBalanceService\x12<\n" +
+	"\n" +
+	"GetBalance\x12\x16.google.protobuf.Empty\x1a\x16.gophermart.v1.Balance2\xaa\x01\n" +
+	"\x11WithdrawalService\x12B\n" +
+	"\bWithdraw\x12\x1e.gophermart.v1.WithdrawRequest\x1a\x16.google.protobuf.Empty\x12Q\n" +
+	"\x0fListWithdrawals\x12\x16.google.protobuf.Empty\x1a&.gophermart.v1.ListWithdrawalsResponseBAZ?github.com/polosaty/go-dev-final/api/gophermart/v1;gophermartv1b\x06proto3"
+
+var (
+	file_gophermart_proto_rawDescOnce sync.Once
+	file_gophermart_proto_rawDescData []byte
+)
+
+func file_gophermart_proto_rawDescGZIP() []byte {
+	file_gophermart_proto_rawDescOnce.Do(func() {
+		file_gophermart_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gophermart_proto_rawDesc), len(file_gophermart_proto_rawDesc)))
+	})
+	return file_gophermart_proto_rawDescData
+}
+
+var file_gophermart_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_gophermart_proto_goTypes = []any{
+	(*Credentials)(nil),             // 0: gophermart.v1.Credentials
+	(*TokenPair)(nil),               // 1: gophermart.v1.TokenPair
+	(*RefreshTokenRequest)(nil),     // 2: gophermart.v1.RefreshTokenRequest
+	(*UploadOrderRequest)(nil),      // 3: gophermart.v1.UploadOrderRequest
+	(*UploadOrderResponse)(nil),     // 4: gophermart.v1.UploadOrderResponse
+	(*Order)(nil),                   // 5: gophermart.v1.Order
+	(*ListOrdersResponse)(nil),      // 6: gophermart.v1.ListOrdersResponse
+	(*GetOrderRequest)(nil),         // 7: gophermart.v1.GetOrderRequest
+	(*OrderStatusChange)(nil),       // 8: gophermart.v1.OrderStatusChange
+	(*OrderDetail)(nil),             // 9: gophermart.v1.OrderDetail
+	(*WatchOrdersRequest)(nil),      // 10: gophermart.v1.WatchOrdersRequest
+	(*Balance)(nil),                 // 11: gophermart.v1.Balance
+	(*WithdrawRequest)(nil),         // 12: gophermart.v1.WithdrawRequest
+	(*Withdrawal)(nil),              // 13: gophermart.v1.Withdrawal
+	(*ListWithdrawalsResponse)(nil), // 14: gophermart.v1.ListWithdrawalsResponse
+	(*timestamppb.Timestamp)(nil),   // 15: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),           // 16: google.protobuf.Empty
+}
+var file_gophermart_proto_depIdxs = []int32{
+	15, // 0: gophermart.v1.Order.uploaded_at:type_name -> google.protobuf.Timestamp
+	15, // 1: gophermart.v1.Order.processed_at:type_name -> google.protobuf.Timestamp
+	5,  // 2: gophermart.v1.ListOrdersResponse.orders:type_name -> gophermart.v1.Order
+	15, // 3: gophermart.v1.OrderStatusChange.changed_at:type_name -> google.protobuf.Timestamp
+	5,  // 4: gophermart.v1.OrderDetail.order:type_name -> gophermart.v1.Order
+	8,  // 5: gophermart.v1.OrderDetail.history:type_name -> gophermart.v1.OrderStatusChange
+	15, // 6: gophermart.v1.Withdrawal.processed_at:type_name -> google.protobuf.Timestamp
+	13, // 7: gophermart.v1.ListWithdrawalsResponse.withdrawals:type_name -> gophermart.v1.Withdrawal
+	0,  // 8: gophermart.v1.AuthService.Register:input_type -> gophermart.v1.Credentials
+	0,  // 9: gophermart.v1.AuthService.Login:input_type -> gophermart.v1.Credentials
+	2,  // 10: gophermart.v1.AuthService.RefreshToken:input_type -> gophermart.v1.RefreshTokenRequest
+	2,  // 11: gophermart.v1.AuthService.RevokeToken:input_type -> gophermart.v1.RefreshTokenRequest
+	3,  // 12: gophermart.v1.OrderService.UploadOrder:input_type -> gophermart.v1.UploadOrderRequest
+	16, // 13: gophermart.v1.OrderService.ListOrders:input_type -> google.protobuf.Empty
+	7,  // 14: gophermart.v1.OrderService.GetOrder:input_type -> gophermart.v1.GetOrderRequest
+	10, // 15: gophermart.v1.OrderService.WatchOrders:input_type -> gophermart.v1.WatchOrdersRequest
+	16, // 16: gophermart.v1.BalanceService.GetBalance:input_type -> google.protobuf.Empty
+	12, // 17: gophermart.v1.WithdrawalService.Withdraw:input_type -> gophermart.v1.WithdrawRequest
+	16, // 18: gophermart.v1.WithdrawalService.ListWithdrawals:input_type -> google.protobuf.Empty
+	1,  // 19: gophermart.v1.AuthService.Register:output_type -> gophermart.v1.TokenPair
+	1,  // 20: gophermart.v1.AuthService.Login:output_type -> gophermart.v1.TokenPair
+	1,  // 21: gophermart.v1.AuthService.RefreshToken:output_type -> gophermart.v1.TokenPair
+	16, // 22: gophermart.v1.AuthService.RevokeToken:output_type -> google.protobuf.Empty
+	4,  // 23: gophermart.v1.OrderService.UploadOrder:output_type -> gophermart.v1.UploadOrderResponse
+	6,  // 24: gophermart.v1.OrderService.ListOrders:output_type -> gophermart.v1.ListOrdersResponse
+	9,  // 25: gophermart.v1.OrderService.GetOrder:output_type -> gophermart.v1.OrderDetail
+	5,  // 26: gophermart.v1.OrderService.WatchOrders:output_type -> gophermart.v1.Order
+	11, // 27: gophermart.v1.BalanceService.GetBalance:output_type -> gophermart.v1.Balance
+	16, // 28: gophermart.v1.WithdrawalService.Withdraw:output_type -> google.protobuf.Empty
+	14, // 29: gophermart.v1.WithdrawalService.ListWithdrawals:output_type -> gophermart.v1.ListWithdrawalsResponse
+	19, // [19:30] is the sub-list for method output_type
+	8,  // [8:19] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_gophermart_proto_init() }
+func file_gophermart_proto_init() {
+	if File_gophermart_proto != nil {
+		return
+	}
+	file_gophermart_proto_msgTypes[5].OneofWrappers = []any{}
+	file_gophermart_proto_msgTypes[8].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gophermart_proto_rawDesc), len(file_gophermart_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   4,
+		},
+		GoTypes:           file_gophermart_proto_goTypes,
+		DependencyIndexes: file_gophermart_proto_depIdxs,
+		MessageInfos:      file_gophermart_proto_msgTypes,
+	}.Build()
+	File_gophermart_proto = out.File
+	file_gophermart_proto_goTypes = nil
+	file_gophermart_proto_depIdxs = nil
+}
@@ -1,32 +1,262 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"github.com/caarlos0/env/v6"
+	"fmt"
 	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
 	"github.com/polosaty/go-dev-final/internal/app/server"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
 	"log"
+	"os"
+	"strings"
 )
 
 func main() {
-	var cfg config.Config
-	err := env.Parse(&cfg)
+	cfg, printConfig, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	if printConfig {
+		if err = config.Print(os.Stdout, cfg); err != nil {
+			log.Fatal(err)
+		}
+		if err = cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
-	flag.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "server address")
-	flag.StringVar(&cfg.DatabaseURI, "d", cfg.DatabaseURI, "database URI")
-	flag.StringVar(&cfg.AccrualSystemAddress, "r", cfg.AccrualSystemAddress, "accrual system address")
-	flag.Parse()
+	if err = logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel, cfg.LogLevels); err != nil {
+		log.Fatal(err)
+	}
+	for _, warning := range cfg.Warnings() {
+		logging.Component("config").Warn(warning)
+	}
 
 	var db storage.Repository
 
-	if db, err = storage.NewStoragePG(cfg.DatabaseURI); err != nil {
+	if db, err = storage.NewStoragePG(cfg.DatabaseURI, storage.PGOptions{
+		Password:           cfg.DatabasePassword,
+		MaxConns:           cfg.DatabaseMaxConns,
+		BcryptCost:         cfg.BcryptCost,
+		PointsExpiryMonths: cfg.PointsExpiryMonths,
+		WithdrawalLimits: storage.WithdrawalLimits{
+			Min:            cfg.WithdrawalMin,
+			PerTransaction: cfg.WithdrawalMaxPerTransaction,
+			PerDay:         cfg.WithdrawalMaxPerDay,
+			PerMonth:       cfg.WithdrawalMaxPerMonth,
+		},
+		Tiers: storage.Tiers{
+			SilverThreshold:  cfg.TierSilverThreshold,
+			GoldThreshold:    cfg.TierGoldThreshold,
+			BronzeMultiplier: cfg.TierBronzeMultiplier,
+			SilverMultiplier: cfg.TierSilverMultiplier,
+			GoldMultiplier:   cfg.TierGoldMultiplier,
+		},
+		ReferralBonus: storage.ReferralBonus{
+			Referrer:       cfg.ReferralReferrerBonus,
+			Referred:       cfg.ReferralReferredBonus,
+			MaxPerReferrer: cfg.ReferralMaxPerReferrer,
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+	logging.Component("server").Info("use postgres as db", "database_uri", logging.RedactURL(cfg.DatabaseURI))
+
+	// при перечитывании настроек (SIGHUP) источники те же, что при запуске, включая флаги
+	reload := func() (config.Config, error) {
+		cfg, _, err := loadConfig(os.Args[1:])
+		return cfg, err
+	}
+	if err = server.Serve(cfg, db, reload); err != nil {
 		log.Fatal(err)
 	}
-	log.Println("use postgres conn " + cfg.DatabaseURI + " as db")
+}
+
+// loadConfig собирает настройки по слоям: значения по умолчанию, файл (-config или CONFIG_FILE),
+// переменные окружения, флаги
+func loadConfig(args []string) (cfg config.Config, printConfig bool, err error) {
+	file := configFile(args)
+	if cfg, err = config.Load(file); err != nil {
+		return cfg, false, err
+	}
 
-	log.Fatal(server.Serve(cfg.RunAddress, cfg.AccrualSystemAddress, db))
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.String("config", file, "config file: yaml, json or toml (or "+config.FileEnv+" env)")
+	flags.BoolVar(&printConfig, "print-config", false, "print effective config with secrets redacted and exit")
+	bindFlags(flags, &cfg)
+	err = flags.Parse(args)
+	return cfg, printConfig, err
+}
+
+// configFile путь к файлу настроек нужен до разбора остальных флагов: их значения по умолчанию берутся из него
+func configFile(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(config.FileEnv)
+}
+
+func bindFlags(flags *flag.FlagSet, cfg *config.Config) {
+	flags.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "server address")
+	flags.StringVar(&cfg.DatabaseURI, "d", cfg.DatabaseURI, "database URI")
+	flags.StringVar(&cfg.AccrualSystemAddress, "r", cfg.AccrualSystemAddress, "accrual system address")
+	flags.IntVar(&cfg.DatabaseMaxConns, "database-max-conns", cfg.DatabaseMaxConns, "database pool size (0 - by CPU count)")
+	flags.IntVar(&cfg.BcryptCost, "bcrypt-cost", cfg.BcryptCost, "bcrypt cost for new password hashes")
+	flags.IntVar(&cfg.CheckerPollLimit, "checker-poll-limit", cfg.CheckerPollLimit, "orders selected for check at once")
+	flags.DurationVar(&cfg.CheckerPollInterval, "checker-poll-interval", cfg.CheckerPollInterval,
+		"order checker pause after all orders are checked")
+	flags.IntVar(&cfg.CheckerQueueSize, "checker-queue-size", cfg.CheckerQueueSize, "order checker queues capacity")
+	flags.IntVar(&cfg.CheckerBatchSize, "checker-batch-size", cfg.CheckerBatchSize, "order statuses saved at once")
+	flags.DurationVar(&cfg.CheckerFlushInterval, "checker-flush-interval", cfg.CheckerFlushInterval,
+		"save incomplete batch of order statuses after")
+	flags.IntVar(&cfg.CheckerConcurrency, "checker-concurrency", cfg.CheckerConcurrency, "orders checked in accrual system at once")
+	flags.IntVar(&cfg.PointsExpiryMonths, "points-expiry-months", cfg.PointsExpiryMonths, "months before accrued points expire")
+	flags.DurationVar(&cfg.PointsExpiryInterval, "points-expiry-interval", cfg.PointsExpiryInterval, "how often expired points are burned")
+	flags.IntVar(&cfg.PointsExpiryBatchSize, "points-expiry-batch-size", cfg.PointsExpiryBatchSize, "users per points expiry transaction")
+	flags.Float64Var(&cfg.WithdrawalMin, "withdrawal-min", cfg.WithdrawalMin, "minimum withdrawal sum (0 - no limit)")
+	flags.Float64Var(&cfg.WithdrawalMaxPerTransaction, "withdrawal-max-per-transaction", cfg.WithdrawalMaxPerTransaction,
+		"maximum sum of one withdrawal (0 - no limit)")
+	flags.Float64Var(&cfg.WithdrawalMaxPerDay, "withdrawal-max-per-day", cfg.WithdrawalMaxPerDay,
+		"maximum withdrawals per calendar day, UTC (0 - no limit)")
+	flags.Float64Var(&cfg.WithdrawalMaxPerMonth, "withdrawal-max-per-month", cfg.WithdrawalMaxPerMonth,
+		"maximum withdrawals per calendar month, UTC (0 - no limit)")
+	flags.IntVar(&cfg.FraudUploadVelocityLimit, "fraud-upload-velocity-limit", cfg.FraudUploadVelocityLimit,
+		"order uploads per window before fraud rule fires (0 - off)")
+	flags.DurationVar(&cfg.FraudUploadVelocityWindow, "fraud-upload-velocity-window", cfg.FraudUploadVelocityWindow,
+		"order upload velocity window")
+	flags.StringVar(&cfg.FraudUploadVelocityAction, "fraud-upload-velocity-action", cfg.FraudUploadVelocityAction,
+		"order upload velocity rule action: flag or block")
+	flags.IntVar(&cfg.FraudConflictLimit, "fraud-conflict-limit", cfg.FraudConflictLimit,
+		"uploads of other users' orders per window before fraud rule fires (0 - off)")
+	flags.DurationVar(&cfg.FraudConflictWindow, "fraud-conflict-window", cfg.FraudConflictWindow, "order conflicts window")
+	flags.StringVar(&cfg.FraudConflictAction, "fraud-conflict-action", cfg.FraudConflictAction,
+		"order conflicts rule action: flag or block")
+	flags.DurationVar(&cfg.FraudNewAccountHold, "fraud-new-account-hold", cfg.FraudNewAccountHold,
+		"withdrawals rule period after registration (0 - off)")
+	flags.StringVar(&cfg.FraudNewAccountAction, "fraud-new-account-action", cfg.FraudNewAccountAction,
+		"new account withdrawal rule action: flag or block")
+	flags.DurationVar(&cfg.FraudAccrualCooldown, "fraud-accrual-cooldown", cfg.FraudAccrualCooldown,
+		"withdrawals rule period after accrual (0 - off)")
+	flags.StringVar(&cfg.FraudAccrualCooldownAction, "fraud-accrual-cooldown-action", cfg.FraudAccrualCooldownAction,
+		"withdrawal after accrual rule action: flag or block")
+	flags.Float64Var(&cfg.TierSilverThreshold, "tier-silver-threshold", cfg.TierSilverThreshold,
+		"accrual for 12 months to reach silver tier")
+	flags.Float64Var(&cfg.TierGoldThreshold, "tier-gold-threshold", cfg.TierGoldThreshold, "accrual for 12 months to reach gold tier")
+	flags.Float64Var(&cfg.TierBronzeMultiplier, "tier-bronze-multiplier", cfg.TierBronzeMultiplier, "bronze tier accrual multiplier")
+	flags.Float64Var(&cfg.TierSilverMultiplier, "tier-silver-multiplier", cfg.TierSilverMultiplier, "silver tier accrual multiplier")
+	flags.Float64Var(&cfg.TierGoldMultiplier, "tier-gold-multiplier", cfg.TierGoldMultiplier, "gold tier accrual multiplier")
+	flags.StringVar(&cfg.TierRecalcAt, "tier-recalc-at", cfg.TierRecalcAt, "daily tiers recalculation time, HH:MM UTC")
+	flags.IntVar(&cfg.TierRecalcBatchSize, "tier-recalc-batch-size", cfg.TierRecalcBatchSize, "users per tiers recalculation query")
+	flags.Float64Var(&cfg.ReferralReferrerBonus, "referral-referrer-bonus", cfg.ReferralReferrerBonus,
+		"bonus to referrer for referred user's first processed order")
+	flags.Float64Var(&cfg.ReferralReferredBonus, "referral-referred-bonus", cfg.ReferralReferredBonus,
+		"bonus to referred user for the first processed order")
+	flags.IntVar(&cfg.ReferralMaxPerReferrer, "referral-max-per-referrer", cfg.ReferralMaxPerReferrer,
+		"rewarded referrals per referrer (0 - no limit)")
+	flags.IntVar(&cfg.AccrualRetryCount, "accrual-retry-count", cfg.AccrualRetryCount, "accrual system request retries")
+	flags.StringVar(&cfg.CookieSameSite, "cookie-same-site", cfg.CookieSameSite, "auth cookie SameSite: lax, strict or none")
+	flags.Func("cors-allowed-origins", "comma separated origins allowed to call API from browser", func(value string) error {
+		cfg.CORSAllowedOrigins = splitList(value)
+		return nil
+	})
+	flags.BoolVar(&cfg.CORSAllowCredentials, "cors-allow-credentials", cfg.CORSAllowCredentials, "allow cookies in CORS requests")
+	flags.DurationVar(&cfg.CORSMaxAge, "cors-max-age", cfg.CORSMaxAge, "CORS preflight cache time")
+	flags.Func("trusted-proxies", "comma separated proxy IPs or CIDRs trusted in X-Forwarded-For", func(value string) error {
+		cfg.TrustedProxies = splitList(value)
+		return nil
+	})
+	flags.IntVar(&cfg.CompressMinSize, "compress-min-size", cfg.CompressMinSize, "min response size to compress")
+	flags.Func("compress-content-types", "comma separated content types to compress (default "+
+		strings.Join(cfg.CompressContentTypes, ",")+")", func(value string) error {
+		cfg.CompressContentTypes = splitList(value)
+		return nil
+	})
+	flags.Int64Var(&cfg.MaxDecompressedRequestSize, "max-decompressed-request-size", cfg.MaxDecompressedRequestSize,
+		"max request body size after decompression")
+	flags.StringVar(&cfg.AdminAddress, "admin-address", cfg.AdminAddress, "admin server address with /metrics and detailed /readyz (empty - disabled)")
+	flags.DurationVar(&cfg.ReadinessCacheTTL, "readiness-cache-ttl", cfg.ReadinessCacheTTL, "readiness checks cache TTL")
+	flags.DurationVar(&cfg.ReadinessTimeout, "readiness-timeout", cfg.ReadinessTimeout, "readiness checks timeout")
+	flags.DurationVar(&cfg.CheckerStaleAfter, "checker-stale-after", cfg.CheckerStaleAfter,
+		"fail readiness when order checker keeps failing this long")
+	flags.DurationVar(&cfg.ShutdownDrainDelay, "shutdown-drain-delay", cfg.ShutdownDrainDelay,
+		"time to fail readiness before stopping servers")
+	flags.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "graceful shutdown timeout")
+	flags.StringVar(&cfg.GRPCAddress, "grpc-address", cfg.GRPCAddress, "gRPC server address (empty - disabled)")
+	flags.DurationVar(&cfg.GRPCWatchInterval, "grpc-watch-interval", cfg.GRPCWatchInterval, "gRPC WatchOrders poll interval")
+	flags.DurationVar(&cfg.SessionTTL, "session-ttl", cfg.SessionTTL, "session TTL")
+	flags.BoolVar(&cfg.SessionSliding, "session-sliding", cfg.SessionSliding, "extend session TTL on every request")
+	flags.BoolVar(&cfg.CookieSecure, "cookie-secure", cfg.CookieSecure, "set Secure flag on auth cookie")
+	flags.DurationVar(&cfg.AccessTokenTTL, "access-token-ttl", cfg.AccessTokenTTL, "access token (JWT) TTL")
+	flags.DurationVar(&cfg.RefreshTokenTTL, "refresh-token-ttl", cfg.RefreshTokenTTL, "refresh token TTL")
+	flags.DurationVar(&cfg.SigningKeyRotation, "signing-key-rotation", cfg.SigningKeyRotation, "access token signing key rotation interval")
+	flags.IntVar(&cfg.LoginRateLimitPerIP, "login-rate-ip", cfg.LoginRateLimitPerIP, "login/register attempts per IP per window")
+	flags.IntVar(&cfg.LoginRateLimitPerLogin, "login-rate-login", cfg.LoginRateLimitPerLogin, "login attempts per login per window")
+	flags.DurationVar(&cfg.LoginRateLimitWindow, "login-rate-window", cfg.LoginRateLimitWindow, "login rate limit window")
+	flags.StringVar(&cfg.RateLimitStore, "rate-limit-store", cfg.RateLimitStore, "rate limit store: memory or postgres")
+	flags.IntVar(&cfg.LoginMaxFailures, "login-max-failures", cfg.LoginMaxFailures, "failed logins before temporary lock")
+	flags.DurationVar(&cfg.LoginLockDuration, "login-lock-duration", cfg.LoginLockDuration, "temporary lock duration")
+	flags.IntVar(&cfg.BcryptConcurrency, "bcrypt-concurrency", cfg.BcryptConcurrency, "max concurrent bcrypt computations")
+	flags.DurationVar(&cfg.BcryptQueueTimeout, "bcrypt-queue-timeout", cfg.BcryptQueueTimeout, "max wait for bcrypt slot")
+	flags.IntVar(&cfg.LoginMinLength, "login-min-length", cfg.LoginMinLength, "min login length")
+	flags.IntVar(&cfg.LoginMaxLength, "login-max-length", cfg.LoginMaxLength, "max login length")
+	flags.StringVar(&cfg.LoginPattern, "login-pattern", cfg.LoginPattern, "login regexp (checked after lowercasing)")
+	flags.IntVar(&cfg.PasswordMinLength, "password-min-length", cfg.PasswordMinLength, "min password length")
+	flags.IntVar(&cfg.PasswordMaxLength, "password-max-length", cfg.PasswordMaxLength, "max password length in bytes")
+	flags.BoolVar(&cfg.PasswordRejectCommon, "password-reject-common", cfg.PasswordRejectCommon, "reject common passwords")
+	flags.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: json or logfmt")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "default log level: debug, info, warn or error")
+	flags.StringVar(&cfg.LogLevels, "log-levels", cfg.LogLevels, "per component log levels, e.g. storage=debug,order_checker=warn")
+	flags.StringVar(&cfg.TracingExporter, "tracing-exporter", cfg.TracingExporter, "trace exporter: none, otlp, stdout or file")
+	flags.StringVar(&cfg.TracingEndpoint, "tracing-endpoint", cfg.TracingEndpoint, "OTLP collector endpoint")
+	flags.StringVar(&cfg.TracingProtocol, "tracing-protocol", cfg.TracingProtocol, "OTLP protocol: grpc or http")
+	flags.BoolVar(&cfg.TracingInsecure, "tracing-insecure", cfg.TracingInsecure, "connect to OTLP collector without TLS")
+	flags.StringVar(&cfg.TracingFile, "tracing-file", cfg.TracingFile, "file for file trace exporter")
+	flags.Float64Var(&cfg.TracingSampleRatio, "tracing-sample-ratio", cfg.TracingSampleRatio, "fraction of traces to sample (0..1)")
+	flags.StringVar(&cfg.TLSCertFile, "tls-cert-file", cfg.TLSCertFile, "TLS certificate file (PEM), enables TLS")
+	flags.StringVar(&cfg.TLSKeyFile, "tls-key-file", cfg.TLSKeyFile, "TLS private key file (PEM)")
+	flags.StringVar(&cfg.TLSMinVersion, "tls-min-version", cfg.TLSMinVersion, "minimal TLS version: 1.2 or 1.3")
+	flags.Func("tls-cipher-suites", "comma separated TLS 1.2 cipher suites", func(value string) error {
+		cfg.TLSCipherSuites = splitList(value)
+		return nil
+	})
+	flags.DurationVar(&cfg.TLSReloadInterval, "tls-reload-interval", cfg.TLSReloadInterval,
+		"certificate files change check interval (0 - reload only on SIGHUP)")
+	flags.StringVar(&cfg.AdminClientCAFile, "admin-client-ca-file", cfg.AdminClientCAFile,
+		"CA for admin server client certificates (enables mTLS)")
+	flags.StringVar(&cfg.GRPCClientCAFile, "grpc-client-ca-file", cfg.GRPCClientCAFile,
+		"CA for gRPC client certificates (enables mTLS)")
+}
+
+// splitList разбирает список через запятую, пустые элементы отбрасываются
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
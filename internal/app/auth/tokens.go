@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var logger = logging.Component("auth")
+
+var ErrInvalidToken = errors.New("invalid access token")
+
+// keysReloadInterval как часто перечитывать ключи из базы (и проверять, не пора ли ротировать)
+const keysReloadInterval = time.Minute
+
+// unknownKeyReloadInterval не чаще этого интервала перечитываем ключи, если встретили токен с незнакомым kid
+// (ключ мог выпустить другой экземпляр сервиса)
+const unknownKeyReloadInterval = time.Second * 5
+
+// TokenManager выпускает и проверяет access токены (JWT, HS256).
+// Ключи подписи хранятся в базе, чтобы все экземпляры сервиса могли проверять токены друг друга,
+// и кешируются в памяти: проверка токена не ходит в базу.
+// Новый ключ выпускается раз в rotation, старые ключи живут ещё accessTTL, чтобы выданные ими токены оставались валидны.
+type TokenManager struct {
+	repo      storage.Repository
+	accessTTL time.Duration
+	rotation  time.Duration
+
+	mu         sync.RWMutex
+	keys       map[string]storage.SigningKey
+	current    *storage.SigningKey
+	lastReload time.Time
+}
+
+func NewTokenManager(repo storage.Repository, accessTTL time.Duration, rotation time.Duration) *TokenManager {
+	return &TokenManager{
+		repo:      repo,
+		accessTTL: accessTTL,
+		rotation:  rotation,
+		keys:      make(map[string]storage.SigningKey),
+	}
+}
+
+// Start загружает (или создаёт) ключи и запускает их периодическую ротацию, останавливается по контексту
+func (m *TokenManager) Start(ctx context.Context) error {
+	if err := m.rotate(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(keysReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.rotate(ctx); err != nil {
+					logger.ErrorContext(ctx, "rotate signing keys error", "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *TokenManager) rotate(ctx context.Context) error {
+	keys, err := m.repo.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	// ключи отсортированы по created_at desc: первый - самый свежий
+	if len(keys) == 0 || time.Since(keys[0].CreatedAt) >= m.rotation {
+		key, err := newSigningKey(m.rotation + m.accessTTL)
+		if err != nil {
+			return err
+		}
+		if err = m.repo.CreateSigningKey(ctx, key); err != nil {
+			return err
+		}
+		logger.InfoContext(ctx, "new signing key created", "kid", key.KID)
+		keys = append([]storage.SigningKey{key}, keys...)
+	}
+
+	m.setKeys(keys)
+	return nil
+}
+
+func (m *TokenManager) reload(ctx context.Context) error {
+	keys, err := m.repo.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	m.setKeys(keys)
+	return nil
+}
+
+func (m *TokenManager) setKeys(keys []storage.SigningKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = make(map[string]storage.SigningKey, len(keys))
+	m.current = nil
+	for i, key := range keys {
+		m.keys[key.KID] = key
+		if m.current == nil || key.CreatedAt.After(m.current.CreatedAt) {
+			m.current = &keys[i]
+		}
+	}
+	m.lastReload = time.Now()
+}
+
+func newSigningKey(ttl time.Duration) (storage.SigningKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return storage.SigningKey{}, fmt.Errorf("generate signing key error: %w", err)
+	}
+	now := time.Now()
+	return storage.SigningKey{
+		KID:       uuid.New().String(),
+		Secret:    secret,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// AccessTTL время жизни выпускаемых access токенов
+func (m *TokenManager) AccessTTL() time.Duration {
+	return m.accessTTL
+}
+
+// IssueAccessToken выпускает подписанный текущим ключом access токен пользователя
+func (m *TokenManager) IssueAccessToken(userID int64) (string, error) {
+	m.mu.RLock()
+	key := m.current
+	m.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("no signing key")
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   strconv.FormatInt(userID, 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
+		ID:        uuid.New().String(),
+	})
+	token.Header["kid"] = key.KID
+
+	signed, err := token.SignedString(key.Secret)
+	if err != nil {
+		return "", fmt.Errorf("sign access token error: %w", err)
+	}
+	return signed, nil
+}
+
+// ParseAccessToken проверяет подпись и срок действия access токена и возвращает ID пользователя
+func (m *TokenManager) ParseAccessToken(ctx context.Context, tokenString string) (int64, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.lookupKey(ctx, kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		return key.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: wrong subject", ErrInvalidToken)
+	}
+	return userID, nil
+}
+
+func (m *TokenManager) lookupKey(ctx context.Context, kid string) (storage.SigningKey, bool) {
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	lastReload := m.lastReload
+	m.mu.RUnlock()
+	if ok || kid == "" || time.Since(lastReload) < unknownKeyReloadInterval {
+		return key, ok
+	}
+
+	if err := m.reload(ctx); err != nil {
+		logger.ErrorContext(ctx, "reload signing keys error", "error", err)
+		return key, false
+	}
+	m.mu.RLock()
+	key, ok = m.keys[kid]
+	m.mu.RUnlock()
+	return key, ok
+}
@@ -1,7 +1,213 @@
 package config
 
+import "time"
+
+// Config настройки сервиса. Источники по возрастанию приоритета: значения по умолчанию (envDefault),
+// файл настроек (ключи - имена переменных окружения в нижнем регистре), переменные окружения
+// (или NAME_FILE с путём к файлу со значением), флаги. Поля с тегом secret не печатаются,
+// поля с тегом reload применяются без перезапуска (SIGHUP или POST /config/reload на служебном порту).
 type Config struct {
 	RunAddress           string `env:"RUN_ADDRESS" envDefault:"localhost:8080"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	DatabaseURI          string `env:"DATABASE_URI" secret:"true"`
+	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS" reload:"true"`
+
+	// DatabasePassword пароль к базе, заменяет пароль из DatabaseURI (удобно задавать через DATABASE_PASSWORD_FILE)
+	DatabasePassword string `env:"DATABASE_PASSWORD" secret:"true"`
+	// DatabaseMaxConns размер пула соединений с базой (0 - по числу CPU, но не меньше 4)
+	DatabaseMaxConns int `env:"DATABASE_MAX_CONNS" envDefault:"0"`
+	// BcryptCost сложность bcrypt для новых хэшей паролей (существующие хэши проверяются со своей)
+	BcryptCost int `env:"BCRYPT_COST" envDefault:"14"`
+
+	// CheckerPollLimit сколько заказов OrderChecker выбирает из базы за раз
+	CheckerPollLimit int `env:"CHECKER_POLL_LIMIT" envDefault:"10" reload:"true"`
+	// CheckerPollInterval пауза OrderChecker, когда все заказы на проверку уже пройдены
+	CheckerPollInterval time.Duration `env:"CHECKER_POLL_INTERVAL" envDefault:"1s" reload:"true"`
+	// CheckerQueueSize ёмкость очередей заказов на проверку и статусов на сохранение
+	CheckerQueueSize int `env:"CHECKER_QUEUE_SIZE" envDefault:"10"`
+	// CheckerBatchSize сколько статусов сохранять одним запросом
+	CheckerBatchSize int `env:"CHECKER_BATCH_SIZE" envDefault:"10"`
+	// CheckerFlushInterval через сколько сохранять неполную пачку статусов
+	CheckerFlushInterval time.Duration `env:"CHECKER_FLUSH_INTERVAL" envDefault:"2s"`
+	// CheckerConcurrency сколько заказов OrderChecker проверяет в системе расчёта баллов одновременно
+	CheckerConcurrency int `env:"CHECKER_CONCURRENCY" envDefault:"1" reload:"true"`
+	// AccrualRetryCount сколько раз повторять неудачный запрос в систему расчёта баллов
+	AccrualRetryCount int `env:"ACCRUAL_RETRY_COUNT" envDefault:"2" reload:"true"`
+
+	// PointsExpiryMonths через сколько месяцев после начисления сгорают баллы (для новых начислений)
+	PointsExpiryMonths int `env:"POINTS_EXPIRY_MONTHS" envDefault:"12"`
+	// PointsExpiryInterval как часто сжигать просроченные баллы
+	PointsExpiryInterval time.Duration `env:"POINTS_EXPIRY_INTERVAL" envDefault:"1h"`
+	// PointsExpiryBatchSize скольких пользователей обрабатывать в одной транзакции сжигания
+	PointsExpiryBatchSize int `env:"POINTS_EXPIRY_BATCH_SIZE" envDefault:"1000"`
+
+	// Ограничения списаний: минимальная сумма, максимум за одно списание, за календарный день и месяц (UTC);
+	// 0 - без ограничения. Сотрудники могут задать пользователю персональные ограничения.
+	WithdrawalMin               float64 `env:"WITHDRAWAL_MIN" envDefault:"0"`
+	WithdrawalMaxPerTransaction float64 `env:"WITHDRAWAL_MAX_PER_TRANSACTION" envDefault:"0"`
+	WithdrawalMaxPerDay         float64 `env:"WITHDRAWAL_MAX_PER_DAY" envDefault:"0"`
+	WithdrawalMaxPerMonth       float64 `env:"WITHDRAWAL_MAX_PER_MONTH" envDefault:"0"`
+
+	// Правила антифрода для загрузки заказов и списаний: нулевой порог отключает правило,
+	// *_ACTION - что делать при срабатывании: flag (пропустить и отметить для проверки сотрудником) или block (отклонить).
+	// FraudUploadVelocityLimit сколько номеров заказов пользователь может загрузить за FRAUD_UPLOAD_VELOCITY_WINDOW
+	FraudUploadVelocityLimit  int           `env:"FRAUD_UPLOAD_VELOCITY_LIMIT" envDefault:"30"`
+	FraudUploadVelocityWindow time.Duration `env:"FRAUD_UPLOAD_VELOCITY_WINDOW" envDefault:"1h"`
+	FraudUploadVelocityAction string        `env:"FRAUD_UPLOAD_VELOCITY_ACTION" envDefault:"block"`
+	// FraudConflictLimit сколько раз за FRAUD_CONFLICT_WINDOW можно получить 409 на чужой номер заказа
+	// (перебор номеров других пользователей)
+	FraudConflictLimit  int           `env:"FRAUD_CONFLICT_LIMIT" envDefault:"5"`
+	FraudConflictWindow time.Duration `env:"FRAUD_CONFLICT_WINDOW" envDefault:"1h"`
+	FraudConflictAction string        `env:"FRAUD_CONFLICT_ACTION" envDefault:"block"`
+	// FraudNewAccountHold сколько после регистрации срабатывает правило на списания
+	FraudNewAccountHold   time.Duration `env:"FRAUD_NEW_ACCOUNT_HOLD" envDefault:"24h"`
+	FraudNewAccountAction string        `env:"FRAUD_NEW_ACCOUNT_ACTION" envDefault:"block"`
+	// FraudAccrualCooldown сколько после начисления баллов срабатывает правило на списания
+	FraudAccrualCooldown       time.Duration `env:"FRAUD_ACCRUAL_COOLDOWN" envDefault:"10m"`
+	FraudAccrualCooldownAction string        `env:"FRAUD_ACCRUAL_COOLDOWN_ACTION" envDefault:"flag"`
+
+	// Уровни программы лояльности: пороги серебряного и золотого уровней по сумме начислений системы расчёта
+	// за 12 месяцев и множители начислений на каждом уровне. Уровень пересчитывается при начислении
+	// и ежедневно в TIER_RECALC_AT (ЧЧ:ММ по UTC).
+	TierSilverThreshold  float64 `env:"TIER_SILVER_THRESHOLD" envDefault:"1000"`
+	TierGoldThreshold    float64 `env:"TIER_GOLD_THRESHOLD" envDefault:"5000"`
+	TierBronzeMultiplier float64 `env:"TIER_BRONZE_MULTIPLIER" envDefault:"1"`
+	TierSilverMultiplier float64 `env:"TIER_SILVER_MULTIPLIER" envDefault:"1.1"`
+	TierGoldMultiplier   float64 `env:"TIER_GOLD_MULTIPLIER" envDefault:"1.25"`
+	TierRecalcAt         string  `env:"TIER_RECALC_AT" envDefault:"03:00"`
+	// TierRecalcBatchSize скольких пользователей пересчитывать одним запросом
+	TierRecalcBatchSize int `env:"TIER_RECALC_BATCH_SIZE" envDefault:"1000"`
+
+	// Реферальная программа: бонусы пригласившему и приглашённому за первый обработанный заказ приглашённого;
+	// ReferralMaxPerReferrer сколько приглашений одного пользователя вознаграждается (0 - без ограничения)
+	ReferralReferrerBonus  float64 `env:"REFERRAL_REFERRER_BONUS" envDefault:"100"`
+	ReferralReferredBonus  float64 `env:"REFERRAL_REFERRED_BONUS" envDefault:"50"`
+	ReferralMaxPerReferrer int     `env:"REFERRAL_MAX_PER_REFERRER" envDefault:"20"`
+
+	// CORSAllowedOrigins origin фронтенда, которым разрешены запросы из браузера (пустой список - только same-origin).
+	// Изменяющие запросы с cookie auth принимаются только с этих origin или с того же origin (защита от CSRF).
+	CORSAllowedOrigins []string `env:"CORS_ALLOWED_ORIGINS" envSeparator:","`
+	// CORSAllowCredentials разрешить браузеру отправлять cookie в cross-origin запросах
+	CORSAllowCredentials bool `env:"CORS_ALLOW_CREDENTIALS" envDefault:"true"`
+	// CORSMaxAge сколько браузер может кэшировать ответ на preflight запрос
+	CORSMaxAge time.Duration `env:"CORS_MAX_AGE" envDefault:"10m"`
+
+	// TrustedProxies адреса и подсети (CIDR) обратных прокси, которым можно верить в X-Forwarded-For и X-Real-IP.
+	// Адрес клиента (ограничение попыток входа, сессии, антифрод) берётся из заголовков, только если запрос пришёл
+	// с такого адреса; пустой список - заголовки игнорируются, используется адрес соединения.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+
+	// CompressMinSize ответы короче (в байтах) не сжимаются
+	CompressMinSize int `env:"COMPRESS_MIN_SIZE" envDefault:"1024"`
+	// CompressContentTypes какие типы ответов сжимать (пустой список - любые)
+	CompressContentTypes []string `env:"COMPRESS_CONTENT_TYPES" envSeparator:"," envDefault:"application/json,application/x-ndjson,application/x-ofx,application/xml,text/csv,text/plain,text/html"`
+	// MaxDecompressedRequestSize максимальный размер тела запроса после распаковки (защита от zip-бомб)
+	MaxDecompressedRequestSize int64 `env:"MAX_DECOMPRESSED_REQUEST_SIZE" envDefault:"1048576"`
+
+	// AdminAddress адрес служебного API (метрики), не должен быть доступен снаружи (пустой - не запускается)
+	AdminAddress string `env:"ADMIN_ADDRESS" envDefault:"localhost:9090"`
+
+	// ReadinessCacheTTL сколько переиспользовать результат проверок /readyz
+	ReadinessCacheTTL time.Duration `env:"READINESS_CACHE_TTL" envDefault:"2s"`
+	// ReadinessTimeout общий таймаут проверок /readyz
+	ReadinessTimeout time.Duration `env:"READINESS_TIMEOUT" envDefault:"3s"`
+	// CheckerStaleAfter сколько запросы в систему расчёта или сохранение статусов могут не проходить,
+	// прежде чем /readyz начнёт отвечать 503
+	CheckerStaleAfter time.Duration `env:"CHECKER_STALE_AFTER" envDefault:"5m"`
+	// ShutdownDrainDelay сколько после SIGTERM отвечать 503 на /readyz, продолжая обслуживать запросы,
+	// чтобы балансировщик успел убрать экземпляр
+	ShutdownDrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" envDefault:"5s"`
+	// ShutdownTimeout сколько ждать завершения текущих запросов при остановке
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+
+	// GRPCAddress адрес gRPC API для внутренних сервисов (пустой - gRPC сервер не запускается)
+	GRPCAddress string `env:"GRPC_ADDRESS" envDefault:"localhost:8090"`
+	// GRPCWatchInterval как часто OrderService.WatchOrders проверяет изменения заказов
+	GRPCWatchInterval time.Duration `env:"GRPC_WATCH_INTERVAL" envDefault:"2s"`
+
+	// SessionTTL время жизни сессии (и cookie auth)
+	SessionTTL time.Duration `env:"SESSION_TTL" envDefault:"10h"`
+	// SessionSliding продлевает сессию на SessionTTL при каждом запросе
+	SessionSliding bool `env:"SESSION_SLIDING" envDefault:"true"`
+	// CookieSecure выставляет флаг Secure у cookie auth. С TLS_CERT_FILE флаг выставляется всегда;
+	// выключать его стоит только для разработки по plain http (см. Warnings)
+	CookieSecure bool `env:"COOKIE_SECURE" envDefault:"true"`
+	// CookieSameSite режим SameSite cookie auth: lax, strict или none (для фронтенда на другом сайте, требует Secure)
+	CookieSameSite string `env:"COOKIE_SAME_SITE" envDefault:"lax"`
+
+	// AccessTokenTTL время жизни access токена (JWT) для авторизации через заголовок Authorization: Bearer
+	AccessTokenTTL time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m"`
+	// RefreshTokenTTL время жизни refresh токена
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h"`
+	// SigningKeyRotation как часто выпускать новый ключ подписи access токенов
+	SigningKeyRotation time.Duration `env:"SIGNING_KEY_ROTATION" envDefault:"24h"`
+
+	// LoginRateLimitPerIP сколько попыток входа/регистрации допускается с одного IP за LoginRateLimitWindow (0 - без ограничений)
+	LoginRateLimitPerIP int `env:"LOGIN_RATE_LIMIT_PER_IP" envDefault:"30" reload:"true"`
+	// LoginRateLimitPerLogin сколько попыток входа допускается для одного логина за LoginRateLimitWindow (0 - без ограничений)
+	LoginRateLimitPerLogin int `env:"LOGIN_RATE_LIMIT_PER_LOGIN" envDefault:"10" reload:"true"`
+	// LoginRateLimitWindow окно, за которое восстанавливается весь лимит попыток
+	LoginRateLimitWindow time.Duration `env:"LOGIN_RATE_LIMIT_WINDOW" envDefault:"1m" reload:"true"`
+	// RateLimitStore где хранить счётчики: memory - в памяти процесса, postgres - общие для всех экземпляров
+	RateLimitStore string `env:"RATE_LIMIT_STORE" envDefault:"memory"`
+	// LoginMaxFailures после скольких неудачных попыток входа подряд блокировать пользователя (0 - не блокировать)
+	LoginMaxFailures int `env:"LOGIN_MAX_FAILURES" envDefault:"10"`
+	// LoginLockDuration на сколько блокировать пользователя
+	LoginLockDuration time.Duration `env:"LOGIN_LOCK_DURATION" envDefault:"15m"`
+	// BcryptConcurrency сколько вычислений bcrypt может идти одновременно (0 - по числу CPU)
+	BcryptConcurrency int `env:"BCRYPT_CONCURRENCY" envDefault:"0"`
+	// BcryptQueueTimeout сколько запрос может ждать своей очереди на bcrypt
+	BcryptQueueTimeout time.Duration `env:"BCRYPT_QUEUE_TIMEOUT" envDefault:"2s"`
+
+	// Правила для логина и пароля при регистрации (длины - 0 без ограничения, шаблон - пустой без ограничения).
+	// Логин перед проверкой приводится к нижнему регистру.
+	LoginMinLength    int    `env:"LOGIN_MIN_LENGTH" envDefault:"3"`
+	LoginMaxLength    int    `env:"LOGIN_MAX_LENGTH" envDefault:"64"`
+	LoginPattern      string `env:"LOGIN_PATTERN" envDefault:"^[a-z0-9][a-z0-9._@-]*$"`
+	PasswordMinLength int    `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	// PasswordMaxLength в байтах, bcrypt всё равно учитывает только первые 72 байта
+	PasswordMaxLength int `env:"PASSWORD_MAX_LENGTH" envDefault:"72"`
+	// PasswordRejectCommon запрещать пароли из встроенного списка самых распространённых
+	PasswordRejectCommon bool `env:"PASSWORD_REJECT_COMMON" envDefault:"true"`
+
+	// LogFormat формат логов: json или logfmt
+	LogFormat string `env:"LOG_FORMAT" envDefault:"logfmt"`
+	// LogLevel уровень логов по умолчанию: debug, info, warn или error
+	LogLevel string `env:"LOG_LEVEL" envDefault:"info" reload:"true"`
+	// LogLevels уровни отдельных компонентов через запятую, например "storage=debug,order_checker=warn";
+	// меняются на лету через служебный порт (/log/levels), перечитывание настроек сбрасывает такие изменения
+	LogLevels string `env:"LOG_LEVELS" reload:"true"`
+
+	// TracingExporter куда отправлять спаны OpenTelemetry: none, otlp, stdout или file
+	TracingExporter string `env:"TRACING_EXPORTER" envDefault:"none"`
+	// TracingEndpoint адрес OTLP коллектора (пустой - из OTEL_EXPORTER_OTLP_ENDPOINT или localhost:4317)
+	TracingEndpoint string `env:"TRACING_ENDPOINT"`
+	// TracingProtocol протокол OTLP: grpc или http
+	TracingProtocol string `env:"TRACING_PROTOCOL" envDefault:"grpc"`
+	// TracingInsecure подключаться к OTLP коллектору без TLS
+	TracingInsecure bool `env:"TRACING_INSECURE" envDefault:"true"`
+	// TracingFile файл для экспортёра file
+	TracingFile string `env:"TRACING_FILE" envDefault:"traces.json"`
+	// TracingSampleRatio доля сохраняемых трассировок от 0 до 1; если вызывающий передал traceparent,
+	// решение берётся из него
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+
+	// TLSCertFile и TLSKeyFile сертификат и ключ в PEM; если заданы, HTTP API, gRPC и служебный порт
+	// работают по TLS (HTTP/2 включается автоматически). Файлы перечитываются при изменении и по SIGHUP.
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+	// TLSMinVersion минимальная версия TLS: 1.2 или 1.3
+	TLSMinVersion string `env:"TLS_MIN_VERSION" envDefault:"1.2"`
+	// TLSCipherSuites шифры для TLS 1.2 через запятую (пустой - набор Go по умолчанию)
+	TLSCipherSuites []string `env:"TLS_CIPHER_SUITES" envSeparator:","`
+	// TLSReloadInterval как часто проверять изменение файлов сертификатов (0 - только по SIGHUP)
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" envDefault:"30s"`
+	// AdminClientCAFile CA клиентских сертификатов для служебного порта (mTLS), пустой - без проверки
+	AdminClientCAFile string `env:"ADMIN_CLIENT_CA_FILE"`
+	// GRPCClientCAFile CA клиентских сертификатов для gRPC API (mTLS), пустой - без проверки
+	GRPCClientCAFile string `env:"GRPC_CLIENT_CA_FILE"`
+}
+
+// AuthCookieSecure нужен ли флаг Secure у cookie auth: при TLS_CERT_FILE он нужен независимо от COOKIE_SECURE
+func (c Config) AuthCookieSecure() bool {
+	return c.CookieSecure || c.TLSCertFile != ""
 }
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEnv переменная окружения с путём к файлу настроек (флаг -config имеет приоритет)
+const FileEnv = "CONFIG_FILE"
+
+// fileSuffix суффикс переменной окружения, в которой вместо значения задан путь к файлу с ним
+// (секреты из docker/kubernetes secrets: DATABASE_PASSWORD_FILE=/run/secrets/db_password)
+const fileSuffix = "_FILE"
+
+// Load собирает настройки из значений по умолчанию, файла file (yaml, json или toml; пустой - без файла)
+// и переменных окружения. Флаги применяются поверх вызывающим.
+func Load(file string) (Config, error) {
+	cfg := Defaults()
+	if file != "" {
+		if err := applyFile(&cfg, file); err != nil {
+			return cfg, err
+		}
+	}
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Defaults настройки по умолчанию (из тегов envDefault)
+func Defaults() Config {
+	var cfg Config
+	for _, f := range fields(&cfg) {
+		if value, ok := f.field.Tag.Lookup("envDefault"); ok {
+			if err := f.set(value); err != nil {
+				// значения по умолчанию задаются в коде, ошибка в них - ошибка программиста
+				panic(fmt.Sprintf("config: wrong default for %s: %v", f.env, err))
+			}
+		}
+	}
+	return cfg
+}
+
+// Key ключ настройки в файле
+func Key(env string) string {
+	return strings.ToLower(env)
+}
+
+func applyFile(cfg *Config, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("cant read config file: %w", err)
+	}
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unknown config file format %q, expected .yaml, .yml, .json or .toml", filepath.Ext(file))
+	}
+	if err != nil {
+		return fmt.Errorf("cant parse config file %s: %w", file, err)
+	}
+
+	byKey := map[string]field{}
+	for _, f := range fields(cfg) {
+		byKey[Key(f.env)] = f
+	}
+	var errs []error
+	for key, value := range values {
+		f, ok := byKey[strings.ToLower(key)]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", file, key))
+			continue
+		}
+		if err := f.setFileValue(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", file, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	var errs []error
+	for _, f := range fields(cfg) {
+		value, ok := lookup(f.env)
+		path, fromFile := lookup(f.env + fileSuffix)
+		if ok && fromFile {
+			errs = append(errs, fmt.Errorf("%s and %s%s are both set", f.env, f.env, fileSuffix))
+			continue
+		}
+		if fromFile {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", f.env, fileSuffix, err))
+				continue
+			}
+			value, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+		if err := f.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// field поле Config с тегом env
+type field struct {
+	field reflect.StructField
+	value reflect.Value
+	env   string
+}
+
+func fields(cfg *Config) []field {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	result := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		env, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		result = append(result, field{field: t.Field(i), value: v.Field(i), env: env})
+	}
+	return result
+}
+
+func (f field) secret() bool {
+	return f.field.Tag.Get("secret") == "true"
+}
+
+// set значение в формате переменной окружения
+func (f field) set(value string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		f.value.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected duration like 30s, 5m or 1h, got %q", value)
+		}
+		f.value.SetInt(int64(d))
+	case int, int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected integer, got %q", value)
+		}
+		f.value.SetInt(n)
+	case float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected number, got %q", value)
+		}
+		f.value.SetFloat(n)
+	case []string:
+		separator := f.field.Tag.Get("envSeparator")
+		var items []string
+		for _, item := range strings.Split(value, separator) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config field type %s", f.value.Type())
+	}
+	return nil
+}
+
+// setFileValue значение из файла: скаляр или список для []string
+func (f field) setFileValue(value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		if _, isList := f.value.Interface().([]string); !isList {
+			return errors.New("expected single value, got list")
+		}
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, scalarString(item))
+		}
+		f.value.Set(reflect.ValueOf(items))
+		return nil
+	}
+	if value == nil {
+		return errors.New("empty value")
+	}
+	return f.set(scalarString(value))
+}
+
+func scalarString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		// числа из json всегда float64, 1048576 не должно превратиться в 1.048576e+06
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
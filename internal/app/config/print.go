@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Print печатает действующие настройки в формате файла настроек (yaml), секреты скрыты
+func Print(w io.Writer, cfg Config) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range fields(&cfg) {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: Key(f.env)}
+		root.Content = append(root.Content, key, printValue(f))
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return fmt.Errorf("cant print config: %w", err)
+	}
+	return encoder.Close()
+}
+
+func printValue(f field) *yaml.Node {
+	scalar := func(value string, tag string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: tag}
+	}
+	if f.secret() {
+		value := f.value.String()
+		switch {
+		case value == "":
+		case f.env == "DATABASE_URI":
+			// в строке подключения секрет только пароль, остальное нужно для диагностики
+			value = logging.RedactURL(value)
+		default:
+			value = logging.Redacted
+		}
+		return scalar(value, "!!str")
+	}
+	switch v := f.value.Interface().(type) {
+	case time.Duration:
+		return scalar(v.String(), "!!str")
+	case bool:
+		return scalar(strconv.FormatBool(v), "!!bool")
+	case int, int64:
+		return scalar(strconv.FormatInt(f.value.Int(), 10), "!!int")
+	case float64:
+		return scalar(strconv.FormatFloat(v, 'f', -1, 64), "!!float")
+	case []string:
+		list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range v {
+			list.Content = append(list.Content, scalar(item, "!!str"))
+		}
+		return list
+	default:
+		return scalar(f.value.String(), "!!str")
+	}
+}
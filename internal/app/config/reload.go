@@ -0,0 +1,34 @@
+package config
+
+import (
+	"reflect"
+)
+
+// Changes имена настроек, которые отличаются в next от current: reloadable применяются на лету
+// (поля с тегом reload), restart вступят в силу только после перезапуска
+func Changes(current Config, next Config) (reloadable []string, restart []string) {
+	currentFields := fields(&current)
+	for i, f := range fields(&next) {
+		if reflect.DeepEqual(f.value.Interface(), currentFields[i].value.Interface()) {
+			continue
+		}
+		if f.field.Tag.Get("reload") == "true" {
+			reloadable = append(reloadable, f.env)
+		} else {
+			restart = append(restart, f.env)
+		}
+	}
+	return reloadable, restart
+}
+
+// Reloadable настройки current, в которых значения, применяемые на лету, взяты из next
+func Reloadable(current Config, next Config) Config {
+	result := current
+	nextFields := fields(&next)
+	for i, f := range fields(&result) {
+		if f.field.Tag.Get("reload") == "true" {
+			f.value.Set(nextFields[i].value)
+		}
+	}
+	return result
+}
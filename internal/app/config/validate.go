@@ -0,0 +1,241 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Warnings возвращает допустимые, но небезопасные сочетания настроек, о которых стоит предупредить при запуске
+func (c Config) Warnings() []string {
+	var warnings []string
+	if !c.AuthCookieSecure() {
+		warnings = append(warnings, "COOKIE_SECURE=false without TLS_CERT_FILE: auth cookie is sent over plain http, use only for development")
+	}
+	return warnings
+}
+
+// Validate проверяет все настройки и возвращает все найденные ошибки сразу,
+// каждая - с именем переменной окружения (ключ в файле - то же имя в нижнем регистре)
+func (c Config) Validate() error {
+	v := &validator{}
+
+	v.address("RUN_ADDRESS", c.RunAddress, true)
+	v.address("ADMIN_ADDRESS", c.AdminAddress, false)
+	v.address("GRPC_ADDRESS", c.GRPCAddress, false)
+	v.check("DATABASE_URI", c.DatabaseURI != "", "required")
+	if c.AccrualSystemAddress == "" {
+		v.fail("ACCRUAL_SYSTEM_ADDRESS", "required")
+	} else if u, err := url.Parse(c.AccrualSystemAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail("ACCRUAL_SYSTEM_ADDRESS", "must be http(s) URL like http://localhost:8081, got %q", c.AccrualSystemAddress)
+	}
+	v.min("DATABASE_MAX_CONNS", c.DatabaseMaxConns, 0)
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		v.fail("BCRYPT_COST", "must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.BcryptCost)
+	}
+
+	v.min("CHECKER_POLL_LIMIT", c.CheckerPollLimit, 1)
+	v.positive("CHECKER_POLL_INTERVAL", c.CheckerPollInterval)
+	v.min("CHECKER_QUEUE_SIZE", c.CheckerQueueSize, 1)
+	v.min("CHECKER_BATCH_SIZE", c.CheckerBatchSize, 1)
+	v.positive("CHECKER_FLUSH_INTERVAL", c.CheckerFlushInterval)
+	v.min("CHECKER_CONCURRENCY", c.CheckerConcurrency, 1)
+	v.min("ACCRUAL_RETRY_COUNT", c.AccrualRetryCount, 0)
+	v.positive("CHECKER_STALE_AFTER", c.CheckerStaleAfter)
+	v.min("POINTS_EXPIRY_MONTHS", c.PointsExpiryMonths, 1)
+	v.positive("POINTS_EXPIRY_INTERVAL", c.PointsExpiryInterval)
+	v.min("POINTS_EXPIRY_BATCH_SIZE", c.PointsExpiryBatchSize, 1)
+	v.amount("WITHDRAWAL_MIN", c.WithdrawalMin)
+	v.amount("WITHDRAWAL_MAX_PER_TRANSACTION", c.WithdrawalMaxPerTransaction)
+	v.amount("WITHDRAWAL_MAX_PER_DAY", c.WithdrawalMaxPerDay)
+	v.amount("WITHDRAWAL_MAX_PER_MONTH", c.WithdrawalMaxPerMonth)
+	v.check("WITHDRAWAL_MIN", c.WithdrawalMaxPerTransaction == 0 || c.WithdrawalMin <= c.WithdrawalMaxPerTransaction,
+		"must not exceed WITHDRAWAL_MAX_PER_TRANSACTION (%v), got %v", c.WithdrawalMaxPerTransaction, c.WithdrawalMin)
+	v.min("FRAUD_UPLOAD_VELOCITY_LIMIT", c.FraudUploadVelocityLimit, 0)
+	v.positive("FRAUD_UPLOAD_VELOCITY_WINDOW", c.FraudUploadVelocityWindow)
+	v.oneOf("FRAUD_UPLOAD_VELOCITY_ACTION", c.FraudUploadVelocityAction, "flag", "block")
+	v.min("FRAUD_CONFLICT_LIMIT", c.FraudConflictLimit, 0)
+	v.positive("FRAUD_CONFLICT_WINDOW", c.FraudConflictWindow)
+	v.oneOf("FRAUD_CONFLICT_ACTION", c.FraudConflictAction, "flag", "block")
+	v.nonNegative("FRAUD_NEW_ACCOUNT_HOLD", c.FraudNewAccountHold)
+	v.oneOf("FRAUD_NEW_ACCOUNT_ACTION", c.FraudNewAccountAction, "flag", "block")
+	v.nonNegative("FRAUD_ACCRUAL_COOLDOWN", c.FraudAccrualCooldown)
+	v.oneOf("FRAUD_ACCRUAL_COOLDOWN_ACTION", c.FraudAccrualCooldownAction, "flag", "block")
+	v.check("TIER_SILVER_THRESHOLD", c.TierSilverThreshold > 0, "must be positive, got %v", c.TierSilverThreshold)
+	v.check("TIER_GOLD_THRESHOLD", c.TierGoldThreshold > c.TierSilverThreshold,
+		"must exceed TIER_SILVER_THRESHOLD (%v), got %v", c.TierSilverThreshold, c.TierGoldThreshold)
+	v.check("TIER_BRONZE_MULTIPLIER", c.TierBronzeMultiplier > 0, "must be positive, got %v", c.TierBronzeMultiplier)
+	v.check("TIER_SILVER_MULTIPLIER", c.TierSilverMultiplier > 0, "must be positive, got %v", c.TierSilverMultiplier)
+	v.check("TIER_GOLD_MULTIPLIER", c.TierGoldMultiplier > 0, "must be positive, got %v", c.TierGoldMultiplier)
+	if _, err := time.Parse("15:04", c.TierRecalcAt); err != nil {
+		v.fail("TIER_RECALC_AT", "must be time of day HH:MM, got %q", c.TierRecalcAt)
+	}
+	v.min("TIER_RECALC_BATCH_SIZE", c.TierRecalcBatchSize, 1)
+	v.amount("REFERRAL_REFERRER_BONUS", c.ReferralReferrerBonus)
+	v.amount("REFERRAL_REFERRED_BONUS", c.ReferralReferredBonus)
+	v.min("REFERRAL_MAX_PER_REFERRER", c.ReferralMaxPerReferrer, 0)
+
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			v.fail("CORS_ALLOWED_ORIGINS", "origin must be scheme://host[:port] or *, got %q", origin)
+		}
+	}
+	v.nonNegative("CORS_MAX_AGE", c.CORSMaxAge)
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		v.fail("TRUSTED_PROXIES", "%v", err)
+	}
+	v.min("COMPRESS_MIN_SIZE", c.CompressMinSize, 0)
+	v.check("MAX_DECOMPRESSED_REQUEST_SIZE", c.MaxDecompressedRequestSize > 0, "must be positive, got %d",
+		c.MaxDecompressedRequestSize)
+
+	v.nonNegative("READINESS_CACHE_TTL", c.ReadinessCacheTTL)
+	v.positive("READINESS_TIMEOUT", c.ReadinessTimeout)
+	v.nonNegative("SHUTDOWN_DRAIN_DELAY", c.ShutdownDrainDelay)
+	v.positive("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	v.positive("GRPC_WATCH_INTERVAL", c.GRPCWatchInterval)
+
+	v.positive("SESSION_TTL", c.SessionTTL)
+	v.oneOf("COOKIE_SAME_SITE", c.CookieSameSite, "lax", "strict", "none")
+	if strings.EqualFold(c.CookieSameSite, "none") && !c.AuthCookieSecure() {
+		v.fail("COOKIE_SAME_SITE", "none requires COOKIE_SECURE=true or TLS_CERT_FILE, browsers reject such cookies otherwise")
+	}
+	v.positive("ACCESS_TOKEN_TTL", c.AccessTokenTTL)
+	v.positive("REFRESH_TOKEN_TTL", c.RefreshTokenTTL)
+	v.positive("SIGNING_KEY_ROTATION", c.SigningKeyRotation)
+
+	v.min("LOGIN_RATE_LIMIT_PER_IP", c.LoginRateLimitPerIP, 0)
+	v.min("LOGIN_RATE_LIMIT_PER_LOGIN", c.LoginRateLimitPerLogin, 0)
+	v.positive("LOGIN_RATE_LIMIT_WINDOW", c.LoginRateLimitWindow)
+	v.oneOf("RATE_LIMIT_STORE", c.RateLimitStore, "memory", "postgres")
+	v.min("LOGIN_MAX_FAILURES", c.LoginMaxFailures, 0)
+	v.positive("LOGIN_LOCK_DURATION", c.LoginLockDuration)
+	v.min("BCRYPT_CONCURRENCY", c.BcryptConcurrency, 0)
+	v.positive("BCRYPT_QUEUE_TIMEOUT", c.BcryptQueueTimeout)
+
+	v.min("LOGIN_MIN_LENGTH", c.LoginMinLength, 0)
+	v.min("LOGIN_MAX_LENGTH", c.LoginMaxLength, 0)
+	v.check("LOGIN_MAX_LENGTH", c.LoginMaxLength == 0 || c.LoginMaxLength >= c.LoginMinLength,
+		"must not be less than LOGIN_MIN_LENGTH (%d), got %d", c.LoginMinLength, c.LoginMaxLength)
+	if _, err := regexp.Compile(c.LoginPattern); err != nil {
+		v.fail("LOGIN_PATTERN", "wrong regexp: %v", err)
+	}
+	v.min("PASSWORD_MIN_LENGTH", c.PasswordMinLength, 0)
+	v.min("PASSWORD_MAX_LENGTH", c.PasswordMaxLength, 0)
+	v.check("PASSWORD_MAX_LENGTH", c.PasswordMaxLength == 0 || c.PasswordMaxLength >= c.PasswordMinLength,
+		"must not be less than PASSWORD_MIN_LENGTH (%d), got %d", c.PasswordMinLength, c.PasswordMaxLength)
+
+	v.oneOf("LOG_FORMAT", c.LogFormat, "json", "logfmt")
+	v.oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	for _, item := range strings.Split(c.LogLevels, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		component, level, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(component) == "" {
+			v.fail("LOG_LEVELS", "expected component=level, got %q", item)
+			continue
+		}
+		v.oneOf("LOG_LEVELS", strings.TrimSpace(level), "debug", "info", "warn", "error")
+	}
+
+	v.oneOf("TRACING_EXPORTER", c.TracingExporter, "none", "otlp", "stdout", "file")
+	v.oneOf("TRACING_PROTOCOL", c.TracingProtocol, "grpc", "http", "http/protobuf")
+	v.check("TRACING_FILE", !strings.EqualFold(c.TracingExporter, "file") || c.TracingFile != "",
+		"required for TRACING_EXPORTER=file")
+	v.check("TRACING_SAMPLE_RATIO", c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1,
+		"must be between 0 and 1, got %v", c.TracingSampleRatio)
+
+	v.check("TLS_KEY_FILE", c.TLSCertFile == "" || c.TLSKeyFile != "", "required with TLS_CERT_FILE")
+	v.check("TLS_CERT_FILE", c.TLSKeyFile == "" || c.TLSCertFile != "", "required with TLS_KEY_FILE")
+	v.oneOf("TLS_MIN_VERSION", c.TLSMinVersion, "1.2", "1.3")
+	v.nonNegative("TLS_RELOAD_INTERVAL", c.TLSReloadInterval)
+	v.check("ADMIN_CLIENT_CA_FILE", c.AdminClientCAFile == "" || c.TLSCertFile != "", "requires TLS_CERT_FILE")
+	v.check("GRPC_CLIENT_CA_FILE", c.GRPCClientCAFile == "" || c.TLSCertFile != "", "requires TLS_CERT_FILE")
+
+	return v.err()
+}
+
+// ParseTrustedProxies разбирает TRUSTED_PROXIES: отдельный адрес считается подсетью из одного адреса
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("must be IP or CIDR like 10.0.0.0/8, got %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(env string, format string, args ...interface{}) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", env, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) check(env string, ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.fail(env, format, args...)
+	}
+}
+
+func (v *validator) min(env string, value int, min int) {
+	v.check(env, value >= min, "must be at least %d, got %d", min, value)
+}
+
+func (v *validator) positive(env string, value time.Duration) {
+	v.check(env, value > 0, "must be positive duration like 30s or 5m, got %s", value)
+}
+
+func (v *validator) nonNegative(env string, value time.Duration) {
+	v.check(env, value >= 0, "must not be negative, got %s", value)
+}
+
+func (v *validator) amount(env string, value float64) {
+	v.check(env, value >= 0, "must not be negative, got %v", value)
+}
+
+func (v *validator) oneOf(env string, value string, allowed ...string) {
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return
+		}
+	}
+	v.fail(env, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+func (v *validator) address(env string, value string, required bool) {
+	if value == "" {
+		v.check(env, !required, "required")
+		return
+	}
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		v.fail(env, "must be host:port, got %q", value)
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n%w", errors.Join(v.errs...))
+}
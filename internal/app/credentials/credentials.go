@@ -0,0 +1,172 @@
+package credentials
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(file string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
+
+// Коды ошибок проверки
+const (
+	CodeRequired = "required"
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeInvalid  = "invalid_characters"
+	CodeCommon   = "too_common"
+	CodeSameAs   = "same_as_login"
+	CodeNotFound = "not_found"
+)
+
+// FieldError ошибка проверки одного поля
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError все ошибки проверки учётных данных
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fieldError.Field+": "+fieldError.Message)
+	}
+	return "invalid credentials: " + strings.Join(messages, "; ")
+}
+
+// NewValidationError ошибка проверки одного поля, например неизвестного реферального кода
+func NewValidationError(field string, code string, message string) *ValidationError {
+	return &ValidationError{Errors: []FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+func (e *ValidationError) add(field string, code string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Policy правила для логина и пароля
+type Policy struct {
+	LoginMinLength    int
+	LoginMaxLength    int
+	LoginPattern      *regexp.Regexp
+	PasswordMinLength int
+	PasswordMaxLength int
+	RejectCommon      bool
+}
+
+func NewPolicy(loginMinLength int, loginMaxLength int, loginPattern string,
+	passwordMinLength int, passwordMaxLength int, rejectCommon bool) (*Policy, error) {
+
+	var pattern *regexp.Regexp
+	if loginPattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(loginPattern); err != nil {
+			return nil, fmt.Errorf("wrong login pattern %q: %w", loginPattern, err)
+		}
+	}
+	return &Policy{
+		LoginMinLength:    loginMinLength,
+		LoginMaxLength:    loginMaxLength,
+		LoginPattern:      pattern,
+		PasswordMinLength: passwordMinLength,
+		PasswordMaxLength: passwordMaxLength,
+		RejectCommon:      rejectCommon,
+	}, nil
+}
+
+// NormalizeLogin приводит логин к каноническому виду: логины, отличающиеся регистром
+// или пробелами по краям, считаются одним логином
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// ValidateLogin проверяет уже нормализованный логин
+func (p *Policy) ValidateLogin(login string) *ValidationError {
+	verr := &ValidationError{}
+	p.validateLogin(verr, login)
+	return verr.orNil()
+}
+
+// ValidatePassword проверяет пароль (login нужен, чтобы не допустить пароль, совпадающий с логином)
+func (p *Policy) ValidatePassword(login string, password string) *ValidationError {
+	verr := &ValidationError{}
+	p.validatePassword(verr, login, password)
+	return verr.orNil()
+}
+
+// Validate проверяет пару логин/пароль при регистрации
+func (p *Policy) Validate(login string, password string) *ValidationError {
+	verr := &ValidationError{}
+	p.validateLogin(verr, login)
+	p.validatePassword(verr, login, password)
+	return verr.orNil()
+}
+
+func (e *ValidationError) orNil() *ValidationError {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (p *Policy) validateLogin(verr *ValidationError, login string) {
+	length := utf8.RuneCountInString(login)
+	switch {
+	case length == 0:
+		verr.add("login", CodeRequired, "login is required")
+		return
+	case p.LoginMinLength > 0 && length < p.LoginMinLength:
+		verr.add("login", CodeTooShort, "login must be at least %d characters", p.LoginMinLength)
+	case p.LoginMaxLength > 0 && length > p.LoginMaxLength:
+		verr.add("login", CodeTooLong, "login must be at most %d characters", p.LoginMaxLength)
+	}
+	if p.LoginPattern != nil && !p.LoginPattern.MatchString(login) {
+		verr.add("login", CodeInvalid, "login must match %s", p.LoginPattern.String())
+	}
+}
+
+func (p *Policy) validatePassword(verr *ValidationError, login string, password string) {
+	// bcrypt учитывает только первые 72 байта пароля, поэтому длину пароля ограничиваем в байтах
+	length := len(password)
+	switch {
+	case length == 0:
+		verr.add("password", CodeRequired, "password is required")
+		return
+	case p.PasswordMinLength > 0 && utf8.RuneCountInString(password) < p.PasswordMinLength:
+		verr.add("password", CodeTooShort, "password must be at least %d characters", p.PasswordMinLength)
+	case p.PasswordMaxLength > 0 && length > p.PasswordMaxLength:
+		verr.add("password", CodeTooLong, "password must be at most %d bytes", p.PasswordMaxLength)
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		verr.add("password", CodeSameAs, "password must not be the same as login")
+		return
+	}
+	if p.RejectCommon {
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			verr.add("password", CodeCommon, "password is too common")
+		}
+	}
+}
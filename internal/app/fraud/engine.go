@@ -0,0 +1,150 @@
+// Package fraud правила антифрода для загрузки заказов и списаний баллов
+package fraud
+
+import (
+	"context"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+var logger = logging.Component("fraud")
+
+// Event проверяемое действие пользователя
+type Event struct {
+	Action storage.FraudAction
+	UserID int64
+	// OrderNum номер загружаемого заказа или заказа, в счёт которого списываются баллы
+	OrderNum string
+	// Sum сумма списания
+	Sum float64
+	IP  string
+}
+
+// Rule правило антифрода. Check возвращает сработавшее правило или nil, если действие не подозрительно.
+type Rule interface {
+	Name() string
+	Check(ctx context.Context, event Event) (*storage.FraudRuleHit, error)
+}
+
+// Store данные, нужные правилам, и журнал решений
+type Store interface {
+	CountOrderUploads(ctx context.Context, userID int64, since time.Time) (int, error)
+	CountOrderConflicts(ctx context.Context, userID int64, since time.Time) (int, error)
+	GetUserCreatedAt(ctx context.Context, userID int64) (time.Time, error)
+	GetLastAccrualAt(ctx context.Context, userID int64) (*time.Time, error)
+	CreateFraudDecision(ctx context.Context, decision storage.FraudDecision) (int64, error)
+}
+
+// Engine проверяет действия пользователей правилами, зарегистрированными для этого действия.
+// Итог - самый строгий из результатов сработавших правил; отмеченные и отклонённые действия
+// сохраняются в журнал для проверки сотрудниками.
+type Engine struct {
+	store Store
+	rules map[storage.FraudAction][]Rule
+}
+
+// NewEngine создаёт пустой движок, правила добавляются через Add
+func NewEngine(store Store) *Engine {
+	return &Engine{store: store, rules: make(map[storage.FraudAction][]Rule)}
+}
+
+// NewDefaultEngine движок со стандартными правилами по настройкам cfg; правила с нулевым порогом не добавляются
+func NewDefaultEngine(cfg config.Config, store Store) *Engine {
+	e := NewEngine(store)
+	if cfg.FraudUploadVelocityLimit > 0 {
+		e.Add(storage.FraudActionOrderUpload, &UploadVelocity{
+			Store:   store,
+			Limit:   cfg.FraudUploadVelocityLimit,
+			Window:  cfg.FraudUploadVelocityWindow,
+			Outcome: outcome(cfg.FraudUploadVelocityAction),
+		})
+	}
+	if cfg.FraudConflictLimit > 0 {
+		e.Add(storage.FraudActionOrderUpload, &ConflictProbing{
+			Store:   store,
+			Limit:   cfg.FraudConflictLimit,
+			Window:  cfg.FraudConflictWindow,
+			Outcome: outcome(cfg.FraudConflictAction),
+		})
+	}
+	if cfg.FraudNewAccountHold > 0 {
+		e.Add(storage.FraudActionWithdrawal, &NewAccountHold{
+			Store:   store,
+			Hold:    cfg.FraudNewAccountHold,
+			Outcome: outcome(cfg.FraudNewAccountAction),
+		})
+	}
+	if cfg.FraudAccrualCooldown > 0 {
+		e.Add(storage.FraudActionWithdrawal, &AccrualCooldown{
+			Store:    store,
+			Cooldown: cfg.FraudAccrualCooldown,
+			Outcome:  outcome(cfg.FraudAccrualCooldownAction),
+		})
+	}
+	return e
+}
+
+func outcome(action string) storage.FraudOutcome {
+	return storage.FraudOutcome(strings.ToLower(action))
+}
+
+// Add регистрирует правило для действия action
+func (e *Engine) Add(action storage.FraudAction, rule Rule) {
+	e.rules[action] = append(e.rules[action], rule)
+}
+
+// Evaluate проверяет действие всеми его правилами. Ошибка правила или сохранения решения
+// не мешает пользователю: правило пропускается, действие не блокируется из-за сбоя базы.
+func (e *Engine) Evaluate(ctx context.Context, event Event) storage.FraudOutcome {
+	ctx, span := tracing.Start(ctx, "fraud", "evaluate fraud rules",
+		trace.WithAttributes(attribute.String("fraud.action", string(event.Action))))
+	defer span.End()
+
+	result := storage.FraudAllow
+	hits := []storage.FraudRuleHit{}
+	for _, rule := range e.rules[event.Action] {
+		hit, err := rule.Check(ctx, event)
+		if err != nil {
+			logger.ErrorContext(ctx, "fraud rule error", "rule", rule.Name(), "error", err)
+			continue
+		}
+		if hit == nil {
+			continue
+		}
+		hits = append(hits, *hit)
+		if hit.Outcome.Severity() > result.Severity() {
+			result = hit.Outcome
+		}
+	}
+	span.SetAttributes(attribute.String("fraud.outcome", string(result)))
+	metrics.FraudDecisions.WithLabelValues(string(event.Action), string(result)).Inc()
+	if result == storage.FraudAllow {
+		return result
+	}
+
+	decision := storage.FraudDecision{
+		UserID:   event.UserID,
+		Action:   event.Action,
+		OrderNum: event.OrderNum,
+		Outcome:  result,
+		Rules:    hits,
+		IP:       event.IP,
+	}
+	if event.Action == storage.FraudActionWithdrawal {
+		decision.Sum = &event.Sum
+	}
+	id, err := e.store.CreateFraudDecision(ctx, decision)
+	if err != nil {
+		logger.ErrorContext(ctx, "create fraud decision error", "error", err)
+	}
+	logger.WarnContext(ctx, "fraud rules fired",
+		"decision_id", id, "action", event.Action, "outcome", result, "rules", hits)
+	return result
+}
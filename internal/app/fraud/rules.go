@@ -0,0 +1,116 @@
+package fraud
+
+import (
+	"context"
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"time"
+)
+
+// UploadVelocity срабатывает, если пользователь загрузил Limit и больше номеров заказов за Window
+type UploadVelocity struct {
+	Store   Store
+	Limit   int
+	Window  time.Duration
+	Outcome storage.FraudOutcome
+}
+
+func (r *UploadVelocity) Name() string {
+	return "upload_velocity"
+}
+
+func (r *UploadVelocity) Check(ctx context.Context, event Event) (*storage.FraudRuleHit, error) {
+	count, err := r.Store.CountOrderUploads(ctx, event.UserID, time.Now().Add(-r.Window))
+	if err != nil {
+		return nil, err
+	}
+	if count < r.Limit {
+		return nil, nil
+	}
+	return &storage.FraudRuleHit{
+		Rule:    r.Name(),
+		Outcome: r.Outcome,
+		Reason:  fmt.Sprintf("%d orders uploaded in last %s, limit %d", count, r.Window, r.Limit),
+	}, nil
+}
+
+// ConflictProbing срабатывает, если пользователь Limit и больше раз за Window пытался загрузить
+// номер заказа другого пользователя: по ответам 409 можно узнать, какие номера уже загружены
+type ConflictProbing struct {
+	Store   Store
+	Limit   int
+	Window  time.Duration
+	Outcome storage.FraudOutcome
+}
+
+func (r *ConflictProbing) Name() string {
+	return "conflict_probing"
+}
+
+func (r *ConflictProbing) Check(ctx context.Context, event Event) (*storage.FraudRuleHit, error) {
+	count, err := r.Store.CountOrderConflicts(ctx, event.UserID, time.Now().Add(-r.Window))
+	if err != nil {
+		return nil, err
+	}
+	if count < r.Limit {
+		return nil, nil
+	}
+	return &storage.FraudRuleHit{
+		Rule:    r.Name(),
+		Outcome: r.Outcome,
+		Reason:  fmt.Sprintf("%d other users' orders uploaded in last %s, limit %d", count, r.Window, r.Limit),
+	}, nil
+}
+
+// NewAccountHold срабатывает на списания в течение Hold после регистрации
+type NewAccountHold struct {
+	Store   Store
+	Hold    time.Duration
+	Outcome storage.FraudOutcome
+}
+
+func (r *NewAccountHold) Name() string {
+	return "new_account_hold"
+}
+
+func (r *NewAccountHold) Check(ctx context.Context, event Event) (*storage.FraudRuleHit, error) {
+	createdAt, err := r.Store.GetUserCreatedAt(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+	until := createdAt.Add(r.Hold)
+	if !time.Now().Before(until) {
+		return nil, nil
+	}
+	return &storage.FraudRuleHit{
+		Rule:    r.Name(),
+		Outcome: r.Outcome,
+		Reason:  fmt.Sprintf("account registered at %s, withdrawals held until %s", createdAt.Format(time.RFC3339), until.Format(time.RFC3339)),
+	}, nil
+}
+
+// AccrualCooldown срабатывает на списания в течение Cooldown после последнего начисления баллов
+type AccrualCooldown struct {
+	Store    Store
+	Cooldown time.Duration
+	Outcome  storage.FraudOutcome
+}
+
+func (r *AccrualCooldown) Name() string {
+	return "accrual_cooldown"
+}
+
+func (r *AccrualCooldown) Check(ctx context.Context, event Event) (*storage.FraudRuleHit, error) {
+	accruedAt, err := r.Store.GetLastAccrualAt(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if accruedAt == nil || time.Since(*accruedAt) >= r.Cooldown {
+		return nil, nil
+	}
+	return &storage.FraudRuleHit{
+		Rule:    r.Name(),
+		Outcome: r.Outcome,
+		Reason:  fmt.Sprintf("points accrued at %s, less than %s ago", accruedAt.Format(time.RFC3339), r.Cooldown),
+	}, nil
+}
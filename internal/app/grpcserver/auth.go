@@ -0,0 +1,152 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	pb "github.com/polosaty/go-dev-final/api/gophermart/v1"
+	"github.com/polosaty/go-dev-final/internal/app/credentials"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Register регистрация пользователя, правила те же, что у POST /api/user/register
+func (s *authServer) Register(ctx context.Context, req *pb.Credentials) (*pb.TokenPair, error) {
+	if err := s.allowIP(ctx); err != nil {
+		return nil, err
+	}
+	login := credentials.NormalizeLogin(req.GetLogin())
+	if verr := s.policy.Validate(login, req.GetPassword()); verr != nil {
+		return nil, validationError(verr)
+	}
+	if err := s.acquireBcrypt(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := s.repository.CreateUser(ctx, login, req.GetPassword(), strings.TrimSpace(req.GetReferralCode()))
+	s.loginGuard.Bcrypt.Release()
+	if err != nil {
+		if errors.Is(err, storage.ErrDuplicateUser) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		if errors.Is(err, storage.ErrReferralCodeNotFound) {
+			return nil, validationError(credentials.NewValidationError("referral_code", credentials.CodeNotFound, err.Error()))
+		}
+		return nil, internalError(ctx, "create user error", err)
+	}
+	metrics.Registrations.Inc()
+	return s.issueTokens(ctx, userID)
+}
+
+// Login вход пользователя, правила те же, что у POST /api/user/login
+func (s *authServer) Login(ctx context.Context, req *pb.Credentials) (*pb.TokenPair, error) {
+	if err := s.allowIP(ctx); err != nil {
+		return nil, err
+	}
+	login := credentials.NormalizeLogin(req.GetLogin())
+	if allowed, retryAfter := s.loginGuard.AllowLogin(ctx, login); !allowed {
+		return nil, tooManyRequests(retryAfter)
+	}
+	if err := s.acquireBcrypt(ctx); err != nil {
+		return nil, err
+	}
+	userID, err := s.repository.LoginUser(ctx, login, req.GetPassword())
+	s.loginGuard.Bcrypt.Release()
+	if err != nil {
+		logger.InfoContext(ctx, "login user error", "error", err)
+		var lockedErr *storage.UserLockedError
+		switch {
+		case errors.As(err, &lockedErr):
+			metrics.Logins.WithLabelValues(metrics.LoginLocked).Inc()
+			return nil, tooManyRequests(time.Until(lockedErr.Until))
+		case errors.Is(err, storage.ErrWrongPassword):
+			metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+			err = s.repository.RegisterLoginFailure(ctx, login, s.cfg.LoginMaxFailures, s.cfg.LoginLockDuration)
+			if err != nil {
+				logger.ErrorContext(ctx, "register login failure error", "error", err)
+			}
+			return nil, status.Error(codes.Unauthenticated, storage.ErrWrongPassword.Error())
+		case errors.Is(err, storage.ErrUserBlocked):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, storage.ErrWrongLogin):
+			metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "")
+	}
+	metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
+	return s.issueTokens(ctx, userID)
+}
+
+// RefreshToken обмен refresh токена на новую пару, как POST /api/user/token/refresh
+func (s *authServer) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.TokenPair, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh_token required")
+	}
+	refreshToken, err := s.repository.RotateRefreshToken(ctx, req.GetRefreshToken(), s.cfg.RefreshTokenTTL)
+	if err != nil {
+		if errors.Is(err, storage.ErrWrongToken) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, internalError(ctx, "rotate refresh token error", err)
+	}
+	return s.tokenPair(ctx, refreshToken.UserID, refreshToken)
+}
+
+// RevokeToken отзыв refresh токена, как POST /api/user/token/revoke
+func (s *authServer) RevokeToken(ctx context.Context, req *pb.RefreshTokenRequest) (*emptypb.Empty, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh_token required")
+	}
+	if err := s.repository.RevokeRefreshToken(ctx, req.GetRefreshToken()); err != nil {
+		return nil, internalError(ctx, "revoke refresh token error", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *authServer) issueTokens(ctx context.Context, userID int64) (*pb.TokenPair, error) {
+	refreshToken, err := s.repository.CreateRefreshToken(ctx, userID, s.cfg.RefreshTokenTTL)
+	if err != nil {
+		return nil, internalError(ctx, "create refresh token error", err)
+	}
+	return s.tokenPair(ctx, userID, refreshToken)
+}
+
+func (s *authServer) tokenPair(ctx context.Context, userID int64, refreshToken *storage.RefreshToken) (*pb.TokenPair, error) {
+	accessToken, err := s.tokens.IssueAccessToken(userID)
+	if err != nil {
+		return nil, internalError(ctx, "issue access token error", err)
+	}
+	return &pb.TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.tokens.AccessTTL().Seconds()),
+		RefreshToken: refreshToken.Token,
+	}, nil
+}
+
+func (s *service) allowIP(ctx context.Context) error {
+	if allowed, retryAfter := s.loginGuard.AllowIP(ctx, clientIP(ctx)); !allowed {
+		return tooManyRequests(retryAfter)
+	}
+	return nil
+}
+
+// acquireBcrypt занимает слот для вычисления bcrypt, UNAVAILABLE если не дождались за BcryptQueueTimeout.
+// При успехе слот нужно освободить вызовом s.loginGuard.Bcrypt.Release().
+func (s *service) acquireBcrypt(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.BcryptQueueTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "grpc", "bcrypt queue")
+	err := s.loginGuard.Bcrypt.Acquire(ctx)
+	tracing.End(span, err)
+	if err != nil {
+		return status.Error(codes.Unavailable, "server is busy")
+	}
+	return nil
+}
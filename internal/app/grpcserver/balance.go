@@ -0,0 +1,85 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	pb "github.com/polosaty/go-dev-final/api/gophermart/v1"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *balanceServer) GetBalance(ctx context.Context, _ *emptypb.Empty) (*pb.Balance, error) {
+	balance, err := s.repository.GetBalance(ctx, userID(ctx))
+	if err != nil {
+		return nil, internalError(ctx, "get balance error", err)
+	}
+	return &pb.Balance{Current: balance.Current, Withdrawn: balance.Withdrawn}, nil
+}
+
+// Withdraw списание, правила те же, что у POST /api/user/balance/withdraw
+func (s *withdrawalServer) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*emptypb.Empty, error) {
+	orderNum, err := strconv.ParseInt(req.GetOrder(), 10, 64)
+	if err != nil || !storage.OrderIsValid(orderNum) {
+		return nil, status.Error(codes.InvalidArgument, "order number is invalid")
+	}
+	// решения антифрода записываются только для запросов, которые могли бы пройти
+	if !storage.WithdrawalSumIsValid(req.GetSum()) {
+		return nil, status.Error(codes.InvalidArgument, storage.ErrInvalidWithdrawalSum.Error())
+	}
+	if err = s.repository.CheckUserActive(ctx, userID(ctx)); err != nil {
+		if errors.Is(err, storage.ErrUserBlocked) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, internalError(ctx, "check user active error", err)
+	}
+	outcome := s.fraud.Evaluate(ctx, fraud.Event{
+		Action:   storage.FraudActionWithdrawal,
+		UserID:   userID(ctx),
+		OrderNum: req.GetOrder(),
+		Sum:      req.GetSum(),
+		IP:       clientIP(ctx),
+	})
+	if outcome == storage.FraudBlock {
+		return nil, status.Error(codes.PermissionDenied, "rejected by fraud rules")
+	}
+	err = s.repository.CreateWithdrawal(ctx, userID(ctx), storage.Withdrawal{OrderNum: req.GetOrder(), Sum: req.GetSum()})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidWithdrawalSum) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, storage.ErrUserBlocked) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if errors.Is(err, storage.ErrInsufficientBalance) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, storage.ErrWithdrawalLimit) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, internalError(ctx, "create withdrawal error", err)
+	}
+	metrics.PointsWithdrawn.Add(req.GetSum())
+	return &emptypb.Empty{}, nil
+}
+
+func (s *withdrawalServer) ListWithdrawals(ctx context.Context, _ *emptypb.Empty) (*pb.ListWithdrawalsResponse, error) {
+	withdrawals, err := s.repository.GetWithdrawals(ctx, userID(ctx))
+	if err != nil {
+		return nil, internalError(ctx, "get withdrawals error", err)
+	}
+	resp := &pb.ListWithdrawalsResponse{Withdrawals: make([]*pb.Withdrawal, 0, len(withdrawals))}
+	for _, withdrawal := range withdrawals {
+		resp.Withdrawals = append(resp.Withdrawals, &pb.Withdrawal{
+			Order:       withdrawal.OrderNum,
+			Sum:         withdrawal.Sum,
+			ProcessedAt: timestamp(withdrawal.ProcessedAt),
+		})
+	}
+	return resp, nil
+}
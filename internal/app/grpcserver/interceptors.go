@@ -0,0 +1,202 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/polosaty/go-dev-final/internal/app/auth"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var logger = logging.Component("grpc")
+
+type contextKey int
+
+const (
+	userIDContextKey contextKey = iota
+	callLogInfoContextKey
+)
+
+// publicMethodPrefixes методы, доступные без access токена
+var publicMethodPrefixes = []string{
+	"/gophermart.v1.AuthService/",
+	"/grpc.reflection.",
+}
+
+func isPublicMethod(fullMethod string) bool {
+	for _, prefix := range publicMethodPrefixes {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate проверяет access токен из метаданных authorization: Bearer <token>
+// и кладёт id пользователя в контекст
+func (s *service) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if isPublicMethod(fullMethod) {
+		return ctx, nil
+	}
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "authorization required")
+	}
+	userID, err := s.tokens.ParseAccessToken(ctx, token)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidToken) {
+			logger.ErrorContext(ctx, "parse access token error", "error", err)
+		}
+		return nil, status.Error(codes.Unauthenticated, auth.ErrInvalidToken.Error())
+	}
+	if info, ok := ctx.Value(callLogInfoContextKey).(*callLogInfo); ok {
+		info.userID = userID
+	}
+	ctx = logging.WithUserID(ctx, userID)
+	return context.WithValue(ctx, userIDContextKey, userID), nil
+}
+
+func (s *service) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *service) authStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	ctx, err := s.authenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// contextStream подменяет контекст потока
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// bearerToken достаёт токен из метаданных authorization: Bearer <token>
+func bearerToken(ctx context.Context) (string, bool) {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return "", false
+	}
+	const prefix = "bearer "
+	header := values[0]
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// userID id пользователя, прошедшего authUnaryInterceptor/authStreamInterceptor
+func userID(ctx context.Context) int64 {
+	id, _ := ctx.Value(userIDContextKey).(int64)
+	return id
+}
+
+// callLogInfo то, что становится известно после authenticate, а нужно в итоговой строке лога вызова
+type callLogInfo struct {
+	userID int64
+}
+
+// withCallLog привязывает к контексту request id (из метаданных x-request-id или новый)
+func withCallLog(ctx context.Context) (context.Context, *callLogInfo) {
+	requestID := ""
+	if values := metadata.ValueFromIncomingContext(ctx, "x-request-id"); len(values) > 0 {
+		requestID = values[0]
+	}
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	info := &callLogInfo{}
+	ctx = logging.WithRequestID(ctx, requestID)
+	return context.WithValue(ctx, callLogInfoContextKey, info), info
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func logCall(ctx context.Context, info *callLogInfo, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		level = slog.LevelError
+	}
+	logger.Log(logging.WithUserID(ctx, info.userID), level, "grpc call",
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start),
+	)
+}
+
+func loggingUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	start := time.Now()
+	ctx, callInfo := withCallLog(ctx)
+	resp, err := handler(ctx, req)
+	logCall(ctx, callInfo, info.FullMethod, start, err)
+	return resp, err
+}
+
+func loggingStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	start := time.Now()
+	ctx, callInfo := withCallLog(stream.Context())
+	err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	logCall(ctx, callInfo, info.FullMethod, start, err)
+	return err
+}
+
+func recoveryUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (resp interface{}, err error) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("grpc panic", "method", info.FullMethod, "panic", p, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func recoveryStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) (err error) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("grpc panic", "method", info.FullMethod, "panic", p, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "")
+		}
+	}()
+	return handler(srv, stream)
+}
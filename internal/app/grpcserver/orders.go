@@ -0,0 +1,201 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	pb "github.com/polosaty/go-dev-final/api/gophermart/v1"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// UploadOrder загрузка номера заказа, правила те же, что у POST /api/user/orders
+func (s *orderServer) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	orderNum, err := strconv.ParseInt(req.GetNumber(), 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "cant parse order number")
+	}
+	if !storage.OrderIsValid(orderNum) {
+		return nil, status.Error(codes.InvalidArgument, "order number is invalid")
+	}
+	ctx = logging.WithOrder(ctx, req.GetNumber())
+	// повторная загрузка своего номера идемпотентна и правилами антифрода не проверяется
+	uploaded, err := s.repository.IsOrderUploaded(ctx, userID(ctx), req.GetNumber())
+	if err != nil {
+		logger.ErrorContext(ctx, "check order uploaded error", "error", err)
+	}
+	if !uploaded {
+		outcome := s.fraud.Evaluate(ctx, fraud.Event{
+			Action:   storage.FraudActionOrderUpload,
+			UserID:   userID(ctx),
+			OrderNum: req.GetNumber(),
+			IP:       clientIP(ctx),
+		})
+		if outcome == storage.FraudBlock {
+			return nil, status.Error(codes.PermissionDenied, "rejected by fraud rules")
+		}
+	}
+	err = s.repository.CreateOrder(ctx, userID(ctx), req.GetNumber())
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderConflict) {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		if errors.Is(err, storage.ErrUserBlocked) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if errors.Is(err, storage.ErrOrderDuplicate) {
+			//номер заказа уже был загружен этим пользователем;
+			return &pb.UploadOrderResponse{Created: false}, nil
+		}
+		return nil, internalError(ctx, "create order error", err)
+	}
+	logger.InfoContext(ctx, "order uploaded")
+	metrics.OrdersUploaded.Inc()
+	return &pb.UploadOrderResponse{Created: true}, nil
+}
+
+func (s *orderServer) ListOrders(ctx context.Context, _ *emptypb.Empty) (*pb.ListOrdersResponse, error) {
+	orders, err := s.repository.GetOrders(ctx, userID(ctx))
+	if err != nil {
+		return nil, internalError(ctx, "get orders error", err)
+	}
+	resp := &pb.ListOrdersResponse{Orders: make([]*pb.Order, 0, len(orders))}
+	for _, order := range orders {
+		resp.Orders = append(resp.Orders, orderToPB(order))
+	}
+	return resp, nil
+}
+
+func (s *orderServer) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.OrderDetail, error) {
+	ctx = logging.WithOrder(ctx, req.GetNumber())
+	order, err := s.repository.GetOrder(ctx, userID(ctx), req.GetNumber())
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, internalError(ctx, "get order error", err)
+	}
+
+	resp := &pb.OrderDetail{
+		Order: &pb.Order{
+			Number:     order.OrderNum,
+			Status:     order.Status,
+			Accrual:    order.Accrual,
+			UploadedAt: timestamp(order.UploadedAt),
+		},
+		History: make([]*pb.OrderStatusChange, 0, len(order.History)),
+	}
+	if order.ProcessedAt != nil {
+		resp.Order.ProcessedAt = timestamp(*order.ProcessedAt)
+	}
+	for _, change := range order.History {
+		resp.History = append(resp.History, &pb.OrderStatusChange{
+			Status:    change.Status,
+			Accrual:   change.Accrual,
+			ChangedAt: timestamp(change.ChangedAt),
+		})
+	}
+	return resp, nil
+}
+
+// orderState то, изменение чего отправляется в WatchOrders
+type orderState struct {
+	status     string
+	accrual    float64
+	hasAccrual bool
+}
+
+// WatchOrders раз в GRPCWatchInterval проверяет версию изменений пользователя и, если она изменилась,
+// отправляет в поток новые заказы и заказы, у которых изменился статус или начисление.
+// Опрашивается база, а не OrderChecker, поэтому видны изменения, сделанные любым экземпляром сервиса.
+func (s *orderServer) WatchOrders(req *pb.WatchOrdersRequest, stream grpc.ServerStreamingServer[pb.Order]) error {
+	ctx := stream.Context()
+
+	var numbers map[string]struct{}
+	if len(req.GetNumbers()) > 0 {
+		numbers = make(map[string]struct{}, len(req.GetNumbers()))
+		for _, number := range req.GetNumbers() {
+			numbers[number] = struct{}{}
+		}
+	}
+
+	ticker := time.NewTicker(s.cfg.GRPCWatchInterval)
+	defer ticker.Stop()
+
+	sent := make(map[string]orderState)
+	lastVersion := int64(-1)
+	for first := true; ; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-ticker.C:
+			}
+		}
+
+		// полный список заказов перечитывается, только если изменилась версия изменений пользователя
+		version, err := s.repository.GetChangeVersion(ctx, userID(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			return internalError(ctx, "get change version error", err)
+		}
+		if version.Version == lastVersion {
+			continue
+		}
+		lastVersion = version.Version
+
+		orders, err := s.repository.GetOrders(ctx, userID(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			return internalError(ctx, "get orders error", err)
+		}
+
+		for _, order := range orders {
+			if _, ok := numbers[order.OrderNum]; numbers != nil && !ok {
+				continue
+			}
+			state := orderState{status: order.Status}
+			if order.Accrual != nil {
+				state.accrual, state.hasAccrual = *order.Accrual, true
+			}
+			prev, known := sent[order.OrderNum]
+			sent[order.OrderNum] = state
+			if (known && prev == state) || (first && req.GetSkipCurrent()) {
+				continue
+			}
+			if err = stream.Send(orderToPB(order)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func orderToPB(order storage.Order) *pb.Order {
+	return &pb.Order{
+		Number:      order.OrderNum,
+		Status:      order.Status,
+		Accrual:     order.Accrual,
+		UploadedAt:  timestamp(order.UploadedAt),
+		ProcessedAt: timestamp(order.ProcessedAt),
+	}
+}
+
+func timestamp(t storage.RFC3339DateTime) *timestamppb.Timestamp {
+	if !t.Valid {
+		return nil
+	}
+	return timestamppb.New(t.Time)
+}
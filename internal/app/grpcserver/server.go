@@ -0,0 +1,70 @@
+package grpcserver
+
+import (
+	pb "github.com/polosaty/go-dev-final/api/gophermart/v1"
+	"github.com/polosaty/go-dev-final/internal/app/auth"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/credentials"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/ratelimit"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// service общие зависимости всех gRPC сервисов - те же, что у HTTP API
+type service struct {
+	repository storage.Repository
+	cfg        config.Config
+	tokens     *auth.TokenManager
+	loginGuard *ratelimit.LoginGuard
+	policy     *credentials.Policy
+	fraud      *fraud.Engine
+}
+
+type authServer struct {
+	pb.UnimplementedAuthServiceServer
+	*service
+}
+
+type orderServer struct {
+	pb.UnimplementedOrderServiceServer
+	*service
+}
+
+type balanceServer struct {
+	pb.UnimplementedBalanceServiceServer
+	*service
+}
+
+type withdrawalServer struct {
+	pb.UnimplementedWithdrawalServiceServer
+	*service
+}
+
+// NewServer создаёт gRPC сервер с сервисами gophermart.v1 и reflection.
+// Все методы, кроме AuthService и reflection, требуют access токен в метаданных authorization.
+func NewServer(repository storage.Repository, cfg config.Config, tokens *auth.TokenManager,
+	loginGuard *ratelimit.LoginGuard, policy *credentials.Policy, fraudEngine *fraud.Engine, opts ...grpc.ServerOption) *grpc.Server {
+
+	s := &service{
+		repository: repository,
+		cfg:        cfg,
+		tokens:     tokens,
+		loginGuard: loginGuard,
+		policy:     policy,
+		fraud:      fraudEngine,
+	}
+
+	server := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(recoveryUnaryInterceptor, tracingUnaryInterceptor, loggingUnaryInterceptor, s.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(recoveryStreamInterceptor, tracingStreamInterceptor, loggingStreamInterceptor, s.authStreamInterceptor),
+	}, opts...)...)
+	pb.RegisterAuthServiceServer(server, &authServer{service: s})
+	pb.RegisterOrderServiceServer(server, &orderServer{service: s})
+	pb.RegisterBalanceServiceServer(server, &balanceServer{service: s})
+	pb.RegisterWithdrawalServiceServer(server, &withdrawalServer{service: s})
+	reflection.Register(server)
+
+	return server
+}
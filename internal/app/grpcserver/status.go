@@ -0,0 +1,61 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/polosaty/go-dev-final/internal/app/credentials"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// internalError логирует причину и отвечает INTERNAL без подробностей (как 500 в HTTP API)
+func internalError(ctx context.Context, message string, err error) error {
+	logger.ErrorContext(ctx, message, "error", err)
+	return status.Error(codes.Internal, "")
+}
+
+// validationError INVALID_ARGUMENT с нарушениями по полям (как 400 с {"errors": [...]} в HTTP API)
+func validationError(verr *credentials.ValidationError) error {
+	details := &errdetails.BadRequest{}
+	for _, fieldError := range verr.Errors {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       fieldError.Field,
+			Description: fieldError.Message,
+			Reason:      fieldError.Code,
+		})
+	}
+	return withDetails(status.New(codes.InvalidArgument, verr.Error()), details)
+}
+
+// tooManyRequests RESOURCE_EXHAUSTED с временем до повторной попытки (как 429 с Retry-After)
+func tooManyRequests(retryAfter time.Duration) error {
+	return withDetails(status.New(codes.ResourceExhausted, "too many requests"),
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+}
+
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// clientIP адрес клиента для ограничения частоты попыток входа
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"net/http"
+	"time"
+)
+
+type passwordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type accountDeletion struct {
+	Password string `json:"password"`
+}
+
+// userExport выгрузка всех данных пользователя
+type userExport struct {
+	Profile     *storage.Profile      `json:"profile"`
+	Orders      []storage.Order       `json:"orders"`
+	Withdrawals []storage.Withdrawal  `json:"withdrawals"`
+	Sessions    []storage.SessionInfo `json:"sessions"`
+}
+
+// postPassword handles
+// POST /api/user/password - смена пароля пользователя;
+// все сессии пользователя, кроме текущей, завершаются, refresh токены отзываются;
+// 200 - пароль изменён;
+// 400 - неверный формат запроса или новый пароль не соответствует правилам
+// (в теле - {"errors": [{"field", "code", "message"}]});
+// 401 - пользователь не авторизован;
+// 403 - неверный текущий пароль;
+// 429 - слишком много попыток (см. Retry-After);
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) postPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+
+		var request passwordChange
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		profile, err := h.repository.GetProfile(ctx, session.UserID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get profile error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if verr := h.policy.ValidatePassword(profile.Login, request.NewPassword); verr != nil {
+			for i := range verr.Errors {
+				verr.Errors[i].Field = "new_password"
+			}
+			writeJSON(w, http.StatusBadRequest, verr)
+			return
+		}
+
+		if !h.allowIP(w, r) || !h.acquireBcrypt(w, r) {
+			return
+		}
+		err = h.repository.ChangePassword(ctx, session.UserID, request.CurrentPassword, request.NewPassword, session.ID)
+		h.loginGuard.Bcrypt.Release()
+		if err != nil {
+			if errors.Is(err, storage.ErrWrongPassword) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			logger.ErrorContext(r.Context(), "change password error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// getExport handles
+// GET /api/user/export - выгрузка всех данных пользователя (профиль, заказы, списания, сессии);
+// ?format=zip - zip архив с отдельным json файлом на каждый раздел, по умолчанию - один json документ;
+// 200 - успешная обработка запроса;
+// 400 - неизвестный формат;
+// 401 - пользователь не авторизован;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) getExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "zip" {
+			http.Error(w, "unknown format", http.StatusBadRequest)
+			return
+		}
+
+		export, err := h.collectExport(ctx, session.UserID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "export user data error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		fileName := fmt.Sprintf("gophermart-export-%d-%s", session.UserID, time.Now().Format("20060102"))
+		if format == "zip" {
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, fileName))
+			w.WriteHeader(http.StatusOK)
+
+			if err = writeExportZip(w, export); err != nil {
+				logger.ErrorContext(r.Context(), "write export zip error", "error", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, fileName))
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(export)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
+		}
+	}
+}
+
+func (h *mainHandler) collectExport(ctx context.Context, userID int64) (export userExport, err error) {
+	if export.Profile, err = h.repository.GetProfile(ctx, userID); err != nil {
+		return
+	}
+	if export.Orders, err = h.repository.GetOrders(ctx, userID); err != nil {
+		return
+	}
+	if export.Withdrawals, err = h.repository.GetWithdrawals(ctx, userID); err != nil {
+		return
+	}
+	export.Sessions, err = h.repository.GetSessions(ctx, userID)
+	return
+}
+
+func writeExportZip(w http.ResponseWriter, export userExport) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"orders.json", export.Orders},
+		{"withdrawals.json", export.Withdrawals},
+		{"sessions.json", export.Sessions},
+	}
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err = json.NewEncoder(f).Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// deleteAccount handles
+// DELETE /api/user/account - удаление учётной записи пользователя;
+// персональные данные обезличиваются, история начислений и списаний сохраняется;
+// уже выданные access токены остаются валидны до истечения их срока жизни;
+// 200 - учётная запись удалена;
+// 400 - неверный формат запроса;
+// 401 - пользователь не авторизован или учётная запись уже удалена (cookie auth сбрасывается);
+// 403 - неверный пароль;
+// 429 - слишком много попыток (см. Retry-After);
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) deleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+
+		var request accountDeletion
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !h.allowIP(w, r) || !h.acquireBcrypt(w, r) {
+			return
+		}
+		err := h.repository.DeleteUser(ctx, session.UserID, request.Password)
+		h.loginGuard.Bcrypt.Release()
+		if err != nil {
+			if errors.Is(err, storage.ErrWrongPassword) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			// повторный запрос с ещё действующим access токеном
+			if errors.Is(err, storage.ErrWrongLogin) {
+				h.clearAuthCookie(w)
+				http.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			logger.ErrorContext(r.Context(), "delete user error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.clearAuthCookie(w)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,631 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type adminReason struct {
+	Reason string `json:"reason"`
+}
+
+type adminBalanceAdjustment struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type adminRoleChange struct {
+	Role   storage.Role `json:"role"`
+	Reason string       `json:"reason"`
+}
+
+type adminFraudReview struct {
+	Resolution string `json:"resolution"`
+	Reason     string `json:"reason"`
+}
+
+type adminWithdrawalLimits struct {
+	storage.WithdrawalLimitsOverride
+	Reason string `json:"reason"`
+}
+
+// requireRole пропускает только пользователей с одной из ролей roles.
+// Роль всегда читается из базы (а не из токена), чтобы отзыв прав и блокировка сотрудника действовали
+// на служебные методы сразу. Обычному пользователю с ещё действующим access токеном блокировка сразу
+// запрещает загрузку заказов и списания (проверка в storage), чтение доступно до истечения токена.
+func (h *mainHandler) requireRole(roles ...storage.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := GetSession(r)
+			if session.Role == "" {
+				role, err := h.repository.GetUserRole(r.Context(), session.UserID)
+				if err != nil {
+					if errors.Is(err, storage.ErrUserBlocked) || errors.Is(err, storage.ErrUserNotFound) {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+					logger.ErrorContext(r.Context(), "get user role error", "error", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				session.Role = role
+			}
+
+			for _, role := range roles {
+				if session.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+}
+
+// audit записывает в журнал действие сотрудника, не меняющее данные (просмотр)
+func (h *mainHandler) audit(r *http.Request, action string, targetUserID *int64) {
+	session := GetSession(r)
+	err := h.repository.CreateAuditRecord(r.Context(), storage.AuditRecord{
+		ActorID:      session.UserID,
+		Action:       action,
+		TargetUserID: targetUserID,
+	})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "create audit record error", "error", err)
+	}
+}
+
+func userIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "cant parse user id", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+// adminGetUsers handles
+// GET /api/admin/users?query=&limit=&offset= - поиск пользователей по части логина или id;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset := pagination(r)
+		query := strings.TrimSpace(r.URL.Query().Get("query"))
+
+		users, err := h.repository.SearchUsers(r.Context(), query, limit, offset)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "search users error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "user.search", nil)
+		writeJSON(w, http.StatusOK, users)
+	}
+}
+
+// adminGetUser handles
+// GET /api/admin/users/{userID} - просмотр пользователя;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - пользователь не найден;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := h.repository.GetUser(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.ErrorContext(r.Context(), "get user error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "user.view", &userID)
+		writeJSON(w, http.StatusOK, user)
+	}
+}
+
+// adminGetUserOrders handles
+// GET /api/admin/users/{userID}/orders - просмотр заказов пользователя;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetUserOrders() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		orders, err := h.repository.GetOrders(r.Context(), userID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get orders error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "user.orders.view", &userID)
+		writeJSON(w, http.StatusOK, orders)
+	}
+}
+
+// adminGetUserWithdrawals handles
+// GET /api/admin/users/{userID}/withdrawals - просмотр списаний пользователя;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetUserWithdrawals() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		withdrawals, err := h.repository.GetWithdrawals(r.Context(), userID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get withdrawals error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "user.withdrawals.view", &userID)
+		writeJSON(w, http.StatusOK, withdrawals)
+	}
+}
+
+// adminGetUserSessions handles
+// GET /api/admin/users/{userID}/sessions - просмотр активных сессий пользователя;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetUserSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		sessions, err := h.repository.GetSessions(r.Context(), userID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get sessions error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "user.sessions.view", &userID)
+		writeJSON(w, http.StatusOK, sessions)
+	}
+}
+
+// adminGetUserAudit handles
+// GET /api/admin/users/{userID}/audit?limit=&offset= - журнал действий сотрудников над пользователем;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetUserAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+		limit, offset := pagination(r)
+
+		records, err := h.repository.GetAuditRecords(r.Context(), &userID, limit, offset)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get audit records error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
+
+// adminPostBalanceAdjustment handles
+// POST /api/admin/users/{userID}/balance - ручная корректировка баланса пользователя, причина обязательна;
+// 200 - баланс изменён, в ответе новый баланс;
+// 400 - неверный формат запроса или не указана причина;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - пользователь не найден;
+// 409 - баланс уйдёт в минус;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminPostBalanceAdjustment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		var request adminBalanceAdjustment
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Reason = strings.TrimSpace(request.Reason)
+		if request.Reason == "" || request.Amount == 0 {
+			http.Error(w, "amount and reason required", http.StatusBadRequest)
+			return
+		}
+
+		balance, err := h.repository.AdjustBalance(r.Context(), storage.BalanceAdjustment{
+			UserID:  userID,
+			Amount:  request.Amount,
+			Reason:  request.Reason,
+			ActorID: session.UserID,
+		})
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrInsufficientBalance) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			logger.ErrorContext(r.Context(), "adjust balance error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, balance)
+	}
+}
+
+// adminPostUserActive handles
+// POST /api/admin/users/{userID}/block и POST /api/admin/users/{userID}/unblock -
+// блокировка и разблокировка пользователя, причина обязательна;
+// 200 - успешная обработка запроса;
+// 400 - неверный формат запроса или не указана причина;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав (администратора может блокировать и разблокировать только администратор);
+// 404 - пользователь не найден;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminPostUserActive(active bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+		reason, ok := readReason(w, r)
+		if !ok {
+			return
+		}
+
+		err := h.repository.SetUserActive(r.Context(), session.UserID, userID, active, reason)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrPermissionDenied) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			logger.ErrorContext(r.Context(), "set user active error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// adminPutUserRole handles
+// PUT /api/admin/users/{userID}/role - смена роли пользователя, причина обязательна;
+// 200 - успешная обработка запроса;
+// 400 - неверный формат запроса, неизвестная роль или не указана причина;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - пользователь не найден;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminPutUserRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		var request adminRoleChange
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Reason = strings.TrimSpace(request.Reason)
+		if !request.Role.IsValid() || request.Reason == "" {
+			http.Error(w, "valid role and reason required", http.StatusBadRequest)
+			return
+		}
+
+		err := h.repository.SetUserRole(r.Context(), session.UserID, userID, request.Role, request.Reason)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.ErrorContext(r.Context(), "set user role error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// adminGetWithdrawalLimits handles
+// GET /api/admin/users/{userID}/withdrawal-limits - ограничения списаний пользователя:
+// общие (default), персональные (override, null - действует общее) и действующие (effective);
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - пользователь не найден;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetWithdrawalLimits() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		limits, err := h.repository.GetWithdrawalLimits(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.ErrorContext(r.Context(), "get withdrawal limits error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, limits)
+	}
+}
+
+// adminPutWithdrawalLimits handles
+// PUT /api/admin/users/{userID}/withdrawal-limits - персональные ограничения списаний пользователя,
+// тело {"min": 10, "per_transaction": null, "per_day": 0, "per_month": 5000, "reason": "..."}:
+// null - действует общее ограничение, 0 - без ограничения; все null - вернуть общие ограничения;
+// причина обязательна;
+// 200 - успешная обработка запроса, в ответе ограничения как в GET;
+// 400 - неверный формат запроса, отрицательное ограничение или не указана причина;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - пользователь не найден;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminPutWithdrawalLimits() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		userID, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		var request adminWithdrawalLimits
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Reason = strings.TrimSpace(request.Reason)
+		if request.Reason == "" {
+			http.Error(w, "reason required", http.StatusBadRequest)
+			return
+		}
+		override := request.WithdrawalLimitsOverride
+		for _, value := range []*float64{override.Min, override.PerTransaction, override.PerDay, override.PerMonth} {
+			if value != nil && *value < 0 {
+				http.Error(w, "limits must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := h.repository.SetWithdrawalLimits(r.Context(), session.UserID, userID, override, request.Reason)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.ErrorContext(r.Context(), "set withdrawal limits error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		limits, err := h.repository.GetWithdrawalLimits(r.Context(), userID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get withdrawal limits error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, limits)
+	}
+}
+
+// adminPostOrderRecheck handles
+// POST /api/admin/orders/{number}/recheck - повторный запрос статуса заказа в системе расчёта баллов,
+// причина обязательна;
+// 202 - заказ поставлен в очередь на проверку;
+// 400 - неверный формат запроса или не указана причина;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - заказ не найден;
+// 409 - заказ уже обработан, начисление зачислено;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminPostOrderRecheck() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		reason, ok := readReason(w, r)
+		if !ok {
+			return
+		}
+
+		number := chi.URLParam(r, "number")
+		r = r.WithContext(logging.WithOrder(r.Context(), number))
+		err := h.repository.RecheckOrder(r.Context(), session.UserID, number, reason)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrOrderAlreadyProcessed) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			logger.ErrorContext(r.Context(), "recheck order error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// adminGetFraudDecisions handles
+// GET /api/admin/fraud/decisions?user_id=&outcome=&reviewed=&limit=&offset= - действия пользователей,
+// отмеченные (outcome=flag) или отклонённые (outcome=block) правилами антифрода, новые первыми;
+// reviewed=false - только ещё не проверенные сотрудниками;
+// 200 - успешная обработка запроса;
+// 400 - неверный формат фильтра;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetFraudDecisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset := pagination(r)
+		query := r.URL.Query()
+
+		var filter storage.FraudDecisionFilter
+		if value := query.Get("user_id"); value != "" {
+			userID, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				http.Error(w, "cant parse user_id", http.StatusBadRequest)
+				return
+			}
+			filter.UserID = &userID
+		}
+		if value := query.Get("outcome"); value != "" {
+			filter.Outcome = storage.FraudOutcome(value)
+			if filter.Outcome != storage.FraudFlag && filter.Outcome != storage.FraudBlock {
+				http.Error(w, "outcome must be flag or block", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("reviewed"); value != "" {
+			reviewed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "cant parse reviewed", http.StatusBadRequest)
+				return
+			}
+			filter.Reviewed = &reviewed
+		}
+
+		decisions, err := h.repository.GetFraudDecisions(r.Context(), filter, limit, offset)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get fraud decisions error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "fraud_decision.list", filter.UserID)
+		writeJSON(w, http.StatusOK, decisions)
+	}
+}
+
+// adminPostFraudReview handles
+// POST /api/admin/fraud/decisions/{decisionID}/review - решение сотрудника по действию, отмеченному антифродом,
+// тело {"resolution": "confirmed" | "dismissed", "reason": "..."}, причина обязательна;
+// 200 - успешная обработка запроса, в ответе решение с результатом проверки;
+// 400 - неверный формат запроса, неизвестное решение или не указана причина;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 404 - решение антифрода не найдено;
+// 409 - решение уже проверено;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminPostFraudReview() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		decisionID, err := strconv.ParseInt(chi.URLParam(r, "decisionID"), 10, 64)
+		if err != nil {
+			http.Error(w, "cant parse decision id", http.StatusBadRequest)
+			return
+		}
+
+		var request adminFraudReview
+		if err = json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Resolution != storage.FraudResolutionConfirmed && request.Resolution != storage.FraudResolutionDismissed {
+			http.Error(w, "resolution must be confirmed or dismissed", http.StatusBadRequest)
+			return
+		}
+		request.Reason = strings.TrimSpace(request.Reason)
+		if request.Reason == "" {
+			http.Error(w, "reason required", http.StatusBadRequest)
+			return
+		}
+
+		decision, err := h.repository.ReviewFraudDecision(r.Context(), session.UserID, decisionID,
+			request.Resolution, request.Reason)
+		if err != nil {
+			if errors.Is(err, storage.ErrFraudDecisionNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrFraudDecisionReviewed) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			logger.ErrorContext(r.Context(), "review fraud decision error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, decision)
+	}
+}
+
+// adminGetAudit handles
+// GET /api/admin/audit?limit=&offset= - журнал действий сотрудников;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 403 - недостаточно прав;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) adminGetAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset := pagination(r)
+
+		records, err := h.repository.GetAuditRecords(r.Context(), nil, limit, offset)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get audit records error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
+
+func readReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request adminReason
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	reason := strings.TrimSpace(request.Reason)
+	if reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return "", false
+	}
+	return reason, true
+}
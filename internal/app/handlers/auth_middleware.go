@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
-	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 func NewSession(token string) *storage.Session {
@@ -18,39 +19,72 @@ type requestContextKeyType string
 
 const requestContextKey = requestContextKeyType("Session")
 
-func authMiddleware(repo storage.Repository) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := r.Context()
-			cookie, err := r.Cookie("auth")
-			if err != nil {
-				if errors.Is(err, http.ErrNoCookie) {
-					w.WriteHeader(http.StatusUnauthorized)
-					return
-				}
-			}
+const authCookieName = "auth"
 
-			userID, err := repo.GetUserByToken(ctx, cookie.Value)
+func (h *mainHandler) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if bearer, ok := bearerToken(r); ok {
+			userID, err := h.tokens.ParseAccessToken(ctx, bearer)
 			if err != nil {
-				log.Println("error while get user by token:", err)
-				if errors.Is(err, storage.ErrWrongToken) {
-					w.WriteHeader(http.StatusUnauthorized)
-					return
-				}
-				w.WriteHeader(http.StatusInternalServerError)
+				logger.InfoContext(ctx, "parse access token error", "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
+			r = withLogUser(r, userID)
+			r = r.WithContext(context.WithValue(r.Context(), requestContextKey, &storage.Session{UserID: userID}))
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			session := &storage.Session{
-				Token:  cookie.Value,
-				UserID: userID,
+		cookie, err := r.Cookie(authCookieName)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// cookie браузер подставляет сам, поэтому изменяющие запросы с ней проверяются на CSRF;
+		// запросы с Bearer токеном подделать со стороннего сайта нельзя
+		if !h.sameOriginOrTrusted(r) {
+			http.Error(w, "cross-site request rejected", http.StatusForbidden)
+			return
+		}
+
+		var prolong time.Duration
+		if h.cfg.SessionSliding {
+			prolong = h.cfg.SessionTTL
+		}
+		session, err := h.repository.GetSessionByToken(ctx, cookie.Value, prolong)
+		if err != nil {
+			logger.InfoContext(ctx, "get session by token error", "error", err)
+			if errors.Is(err, storage.ErrWrongToken) {
+				h.clearAuthCookie(w)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
 			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if h.cfg.SessionSliding {
+			h.setAuthCookie(w, session)
+		}
 
-			r = r.WithContext(context.WithValue(r.Context(), requestContextKey, session))
+		r = withLogUser(r, session.UserID)
+		r = r.WithContext(context.WithValue(r.Context(), requestContextKey, session))
 
-			next.ServeHTTP(w, r)
-		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken достаёт токен из заголовка Authorization: Bearer <token>
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
 	}
+	return strings.TrimSpace(header[len(prefix):]), true
 }
 
 func GetSession(req *http.Request) *storage.Session {
@@ -58,3 +92,40 @@ func GetSession(req *http.Request) *storage.Session {
 	sess, _ := sessCtx.(*storage.Session)
 	return sess
 }
+
+func (h *mainHandler) setAuthCookie(w http.ResponseWriter, session *storage.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     authCookieName,
+		Value:    session.Token,
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   h.cfg.AuthCookieSecure(),
+		SameSite: h.cookieSameSite(),
+	})
+}
+
+// cookieSameSite режим SameSite для cookie auth: none нужен фронтенду на другом сайте
+// (тогда от CSRF защищает только проверка origin, см. sameOriginOrTrusted)
+func (h *mainHandler) cookieSameSite() http.SameSite {
+	switch strings.ToLower(h.cfg.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func (h *mainHandler) clearAuthCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Path:     "/",
+		Name:     authCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.cfg.AuthCookieSecure(),
+		SameSite: h.cookieSameSite(),
+	})
+}
@@ -2,21 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
+	"time"
 )
 
 // getBalance handles
 // GET /api/user/balance — получение текущего баланса счёта баллов лояльности пользователя;
+// expiring — ближайшие сгорания баллов: сколько и когда сгорит, если не потратить (списания
+// расходуют первыми баллы, которые сгорят раньше);
+// withdrawal_allowance — действующие ограничения списаний и сколько ещё можно списать сегодня
+// и в этом месяце (null — без ограничения);
+// tier — уровень программы лояльности, его множитель начислений, сумма начислений за 12 месяцев
+// и сколько осталось до следующего уровня;
+// поддерживает условный запрос по ETag (If-None-Match) и Last-Modified (If-Modified-Since);
+// 304 — баланс не изменился (с начала суток по UTC, когда обновляются остаток ограничений
+// и окно начислений уровня);
 func (h *mainHandler) getBalance() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		//take userID from context
 		session := GetSession(r)
+		if h.notModifiedSince(w, r, time.Now().UTC().Truncate(24*time.Hour)) {
+			return
+		}
 
 		balance, err := h.repository.GetBalance(ctx, session.UserID)
 		if err != nil {
-			log.Println(err)
+			logger.ErrorContext(r.Context(), "get balance error", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -26,7 +39,75 @@ func (h *mainHandler) getBalance() http.HandlerFunc {
 
 		err = json.NewEncoder(w).Encode(balance)
 		if err != nil {
-			log.Println("marshal response error: ", err)
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
 		}
 	}
 }
+
+// getStatement handles
+// GET /api/user/balance/statement?from=&to=&limit=&offset= — выписка по счёту баллов:
+// начисления за заказы, списания, корректировки и сгорания баллов в порядке проведения с балансом после каждого движения,
+// входящим и исходящим остатком за период [from, to);
+// from и to - даты (2006-01-02, to включительно) или моменты времени в RFC3339, по умолчанию - вся история;
+// 200 — успешная обработка запроса;
+// 400 — неверный формат периода;
+// 401 — пользователь не авторизован;
+// 500 — внутренняя ошибка сервера.
+func (h *mainHandler) getStatement() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		//take userID from context
+		session := GetSession(r)
+
+		from, to, err := parsePeriod(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, offset := pagination(r)
+
+		statement, err := h.repository.GetStatement(ctx, session.UserID, from, to, limit, offset)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get statement error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, statement)
+	}
+}
+
+// parsePeriod читает период [from, to) из параметров запроса from и to
+func parsePeriod(r *http.Request) (from time.Time, to time.Time, err error) {
+	from = time.Unix(0, 0)
+	to = time.Now()
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = parsePeriodBound(value, false); err != nil {
+			return
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = parsePeriodBound(value, true); err != nil {
+			return
+		}
+	}
+	if !from.Before(to) {
+		err = fmt.Errorf("empty period: from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return
+}
+
+// parsePeriodBound разбирает границу периода; дата без времени в качестве конца периода включается в период целиком
+func parsePeriodBound(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return t, fmt.Errorf("cant parse date %q: expected 2006-01-02 or RFC3339", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
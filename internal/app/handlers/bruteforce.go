@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"github.com/polosaty/go-dev-final/internal/app/ratelimit"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// allowIP проверяет лимит попыток с IP клиента, при превышении отвечает 429
+func (h *mainHandler) allowIP(w http.ResponseWriter, r *http.Request) bool {
+	allowed, retryAfter := h.loginGuard.AllowIP(r.Context(), h.clientIP(r))
+	if !allowed {
+		tooManyRequests(w, retryAfter)
+	}
+	return allowed
+}
+
+// allowLogin проверяет лимит попыток входа под логином, при превышении отвечает 429
+func (h *mainHandler) allowLogin(w http.ResponseWriter, r *http.Request, login string) bool {
+	allowed, retryAfter := h.loginGuard.AllowLogin(r.Context(), login)
+	if !allowed {
+		tooManyRequests(w, retryAfter)
+	}
+	return allowed
+}
+
+// acquireBcrypt занимает слот для вычисления bcrypt. Если свободного слота не дождались
+// за BcryptQueueTimeout, отвечает 503 и возвращает false.
+// При успехе слот нужно освободить вызовом h.loginGuard.Bcrypt.Release().
+func (h *mainHandler) acquireBcrypt(w http.ResponseWriter, r *http.Request) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.BcryptQueueTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "http", "bcrypt queue")
+	err := h.loginGuard.Bcrypt.Acquire(ctx)
+	tracing.End(span, err)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "server is busy", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(ratelimit.RetryAfterSeconds(retryAfter), 10))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies обратные прокси, которым можно верить в X-Forwarded-For и X-Real-IP
+type trustedProxies []*net.IPNet
+
+func (p trustedProxies) contains(ip net.IP) bool {
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP адрес клиента без порта. Заголовки прокси учитываются, только если соединение пришло
+// от доверенного прокси: X-Forwarded-For разбирается справа налево до первого недоверенного адреса,
+// иначе любой клиент мог бы подставить свой адрес и обойти ограничение попыток входа.
+func (h *mainHandler) clientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || !h.proxies.contains(ip) {
+		return addr
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			addr = hop.String()
+			if !h.proxies.contains(hop) {
+				break
+			}
+		}
+		return addr
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return addr
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Поддерживаемые кодировки в порядке предпочтения сервера при равных q
+const (
+	encodingZstd    = "zstd"
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var supportedEncodings = []string{encodingZstd, encodingGzip, encodingDeflate}
+
+// compressor сжимающий writer, который можно вернуть в пул
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	encodingGzip: {New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(io.Discard, gzip.BestSpeed)
+		return gz
+	}},
+	encodingDeflate: {New: func() interface{} {
+		// Content-Encoding: deflate - это zlib поток (RFC 9110, 8.4.1.2)
+		zw, _ := zlib.NewWriterLevel(io.Discard, zlib.BestSpeed)
+		return zw
+	}},
+	encodingZstd: {New: func() interface{} {
+		zw, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return zw
+	}},
+}
+
+// negotiateEncoding выбирает кодировку ответа по Accept-Encoding с учётом q-value.
+// Пустая строка - отвечать без сжатия.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := weights[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressOutput сжимает ответ выбранной по Accept-Encoding кодировкой, если тело не меньше minSize байт
+// и его Content-Type есть в contentTypes (пустой список - сжимать любой тип).
+// Решение принимается при первой записи тела, когда статус и заголовки уже известны.
+func compressOutput(minSize int, contentTypes []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]struct{}, len(contentTypes))
+	for _, contentType := range contentTypes {
+		allowed[strings.ToLower(strings.TrimSpace(contentType))] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        minSize,
+				contentTypes:   allowed,
+			}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter копит начало тела, пока не станет ясно, стоит ли его сжимать
+type compressWriter struct {
+	http.ResponseWriter
+	encoding     string
+	minSize      int
+	contentTypes map[string]struct{}
+
+	status     int
+	buf        []byte
+	decided    bool
+	compressor compressor
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		// повторный WriteHeader игнорируется, как и в net/http
+		return
+	}
+	w.status = status
+	if !bodyAllowed(status) {
+		// тела не будет - сжимать нечего
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.compressor != nil {
+		return w.compressor.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide отправляет заголовки и накопленное тело; bigEnough - тело не меньше minSize
+func (w *compressWriter) decide(bigEnough bool) error {
+	w.decided = true
+	header := w.Header()
+	if bigEnough && w.shouldCompress() {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		w.compressor = compressorPools[w.encoding].Get().(compressor)
+		w.compressor.Reset(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.compressor != nil {
+		_, err = w.compressor.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) shouldCompress() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || w.status < 200 || w.status >= 300 || w.status == http.StatusPartialContent {
+		return false
+	}
+	if len(w.contentTypes) == 0 {
+		return true
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		// так же, как это сделал бы net/http
+		contentType = http.DetectContentType(w.buf)
+		header.Set("Content-Type", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	_, ok := w.contentTypes[mediaType]
+	return ok
+}
+
+// Flush отправляет клиенту всё записанное (для потоковых ответов); недокопленное тело сжимается,
+// если подходит по типу - при потоковой отдаче размер заранее неизвестен
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		_ = w.decide(true)
+	}
+	if w.compressor != nil {
+		_ = w.compressor.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close дописывает короткое тело без сжатия или закрывает сжатый поток и возвращает writer в пул
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// обработчик ничего не записал - net/http сам ответит 200 с пустым телом
+			w.decided = true
+			return nil
+		}
+		return w.decide(false)
+	}
+	if w.compressor == nil {
+		return nil
+	}
+	err := w.compressor.Close()
+	w.compressor.Reset(io.Discard)
+	compressorPools[w.encoding].Put(w.compressor)
+	w.compressor = nil
+	return err
+}
+
+// Unwrap нужен http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	return hijacker.Hijack()
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+var gzipReaderPool sync.Pool
+
+var zstdDecoderPool = sync.Pool{New: func() interface{} {
+	// окно ограничиваем, чтобы один запрос не мог заставить выделить много памяти под декодер
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+	return decoder
+}}
+
+// decompressInput распаковывает тело запроса в gzip, deflate или zstd.
+// Распакованное тело ограничено maxSize байт (защита от zip-бомб): при превышении чтение тела
+// возвращает ошибку *http.MaxBytesError.
+// 400 - тело не распаковывается;
+// 415 - неподдерживаемый Content-Encoding.
+func decompressInput(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var (
+				body    io.ReadCloser
+				release func()
+			)
+			switch encoding {
+			case encodingGzip, "x-gzip":
+				gz, _ := gzipReaderPool.Get().(*gzip.Reader)
+				var err error
+				if gz == nil {
+					gz, err = gzip.NewReader(r.Body)
+				} else {
+					err = gz.Reset(r.Body)
+				}
+				if err != nil {
+					http.Error(w, "cant read gzip body: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				body, release = gz, func() { gzipReaderPool.Put(gz) }
+			case encodingDeflate:
+				zr, err := zlib.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "cant read deflate body: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				body, release = zr, func() {}
+			case encodingZstd:
+				decoder := zstdDecoderPool.Get().(*zstd.Decoder)
+				if err := decoder.Reset(r.Body); err != nil {
+					zstdDecoderPool.Put(decoder)
+					http.Error(w, "cant read zstd body: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				body = io.NopCloser(decoder)
+				release = func() {
+					_ = decoder.Reset(nil)
+					zstdDecoderPool.Put(decoder)
+				}
+			default:
+				http.Error(w, "unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+				return
+			}
+			defer release()
+			defer body.Close()
+
+			r.Body = http.MaxBytesReader(w, body, maxSize)
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			next.ServeHTTP(w, r)
+		})
+	}
+}
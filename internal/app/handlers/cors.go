@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/cors"
+)
+
+// corsMiddleware разрешает запросы из браузера с origin из CORSAllowedOrigins.
+// Если список пуст, CORS заголовки не выставляются (браузер пустит только same-origin запросы):
+// сам go-chi/cors пустой список считает разрешением для любого origin.
+func (h *mainHandler) corsMiddleware() func(http.Handler) http.Handler {
+	if len(h.cfg.CORSAllowedOrigins) == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	return cors.Handler(cors.Options{
+		AllowedOrigins:   h.cfg.CORSAllowedOrigins,
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Content-Encoding", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"ETag", "Last-Modified", "Retry-After", "Content-Disposition"},
+		AllowCredentials: h.cfg.CORSAllowCredentials,
+		MaxAge:           int(h.cfg.CORSMaxAge.Seconds()),
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified проверяет условный GET по версии изменений пользователя (заказы, списания, баланс).
+// Выставляет ETag и Last-Modified; если у клиента актуальные данные, отвечает 304 и возвращает true -
+// тогда основной запрос к базе не выполняется.
+// Версия читается до основного запроса, поэтому при гонке клиент получит свежие данные со старым ETag
+// и просто перезапросит их при следующем опросе.
+func (h *mainHandler) notModified(w http.ResponseWriter, r *http.Request) bool {
+	return h.notModifiedSince(w, r, time.Time{})
+}
+
+// notModifiedSince как notModified, но ответ считается изменившимся и в момент periodStart - начало периода,
+// от которого зависит ответ (остаток ограничений списаний на сегодня), даже если данные не менялись
+func (h *mainHandler) notModifiedSince(w http.ResponseWriter, r *http.Request, periodStart time.Time) bool {
+	session := GetSession(r)
+	version, err := h.repository.GetChangeVersion(r.Context(), session.UserID)
+	if err != nil {
+		// без версии просто отдаём полный ответ
+		logger.ErrorContext(r.Context(), "get change version error", "error", err)
+		return false
+	}
+
+	etag := fmt.Sprintf(`W/"%d-%d"`, session.UserID, version.Version)
+	lastModified := version.ChangedAt.UTC().Truncate(time.Second)
+	if !periodStart.IsZero() {
+		etag = fmt.Sprintf(`W/"%d-%d-%d"`, session.UserID, version.Version, periodStart.Unix())
+		if periodStart.After(lastModified) {
+			lastModified = periodStart.UTC()
+		}
+	}
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	// ответ зависит от пользователя, общие кэши его хранить не должны, клиент - перепроверять
+	header.Set("Cache-Control", "private, no-cache")
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		// If-Modified-Since игнорируется, если есть If-None-Match (RFC 7232, 6)
+		if !etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+	} else {
+		ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.After(ifModifiedSince) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches слабое сравнение ETag из If-None-Match (список через запятую или *)
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
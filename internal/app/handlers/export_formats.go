@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Форматы выгрузки истории
+const (
+	exportCSV   = "csv"
+	exportJSONL = "jsonl"
+	exportOFX   = "ofx"
+)
+
+// exportRecord одна запись выгрузки во всех поддерживаемых форматах
+type exportRecord struct {
+	// fields значения колонок для CSV
+	fields []string
+	// object объект для JSON Lines
+	object interface{}
+	// transaction проводка для OFX, nil - запись не двигает баланс и в OFX не попадает
+	transaction *ofxTransaction
+}
+
+type exportWriter interface {
+	Write(record exportRecord) error
+	// Close дописывает документ (для OFX - закрывающие теги и остаток по счёту)
+	Close() error
+}
+
+type exportFormat struct {
+	contentType string
+	extension   string
+}
+
+var exportFormats = map[string]exportFormat{
+	exportCSV:   {contentType: "text/csv; charset=utf-8", extension: "csv"},
+	exportJSONL: {contentType: "application/x-ndjson; charset=utf-8", extension: "jsonl"},
+	exportOFX:   {contentType: "application/x-ofx", extension: "ofx"},
+}
+
+func newExportWriter(format string, w io.Writer, columns []string, account ofxAccount) exportWriter {
+	switch format {
+	case exportJSONL:
+		return &jsonlExportWriter{encoder: json.NewEncoder(w)}
+	case exportOFX:
+		return &ofxExportWriter{w: w, account: account}
+	default:
+		return &csvExportWriter{writer: csv.NewWriter(w), columns: columns}
+	}
+}
+
+type csvExportWriter struct {
+	writer        *csv.Writer
+	columns       []string
+	headerWritten bool
+}
+
+func (e *csvExportWriter) Write(record exportRecord) error {
+	if !e.headerWritten {
+		e.headerWritten = true
+		if err := e.writer.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	fields := make([]string, len(record.fields))
+	for i, field := range record.fields {
+		fields[i] = csvSafe(field)
+	}
+	return e.writer.Write(fields)
+}
+
+// csvSafe экранирует значение, которое табличный редактор выполнил бы как формулу (CSV injection):
+// перед ним ставится апостроф. Числа (в том числе отрицательные суммы) не меняются.
+func csvSafe(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+func (e *csvExportWriter) Close() error {
+	if !e.headerWritten {
+		if err := e.writer.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonlExportWriter) Write(record exportRecord) error {
+	return e.encoder.Encode(record.object)
+}
+
+func (e *jsonlExportWriter) Close() error {
+	return nil
+}
+
+// ofxTransaction проводка OFX (STMTTRN)
+type ofxTransaction struct {
+	ID     string
+	Posted time.Time
+	Amount float64
+	Name   string
+	Memo   string
+}
+
+// ofxAccount счёт баллов пользователя, по которому строится OFX выписка
+type ofxAccount struct {
+	UserID  int64
+	Balance float64
+}
+
+const ofxTimeFormat = "20060102150405"
+
+// ofxExportWriter выписка в формате OFX 2.2 (XML): одна банковская выписка по счёту баллов,
+// 1 балл = 1 рубль
+type ofxExportWriter struct {
+	w             io.Writer
+	account       ofxAccount
+	headerWritten bool
+}
+
+func (e *ofxExportWriter) writeHeader() error {
+	e.headerWritten = true
+	now := time.Now().UTC().Format(ofxTimeFormat)
+	_, err := fmt.Fprintf(e.w, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>RUS</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>RUB</CURDEF>
+<BANKACCTFROM><BANKID>GOPHERMART</BANKID><ACCTID>%d</ACCTID><ACCTTYPE>SAVINGS</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>
+`, now, e.account.UserID, time.Unix(0, 0).UTC().Format(ofxTimeFormat), now)
+	return err
+}
+
+func (e *ofxExportWriter) Write(record exportRecord) error {
+	if record.transaction == nil {
+		return nil
+	}
+	if !e.headerWritten {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	t := record.transaction
+	trnType := "CREDIT"
+	if t.Amount < 0 {
+		trnType = "DEBIT"
+	}
+	_, err := fmt.Fprintf(e.w,
+		"<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><NAME>%s</NAME><MEMO>%s</MEMO></STMTTRN>\n",
+		trnType, t.Posted.UTC().Format(ofxTimeFormat), strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		ofxEscape(t.ID), ofxEscape(t.Name), ofxEscape(t.Memo))
+	return err
+}
+
+func (e *ofxExportWriter) Close() error {
+	if !e.headerWritten {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(e.w, `</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`, strconv.FormatFloat(e.account.Balance, 'f', 2, 64), time.Now().UTC().Format(ofxTimeFormat))
+	return err
+}
+
+func ofxEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
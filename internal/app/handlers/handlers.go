@@ -1,43 +1,148 @@
 package handlers
 
 import (
+	"encoding/json"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/polosaty/go-dev-final/internal/app/auth"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/credentials"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/polosaty/go-dev-final/internal/app/ratelimit"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"net/http"
+	"strconv"
 )
 
 type mainHandler struct {
 	chiMux     *chi.Mux
 	repository storage.Repository
+	cfg        config.Config
+	tokens     *auth.TokenManager
+	loginGuard *ratelimit.LoginGuard
+	policy     *credentials.Policy
+	fraud      *fraud.Engine
+	proxies    trustedProxies
 }
 
-func NewMainHandler(repository storage.Repository) *chi.Mux {
+func NewMainHandler(repository storage.Repository, cfg config.Config, tokens *auth.TokenManager,
+	loginGuard *ratelimit.LoginGuard, policy *credentials.Policy, fraudEngine *fraud.Engine) *chi.Mux {
 
-	h := &mainHandler{chiMux: chi.NewMux(), repository: repository}
-	h.chiMux.Use(gzipInput)
-	h.chiMux.Use(gzipOutput)
+	h := &mainHandler{
+		chiMux:     chi.NewMux(),
+		repository: repository,
+		cfg:        cfg,
+		tokens:     tokens,
+		loginGuard: loginGuard,
+		policy:     policy,
+		fraud:      fraudEngine,
+	}
+	// адреса уже проверены в config.Validate
+	h.proxies, _ = config.ParseTrustedProxies(cfg.TrustedProxies)
+	h.chiMux.Use(tracing.HTTPMiddleware)
+	h.chiMux.Use(metrics.HTTPMiddleware)
+	h.chiMux.Use(h.corsMiddleware())
+	h.chiMux.Use(decompressInput(cfg.MaxDecompressedRequestSize))
+	h.chiMux.Use(compressOutput(cfg.CompressMinSize, cfg.CompressContentTypes))
 	h.chiMux.Use(middleware.RequestID)
-	h.chiMux.Use(middleware.RealIP)
-	h.chiMux.Use(middleware.Logger)
+	h.chiMux.Use(requestLogger)
 	h.chiMux.Use(middleware.Recoverer)
 
 	h.chiMux.Route("/api/user", func(r chi.Router) {
 		r.Post("/register", h.postRegister())
 		r.Post("/login", h.postLogin())
+		r.Post("/token/refresh", h.postTokenRefresh())
+		r.Post("/token/revoke", h.postTokenRevoke())
 
 		r.Group(func(r chi.Router) {
-			r.Use(authMiddleware(repository))
+			r.Use(h.authMiddleware)
 
+			r.Post("/logout", h.postLogout())
+			r.Post("/password", h.postPassword())
+			r.Get("/export", h.getExport())
+			r.Get("/export/orders", h.getExportOrders())
+			r.Get("/export/withdrawals", h.getExportWithdrawals())
+			r.Get("/export/history", h.getExportHistory())
+			r.Delete("/account", h.deleteAccount())
+			r.Route("/sessions", func(r chi.Router) {
+				r.Get("/", h.getSessions())
+				r.Delete("/others", h.deleteOtherSessions())
+				r.Delete("/{sessionID}", h.deleteSession())
+			})
 			r.Post("/orders", h.postOrder())
 			r.Get("/orders", h.getOrders())
+			r.Get("/orders/{number}", h.getOrder())
+			r.Get("/referrals", h.getReferrals())
 			r.Route("/balance", func(r chi.Router) {
 				r.Get("/", h.getBalance())
 				r.Post("/withdraw", h.postWithdrawal())
 				r.Get("/withdraws", h.getWithdraws())
+				r.Get("/statement", h.getStatement())
+			})
+		})
+
+	})
+
+	h.chiMux.Route("/api/admin", func(r chi.Router) {
+		r.Use(h.authMiddleware)
+		r.Use(h.requireRole(storage.RoleSupport, storage.RoleAdmin))
+
+		r.Get("/users", h.adminGetUsers())
+		r.Route("/users/{userID}", func(r chi.Router) {
+			r.Get("/", h.adminGetUser())
+			r.Get("/orders", h.adminGetUserOrders())
+			r.Get("/withdrawals", h.adminGetUserWithdrawals())
+			r.Get("/sessions", h.adminGetUserSessions())
+			r.Get("/audit", h.adminGetUserAudit())
+			r.Post("/block", h.adminPostUserActive(false))
+			r.Post("/unblock", h.adminPostUserActive(true))
+			r.Get("/withdrawal-limits", h.adminGetWithdrawalLimits())
+
+			r.Group(func(r chi.Router) {
+				r.Use(h.requireRole(storage.RoleAdmin))
+
+				r.Post("/balance", h.adminPostBalanceAdjustment())
+				r.Put("/role", h.adminPutUserRole())
+				r.Put("/withdrawal-limits", h.adminPutWithdrawalLimits())
 			})
 		})
+		r.Post("/orders/{number}/recheck", h.adminPostOrderRecheck())
+		r.Get("/fraud/decisions", h.adminGetFraudDecisions())
+		r.Post("/fraud/decisions/{decisionID}/review", h.adminPostFraudReview())
 
+		r.With(h.requireRole(storage.RoleAdmin)).Get("/audit", h.adminGetAudit())
 	})
 
 	return h.chiMux
 }
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+func pagination(r *http.Request) (limit int, offset int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("marshal response error", "error", err)
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// streamExport пишет выгрузку в ответ по мере чтения строк из базы.
+// stream должен вызывать write для каждой записи; сжатие ответа согласует middleware compressOutput.
+func (h *mainHandler) streamExport(w http.ResponseWriter, r *http.Request, name string, columns []string,
+	stream func(write func(exportRecord) error) error) {
+
+	ctx := r.Context()
+	session := GetSession(r)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportCSV
+	}
+	exportFmt, ok := exportFormats[format]
+	if !ok {
+		http.Error(w, "unknown format, expected csv, jsonl or ofx", http.StatusBadRequest)
+		return
+	}
+
+	account := ofxAccount{UserID: session.UserID}
+	if format == exportOFX {
+		balance, err := h.repository.GetBalance(ctx, session.UserID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get balance error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		account.Balance = balance.Current
+	}
+
+	w.Header().Set("Content-Type", exportFmt.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`,
+		name, time.Now().Format("20060102"), exportFmt.extension))
+	w.WriteHeader(http.StatusOK)
+
+	writer := newExportWriter(format, w, columns, account)
+	if err := stream(writer.Write); err != nil {
+		// заголовки уже отправлены - остаётся только оборвать выгрузку
+		logger.ErrorContext(r.Context(), "export error", "export", name, "error", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		logger.ErrorContext(r.Context(), "export error", "export", name, "error", err)
+	}
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func formatTime(t storage.RFC3339DateTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+// orderExport заказ в выгрузке JSON Lines: в отличие от списка заказов содержит время обработки
+type orderExport struct {
+	storage.Order
+	ProcessedAt *storage.RFC3339DateTime `json:"processed_at,omitempty"`
+}
+
+// getExportOrders handles
+// GET /api/user/export/orders?format=csv|jsonl|ofx — выгрузка всех заказов пользователя;
+// в OFX попадают только начисления по обработанным заказам;
+// 200 — успешная обработка запроса;
+// 400 — неизвестный формат;
+// 401 — пользователь не авторизован;
+// 500 — внутренняя ошибка сервера;
+func (h *mainHandler) getExportOrders() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		columns := []string{"number", "status", "accrual", "uploaded_at", "processed_at"}
+
+		h.streamExport(w, r, "orders", columns, func(write func(exportRecord) error) error {
+			return h.repository.ExportOrders(r.Context(), session.UserID, func(order storage.Order) error {
+				object := orderExport{Order: order}
+				if order.ProcessedAt.Valid {
+					object.ProcessedAt = &order.ProcessedAt
+				}
+				record := exportRecord{object: object}
+				accrual := ""
+				if order.Accrual != nil {
+					accrual = formatAmount(*order.Accrual)
+					if order.Status == "PROCESSED" && *order.Accrual != 0 {
+						record.transaction = &ofxTransaction{
+							ID:     "order-" + order.OrderNum,
+							Posted: order.ProcessedAt.Time,
+							Amount: *order.Accrual,
+							Name:   "Начисление за заказ " + order.OrderNum,
+						}
+					}
+				}
+				record.fields = []string{order.OrderNum, order.Status, accrual,
+					formatTime(order.UploadedAt), formatTime(order.ProcessedAt)}
+				return write(record)
+			})
+		})
+	}
+}
+
+// getExportWithdrawals handles
+// GET /api/user/export/withdrawals?format=csv|jsonl|ofx — выгрузка всех списаний пользователя;
+// 200 — успешная обработка запроса;
+// 400 — неизвестный формат;
+// 401 — пользователь не авторизован;
+// 500 — внутренняя ошибка сервера;
+func (h *mainHandler) getExportWithdrawals() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		columns := []string{"order", "sum", "processed_at"}
+
+		h.streamExport(w, r, "withdrawals", columns, func(write func(exportRecord) error) error {
+			return h.repository.ExportWithdrawals(r.Context(), session.UserID, func(withdrawal storage.Withdrawal) error {
+				return write(exportRecord{
+					fields: []string{withdrawal.OrderNum, formatAmount(withdrawal.Sum), formatTime(withdrawal.ProcessedAt)},
+					object: withdrawal,
+					transaction: &ofxTransaction{
+						ID:     fmt.Sprintf("withdrawal-%s-%d", withdrawal.OrderNum, withdrawal.ProcessedAt.Time.UnixNano()),
+						Posted: withdrawal.ProcessedAt.Time,
+						Amount: -withdrawal.Sum,
+						Name:   "Списание в счёт заказа " + withdrawal.OrderNum,
+					},
+				})
+			})
+		})
+	}
+}
+
+// getExportHistory handles
+// GET /api/user/export/history?format=csv|jsonl|ofx — выгрузка всех движений по счёту баллов
+// (начисления, списания, корректировки) с балансом после каждого движения;
+// 200 — успешная обработка запроса;
+// 400 — неизвестный формат;
+// 401 — пользователь не авторизован;
+// 500 — внутренняя ошибка сервера;
+func (h *mainHandler) getExportHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		columns := []string{"processed_at", "type", "order", "reason", "amount", "balance"}
+
+		h.streamExport(w, r, "history", columns, func(write func(exportRecord) error) error {
+			return h.repository.ExportHistory(r.Context(), session.UserID, func(entry storage.StatementEntry) error {
+				var orderNum, reason string
+				if entry.OrderNum != nil {
+					orderNum = *entry.OrderNum
+				}
+				if entry.Reason != nil {
+					reason = *entry.Reason
+				}
+				return write(exportRecord{
+					fields: []string{formatTime(entry.ProcessedAt), entry.Type, orderNum, reason,
+						formatAmount(entry.Amount), formatAmount(entry.Balance)},
+					object: entry,
+					transaction: &ofxTransaction{
+						ID:     fmt.Sprintf("%s-%s-%d", entry.Type, orderNum, entry.ProcessedAt.Time.UnixNano()),
+						Posted: entry.ProcessedAt.Time,
+						Amount: entry.Amount,
+						Name:   entry.Type,
+						Memo:   orderNum + reason,
+					},
+				})
+			})
+		})
+	}
+}
@@ -3,89 +3,155 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"github.com/polosaty/go-dev-final/internal/app/credentials"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
-	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type login struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	// WithTokens вместо cookie auth выдать пару access/refresh токенов
+	WithTokens bool `json:"with_tokens,omitempty"`
+	// ReferralCode код пригласившего пользователя, только при регистрации
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
 // postRegister handles
 // POST /api/user/register - регистрация пользователя;
+// логин приводится к нижнему регистру, логин и пароль проверяются по правилам из конфигурации;
+// с "with_tokens": true в теле запроса вместо cookie в ответе выдаётся пара access/refresh токенов;
+// "referral_code" - необязательный код пригласившего пользователя;
 // 200 - пользователь успешно зарегистрирован и аутентифицирован;
-// 400 - неверный формат запроса;
+// 400 - неверный формат запроса, логин/пароль не соответствуют правилам или неизвестный реферальный код
+// (в теле - {"errors": [{"field", "code", "message"}]});
 // 409 - логин уже занят;
+// 429 - слишком много попыток с этого адреса (см. Retry-After);
+// 503 - сервер перегружен проверками паролей (см. Retry-After);
 // 500 - внутренняя ошибка сервера.
 func (h *mainHandler) postRegister() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		if !h.allowIP(w, r) {
+			return
+		}
 		var loginData login
 		if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		userID, err := h.repository.CreateUser(ctx, loginData.Login, loginData.Password)
+		loginData.Login = credentials.NormalizeLogin(loginData.Login)
+		if verr := h.policy.Validate(loginData.Login, loginData.Password); verr != nil {
+			writeJSON(w, http.StatusBadRequest, verr)
+			return
+		}
+		if !h.acquireBcrypt(w, r) {
+			return
+		}
+		userID, err := h.repository.CreateUser(ctx, loginData.Login, loginData.Password,
+			strings.TrimSpace(loginData.ReferralCode))
+		h.loginGuard.Bcrypt.Release()
 		if err != nil {
-			log.Println("create user error", err)
+			logger.ErrorContext(r.Context(), "create user error", "error", err)
 			if errors.Is(err, storage.ErrDuplicateUser) {
 				http.Error(w, err.Error(), http.StatusConflict)
 				return
 			}
+			if errors.Is(err, storage.ErrReferralCodeNotFound) {
+				writeJSON(w, http.StatusBadRequest, credentials.NewValidationError(
+					"referral_code", credentials.CodeNotFound, err.Error()))
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		session, err := h.repository.CreateSession(ctx, userID)
-		if err != nil {
-			log.Println("create session error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		metrics.Registrations.Inc()
+		if loginData.WithTokens {
+			h.writeTokens(w, r, userID)
 			return
 		}
-		cookie := &http.Cookie{
-			Path:    "/",
-			Name:    "auth",
-			Value:   session.Token,
-			Expires: session.ExpiresAt,
+		if err = h.startSession(w, r, userID); err != nil {
+			logger.ErrorContext(r.Context(), "create session error", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		http.SetCookie(w, cookie)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
 // postLogin handles
-// POST /api/user/login - аутентификация пользователя;
+// POST /api/user/login - аутентификация пользователя, логин сравнивается без учёта регистра;
+// с "with_tokens": true в теле запроса вместо cookie в ответе выдаётся пара access/refresh токенов;
 // 200 - пользователь успешно аутентифицирован;
 // 400 - неверный формат запроса;
 // 401 - неверная пара логин/пароль;
+// 403 - пользователь заблокирован;
+// 429 - слишком много попыток, пользователь временно заблокирован (см. Retry-After);
+// 503 - сервер перегружен проверками паролей (см. Retry-After);
 // 500 - внутренняя ошибка сервера.
 func (h *mainHandler) postLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		if !h.allowIP(w, r) {
+			return
+		}
 		var loginData login
 		if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		session, err := h.repository.LoginUser(ctx, loginData.Login, loginData.Password)
+		loginData.Login = credentials.NormalizeLogin(loginData.Login)
+		if !h.allowLogin(w, r, loginData.Login) {
+			return
+		}
+		if !h.acquireBcrypt(w, r) {
+			return
+		}
+		userID, err := h.repository.LoginUser(ctx, loginData.Login, loginData.Password)
+		h.loginGuard.Bcrypt.Release()
 		if err != nil {
-			log.Println("login user error", err)
-			if errors.Is(err, storage.ErrWrongLogin) || errors.Is(err, storage.ErrWrongPassword) {
+			logger.ErrorContext(r.Context(), "login user error", "error", err)
+			var lockedErr *storage.UserLockedError
+			if errors.As(err, &lockedErr) {
+				metrics.Logins.WithLabelValues(metrics.LoginLocked).Inc()
+				tooManyRequests(w, time.Until(lockedErr.Until))
+				return
+			}
+			if errors.Is(err, storage.ErrWrongPassword) {
+				metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
+				err = h.repository.RegisterLoginFailure(ctx, loginData.Login, h.cfg.LoginMaxFailures, h.cfg.LoginLockDuration)
+				if err != nil {
+					logger.ErrorContext(r.Context(), "register login failure error", "error", err)
+				}
+				http.Error(w, storage.ErrWrongPassword.Error(), http.StatusUnauthorized)
+				return
+			}
+			if errors.Is(err, storage.ErrUserBlocked) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if errors.Is(err, storage.ErrWrongLogin) {
+				metrics.Logins.WithLabelValues(metrics.LoginFailure).Inc()
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		metrics.Logins.WithLabelValues(metrics.LoginSuccess).Inc()
 
-		cookie := &http.Cookie{
-			Path:    "/",
-			Name:    "auth",
-			Value:   session.Token,
-			Expires: session.ExpiresAt,
+		if loginData.WithTokens {
+			h.writeTokens(w, r, userID)
+			return
+		}
+		if err = h.startSession(w, r, userID); err != nil {
+			logger.ErrorContext(r.Context(), "create session error", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		http.SetCookie(w, cookie)
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -3,9 +3,12 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
 )
@@ -16,6 +19,8 @@ import (
 // 202 — новый номер заказа принят в обработку;
 // 400 — неверный формат запроса;
 // 401 — пользователь не аутентифицирован;
+// 403 — загрузка отклонена правилами антифрода (слишком частые загрузки, перебор чужих номеров)
+// или пользователь заблокирован;
 // 409 — номер заказа уже был загружен другим пользователем;
 // 422 — неверный формат номера заказа;
 // 500 — внутренняя ошибка сервера;
@@ -41,9 +46,28 @@ func (h *mainHandler) postOrder() http.HandlerFunc {
 			http.Error(w, "order number is invalid", http.StatusUnprocessableEntity)
 			return
 		}
+		r = r.WithContext(logging.WithOrder(ctx, orderStr))
+		ctx = r.Context()
+		// повторная загрузка своего номера идемпотентна и правилами антифрода не проверяется
+		uploaded, err := h.repository.IsOrderUploaded(ctx, session.UserID, orderStr)
+		if err != nil {
+			logger.ErrorContext(ctx, "check order uploaded error", "error", err)
+		}
+		if !uploaded {
+			outcome := h.fraud.Evaluate(ctx, fraud.Event{
+				Action:   storage.FraudActionOrderUpload,
+				UserID:   session.UserID,
+				OrderNum: orderStr,
+				IP:       h.clientIP(r),
+			})
+			if outcome == storage.FraudBlock {
+				http.Error(w, "rejected by fraud rules", http.StatusForbidden)
+				return
+			}
+		}
 		err = h.repository.CreateOrder(ctx, session.UserID, orderStr)
 		if err != nil {
-			log.Println("create order error", err)
+			logger.ErrorContext(r.Context(), "create order error", "error", err)
 			if errors.Is(err, storage.ErrOrderConflict) {
 				http.Error(w, err.Error(), http.StatusConflict)
 				return
@@ -53,10 +77,16 @@ func (h *mainHandler) postOrder() http.HandlerFunc {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
+			if errors.Is(err, storage.ErrUserBlocked) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		//новый номер заказа принят в обработку;
+		logger.InfoContext(ctx, "order uploaded")
+		metrics.OrdersUploaded.Inc()
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
@@ -64,7 +94,9 @@ func (h *mainHandler) postOrder() http.HandlerFunc {
 // getOrders handles
 // GET /api/user/orders — получение списка загруженных пользователем номеров заказов,
 // статусов их обработки и информации о начислениях;
+// поддерживает условный запрос по ETag (If-None-Match) и Last-Modified (If-Modified-Since);
 // 204 — нет данных для ответа;
+// 304 — заказы не изменились с прошлого запроса;
 // 401 — пользователь не авторизован;
 // 500 — внутренняя ошибка сервера;
 func (h *mainHandler) getOrders() http.HandlerFunc {
@@ -72,10 +104,13 @@ func (h *mainHandler) getOrders() http.HandlerFunc {
 		ctx := r.Context()
 		//take userID from context
 		session := GetSession(r)
+		if h.notModified(w, r) {
+			return
+		}
 
 		orders, err := h.repository.GetOrders(ctx, session.UserID)
 		if err != nil {
-			log.Println(err)
+			logger.ErrorContext(r.Context(), "get orders error", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -90,7 +125,39 @@ func (h *mainHandler) getOrders() http.HandlerFunc {
 
 		err = json.NewEncoder(w).Encode(orders)
 		if err != nil {
-			log.Println("marshal response error: ", err)
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
 		}
 	}
 }
+
+// getOrder handles
+// GET /api/user/orders/{number} — получение заказа пользователя с начислением, временем загрузки и обработки
+// и полной историей смены статусов;
+// 200 — успешная обработка запроса;
+// 401 — пользователь не авторизован;
+// 404 — заказ не найден (или загружен другим пользователем);
+// 500 — внутренняя ошибка сервера;
+func (h *mainHandler) getOrder() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		//take userID from context
+		session := GetSession(r)
+
+		number := chi.URLParam(r, "number")
+		r = r.WithContext(logging.WithOrder(ctx, number))
+		ctx = r.Context()
+
+		order, err := h.repository.GetOrder(ctx, session.UserID, number)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.ErrorContext(r.Context(), "get order error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, order)
+	}
+}
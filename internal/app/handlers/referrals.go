@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// getReferrals handles
+// GET /api/user/referrals?limit=&offset= — реферальный код пользователя, бонусы программы
+// и приглашённые им пользователи (логины замаскированы, новые первыми);
+// status приглашения: pending — у приглашённого ещё нет обработанных заказов,
+// rewarded — бонусы начислены, capped — не начислены из-за лимита вознаграждаемых приглашений;
+// 200 — успешная обработка запроса;
+// 401 — пользователь не авторизован;
+// 500 — внутренняя ошибка сервера.
+func (h *mainHandler) getReferrals() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(r)
+		limit, offset := pagination(r)
+
+		referrals, err := h.repository.GetReferrals(r.Context(), session.UserID, limit, offset)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get referrals error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, referrals)
+	}
+}
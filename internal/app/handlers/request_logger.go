@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var logger = logging.Component("http")
+
+type requestLogInfoKeyType struct{}
+
+var requestLogInfoKey = requestLogInfoKeyType{}
+
+// requestLogInfo то, что становится известно глубже по цепочке middleware (пользователь после
+// authMiddleware), а нужно в итоговой строке лога запроса
+type requestLogInfo struct {
+	userID int64
+}
+
+// withLogUser привязывает пользователя к логам запроса
+func withLogUser(r *http.Request, userID int64) *http.Request {
+	if info, ok := r.Context().Value(requestLogInfoKey).(*requestLogInfo); ok {
+		info.userID = userID
+	}
+	return r.WithContext(logging.WithUserID(r.Context(), userID))
+}
+
+// requestLogger привязывает request id к контексту (дальше он попадает во все логи запроса,
+// в том числе в storage и auth) и пишет строку лога на каждый запрос вместо middleware.Logger
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestLogInfo{}
+		ctx := logging.WithRequestID(r.Context(), middleware.GetReqID(r.Context()))
+		r = r.WithContext(context.WithValue(ctx, requestLogInfoKey, info))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		logger.Log(logging.WithUserID(ctx, info.userID), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
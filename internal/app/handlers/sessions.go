@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"net/http"
+	"strconv"
+)
+
+// startSession создаёт сессию пользователя и выставляет cookie auth
+func (h *mainHandler) startSession(w http.ResponseWriter, r *http.Request, userID int64) error {
+	session, err := h.repository.CreateSession(r.Context(), storage.Session{
+		UserID:    userID,
+		IP:        h.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}, h.cfg.SessionTTL)
+	if err != nil {
+		return err
+	}
+	h.setAuthCookie(w, session)
+	return nil
+}
+
+// postLogout handles
+// POST /api/user/logout - завершение текущей сессии пользователя;
+// 200 - сессия завершена;
+// 400 - запрос с access токеном: у него нет сессии, выход - отзыв refresh токена (POST /api/user/token/revoke);
+// 401 - пользователь не авторизован;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) postLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+		if session.ID == 0 {
+			http.Error(w, "bearer token has no session, revoke refresh token via POST /api/user/token/revoke",
+				http.StatusBadRequest)
+			return
+		}
+
+		err := h.repository.DeleteSession(ctx, session.UserID, session.ID)
+		if err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+			logger.ErrorContext(r.Context(), "delete session error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.clearAuthCookie(w)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// getSessions handles
+// GET /api/user/sessions - получение списка активных сессий пользователя;
+// 200 - успешная обработка запроса;
+// 401 - пользователь не авторизован;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) getSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+
+		sessions, err := h.repository.GetSessions(ctx, session.UserID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "get sessions error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == session.ID
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(sessions)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
+		}
+	}
+}
+
+// deleteSession handles
+// DELETE /api/user/sessions/{sessionID} - отзыв одной сессии пользователя;
+// 200 - сессия отозвана;
+// 400 - неверный идентификатор сессии;
+// 401 - пользователь не авторизован;
+// 404 - сессия не найдена;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) deleteSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+
+		sessionID, err := strconv.ParseInt(chi.URLParam(r, "sessionID"), 10, 64)
+		if err != nil {
+			http.Error(w, "cant parse session id", http.StatusBadRequest)
+			return
+		}
+
+		err = h.repository.DeleteSession(ctx, session.UserID, sessionID)
+		if err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.ErrorContext(r.Context(), "delete session error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		if sessionID == session.ID {
+			h.clearAuthCookie(w)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// deleteOtherSessions handles
+// DELETE /api/user/sessions/others - отзыв всех сессий пользователя, кроме текущей;
+// 200 - сессии отозваны;
+// 401 - пользователь не авторизован;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) deleteOtherSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		session := GetSession(r)
+
+		deleted, err := h.repository.DeleteOtherSessions(ctx, session.UserID, session.ID)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "delete sessions error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(struct {
+			Deleted int64 `json:"deleted"`
+		}{deleted})
+		if err != nil {
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
+		}
+	}
+}
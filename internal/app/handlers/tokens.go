@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"net/http"
+)
+
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// writeTokens выпускает пару access/refresh токенов и отдаёт её в ответе
+func (h *mainHandler) writeTokens(w http.ResponseWriter, r *http.Request, userID int64) {
+	refreshToken, err := h.repository.CreateRefreshToken(r.Context(), userID, h.cfg.RefreshTokenTTL)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "create refresh token error", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	h.writeTokenPair(w, r, userID, refreshToken)
+}
+
+func (h *mainHandler) writeTokenPair(w http.ResponseWriter, r *http.Request, userID int64, refreshToken *storage.RefreshToken) {
+	accessToken, err := h.tokens.IssueAccessToken(userID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "issue access token error", "error", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(tokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(h.tokens.AccessTTL().Seconds()),
+		RefreshToken: refreshToken.Token,
+	})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "marshal response error", "error", err)
+	}
+}
+
+// postTokenRefresh handles
+// POST /api/user/token/refresh - обмен refresh токена на новую пару access/refresh токенов;
+// предъявленный refresh токен отзывается;
+// 200 - токены выпущены;
+// 400 - неверный формат запроса;
+// 401 - refresh токен неверный, просрочен или отозван;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) postTokenRefresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request refreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+			http.Error(w, "refresh_token required", http.StatusBadRequest)
+			return
+		}
+
+		refreshToken, err := h.repository.RotateRefreshToken(ctx, request.RefreshToken, h.cfg.RefreshTokenTTL)
+		if err != nil {
+			if errors.Is(err, storage.ErrWrongToken) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			logger.ErrorContext(r.Context(), "rotate refresh token error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.writeTokenPair(w, r, refreshToken.UserID, refreshToken)
+	}
+}
+
+// postTokenRevoke handles
+// POST /api/user/token/revoke - отзыв refresh токена;
+// 200 - токен отозван (или уже был недействителен);
+// 400 - неверный формат запроса;
+// 500 - внутренняя ошибка сервера.
+func (h *mainHandler) postTokenRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var request refreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+			http.Error(w, "refresh_token required", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.repository.RevokeRefreshToken(ctx, request.RefreshToken); err != nil {
+			logger.ErrorContext(r.Context(), "revoke refresh token error", "error", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -3,8 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
-	"log"
 	"net/http"
 	"strconv"
 )
@@ -14,7 +15,12 @@ import (
 // 200 - успешная обработка запроса;
 // 401 - пользователь не авторизован;
 // 402 - на счету недостаточно средств;
-// 422 - неверный номер заказа;
+// 403 - запрос с cookie auth отправлен со стороннего сайта (защита от CSRF)
+// или списание отклонено правилами антифрода (новый аккаунт, списание сразу после начисления),
+// или пользователь заблокирован;
+// 409 - списание нарушает ограничения (минимальная сумма, максимум за списание, день или месяц),
+// в теле JSON с нарушенным ограничением и остатком за период;
+// 422 - неверный номер заказа или сумма списания не больше нуля;
 // 500 - внутренняя ошибка сервера.
 func (h *mainHandler) postWithdrawal() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -34,25 +40,84 @@ func (h *mainHandler) postWithdrawal() http.HandlerFunc {
 			http.Error(w, "order number is invalid", http.StatusUnprocessableEntity)
 			return
 		}
+		// решения антифрода записываются только для запросов, которые могли бы пройти
+		if !storage.WithdrawalSumIsValid(withdrawal.Sum) {
+			http.Error(w, storage.ErrInvalidWithdrawalSum.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err = h.repository.CheckUserActive(ctx, session.UserID); err != nil {
+			if errors.Is(err, storage.ErrUserBlocked) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			logger.ErrorContext(ctx, "check user active error", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		outcome := h.fraud.Evaluate(ctx, fraud.Event{
+			Action:   storage.FraudActionWithdrawal,
+			UserID:   session.UserID,
+			OrderNum: withdrawal.OrderNum,
+			Sum:      withdrawal.Sum,
+			IP:       h.clientIP(r),
+		})
+		if outcome == storage.FraudBlock {
+			http.Error(w, "rejected by fraud rules", http.StatusForbidden)
+			return
+		}
 		err = h.repository.CreateWithdrawal(ctx, session.UserID, withdrawal)
+		var limitErr *storage.WithdrawalLimitError
+		if errors.As(err, &limitErr) {
+			logger.InfoContext(ctx, "withdrawal limit exceeded", "limit", limitErr.Limit, "sum", withdrawal.Sum)
+			writeJSON(w, http.StatusConflict, newWithdrawalLimitResponse(limitErr))
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidWithdrawalSum) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		if err != nil {
-			log.Println("create withdrawal error", err)
+			logger.ErrorContext(r.Context(), "create withdrawal error", "error", err)
 			if errors.Is(err, storage.ErrInsufficientBalance) {
 				http.Error(w, err.Error(), http.StatusPaymentRequired)
 				return
 			}
+			if errors.Is(err, storage.ErrUserBlocked) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		//успешная обработка запроса;
+		metrics.PointsWithdrawn.Add(withdrawal.Sum)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+// withdrawalLimitResponse тело ответа 409 на списание, нарушающее ограничения
+type withdrawalLimitResponse struct {
+	Error string  `json:"error"`
+	Limit string  `json:"limit"`
+	Value float64 `json:"value"`
+	// Remaining сколько ещё можно списать за период (для per_day и per_month)
+	Remaining *float64 `json:"remaining,omitempty"`
+}
+
+func newWithdrawalLimitResponse(err *storage.WithdrawalLimitError) withdrawalLimitResponse {
+	response := withdrawalLimitResponse{Error: storage.ErrWithdrawalLimit.Error(), Limit: err.Limit, Value: err.Value}
+	if err.Limit == storage.WithdrawalLimitPerDay || err.Limit == storage.WithdrawalLimitPerMonth {
+		response.Remaining = &err.Remaining
+	}
+	return response
+}
+
 // getWithdraws handles
 // GET /api/user/balance/withdrawals — получение информации о выводе средств с накопительного счёта пользователем.
+// Поддерживает условный запрос по ETag (If-None-Match) и Last-Modified (If-Modified-Since).
 // 204 - нет ни одного списания.
+// 304 - списания не изменились с прошлого запроса.
 // 401 - пользователь не авторизован.
 // 500 - внутренняя ошибка сервера.
 func (h *mainHandler) getWithdraws() http.HandlerFunc {
@@ -60,10 +125,13 @@ func (h *mainHandler) getWithdraws() http.HandlerFunc {
 		ctx := r.Context()
 		//take userID from context
 		session := GetSession(r)
+		if h.notModified(w, r) {
+			return
+		}
 
 		orders, err := h.repository.GetWithdrawals(ctx, session.UserID)
 		if err != nil {
-			log.Println(err)
+			logger.ErrorContext(r.Context(), "get withdrawals error", "error", err)
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
@@ -78,7 +146,7 @@ func (h *mainHandler) getWithdraws() http.HandlerFunc {
 
 		err = json.NewEncoder(w).Encode(orders)
 		if err != nil {
-			log.Println("marshal response error: ", err)
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
 		}
 	}
 }
@@ -0,0 +1,152 @@
+// Package health проверки живости (/healthz) и готовности (/readyz) для оркестратора
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.Component("health")
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// CheckFunc проверка одной зависимости; details попадают в подробный ответ /readyz
+type CheckFunc func(ctx context.Context) (details interface{}, err error)
+
+type CheckResult struct {
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check CheckFunc
+}
+
+// Health набор проверок готовности. Результат кэшируется на cacheTTL, чтобы частые запросы
+// проб (и нескольких проб сразу) не нагружали базу и систему расчёта баллов.
+type Health struct {
+	checks   []namedCheck
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	shuttingDown atomic.Bool
+
+	mu     sync.Mutex
+	cached *Report
+}
+
+func New(cacheTTL time.Duration, timeout time.Duration) *Health {
+	return &Health{cacheTTL: cacheTTL, timeout: timeout}
+}
+
+// Add добавляет проверку готовности
+func (h *Health) Add(name string, check CheckFunc) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// SetShuttingDown переводит сервис в режим остановки: /readyz начинает отвечать 503,
+// чтобы балансировщик перестал присылать новые запросы
+func (h *Health) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Ready выполняет проверки (или берёт результат из кэша)
+func (h *Health) Ready(ctx context.Context) Report {
+	if h.shuttingDown.Load() {
+		return Report{
+			Status:    StatusFail,
+			CheckedAt: time.Now(),
+			Checks:    map[string]CheckResult{"shutdown": {Status: StatusFail, Error: "shutting down"}},
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cached != nil && time.Since(h.cached.CheckedAt) < h.cacheTTL {
+		return *h.cached
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, CheckedAt: time.Now(), Checks: make(map[string]CheckResult, len(h.checks))}
+	results := make([]CheckResult, len(h.checks))
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			details, err := c.check(ctx)
+			result := CheckResult{Status: StatusOK, DurationMs: time.Since(start).Milliseconds(), Details: details}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+			results[i] = result
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range h.checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+			logger.WarnContext(ctx, "readiness check failed", "check", c.name, "error", results[i].Error)
+		}
+	}
+	h.cached = &report
+	return report
+}
+
+// LivenessHandler GET /healthz - процесс жив и обслуживает запросы, зависимости не проверяются
+// (иначе недоступная база приводила бы к бесконечным перезапускам)
+func LivenessHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(StatusOK))
+}
+
+// ReadinessHandler GET /readyz - 200, если сервис готов принимать запросы, иначе 503;
+// detailed - отдавать результаты всех проверок в JSON (только на служебном порту)
+func (h *Health) ReadinessHandler(detailed bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.Ready(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+
+		if !detailed {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(report.Status))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			logger.ErrorContext(r.Context(), "marshal response error", "error", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+)
+
+type contextKey struct{}
+
+// fields атрибуты, привязанные к контексту запроса
+type fields struct {
+	requestID string
+	userID    int64
+	order     string
+}
+
+func fromContext(ctx context.Context) fields {
+	if ctx == nil {
+		return fields{}
+	}
+	f, _ := ctx.Value(contextKey{}).(fields)
+	return f
+}
+
+// WithRequestID привязывает к контексту идентификатор запроса
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	f := fromContext(ctx)
+	f.requestID = requestID
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// WithUserID привязывает к контексту пользователя
+func WithUserID(ctx context.Context, userID int64) context.Context {
+	f := fromContext(ctx)
+	f.userID = userID
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// WithOrder привязывает к контексту номер заказа
+func WithOrder(ctx context.Context, order string) context.Context {
+	f := fromContext(ctx)
+	f.order = order
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+// RequestID идентификатор запроса из контекста
+func RequestID(ctx context.Context) string {
+	return fromContext(ctx).requestID
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	f := fromContext(ctx)
+	var attrs []slog.Attr
+	if f.requestID != "" {
+		attrs = append(attrs, slog.String("request_id", f.requestID))
+	}
+	if f.userID != 0 {
+		attrs = append(attrs, slog.Int64("user_id", f.userID))
+	}
+	if f.order != "" {
+		attrs = append(attrs, slog.String("order", f.order))
+	}
+	// для перехода из лога к трассировке запроса
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		attrs = append(attrs,
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()))
+	}
+	return attrs
+}
@@ -0,0 +1,200 @@
+// Package logging структурированный логгер (log/slog) с уровнями по компонентам, которые можно
+// менять на лету, и атрибутами запроса (request_id, user_id, order), передаваемыми через context.Context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Форматы вывода
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Default компонент, уровень которого используется для компонентов без собственного уровня
+const Default = "default"
+
+var (
+	mu      sync.RWMutex
+	handler slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{ReplaceAttr: redact})
+	levels               = map[string]*slog.LevelVar{Default: new(slog.LevelVar)}
+)
+
+// Setup настраивает вывод всех логгеров: format - json или logfmt, level - уровень по умолчанию,
+// componentLevels - уровни отдельных компонентов ("storage=debug,order_checker=warn")
+func Setup(w io.Writer, format string, level string, componentLevels string) error {
+	options := &slog.HandlerOptions{
+		// фильтрацию по уровню делает componentHandler
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redact,
+	}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, options)
+	case FormatLogfmt, "text", "":
+		h = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q, expected json or logfmt", format)
+	}
+
+	if err := SetLevels(level, componentLevels); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	handler = h
+	mu.Unlock()
+
+	// стандартный log (сторонние библиотеки) тоже пишет через slog
+	slog.SetDefault(slog.New(&componentHandler{component: "std"}))
+	return nil
+}
+
+// Component логгер компонента (storage, order_checker, http...). Уровень компонента задаётся SetLevel,
+// пока он не задан - используется уровень Default.
+func Component(name string) *slog.Logger {
+	return slog.New(&componentHandler{component: name})
+}
+
+// SetLevel меняет уровень компонента на лету; пустой level у компонента возвращает его к уровню Default
+func SetLevel(component string, level string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if level == "" && component != Default {
+		delete(levels, component)
+		return nil
+	}
+	levelVar, ok := levels[component]
+	if !ok {
+		levelVar = new(slog.LevelVar)
+		if err := setLevelVar(levelVar, level); err != nil {
+			return err
+		}
+		levels[component] = levelVar
+		return nil
+	}
+	return setLevelVar(levelVar, level)
+}
+
+// SetLevels заменяет все уровни разом: level - уровень Default, componentLevels - уровни компонентов
+// ("storage=debug,order_checker=warn"); уровни компонентов, которых нет в componentLevels, сбрасываются.
+// При ошибке уровни не меняются.
+func SetLevels(level string, componentLevels string) error {
+	parsed := map[string]*slog.LevelVar{Default: new(slog.LevelVar)}
+	if err := setLevelVar(parsed[Default], level); err != nil {
+		return err
+	}
+	for _, item := range strings.Split(componentLevels, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		component, componentLevel, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("wrong component log level %q, expected component=level", item)
+		}
+		levelVar := new(slog.LevelVar)
+		if err := setLevelVar(levelVar, strings.TrimSpace(componentLevel)); err != nil {
+			return err
+		}
+		parsed[strings.TrimSpace(component)] = levelVar
+	}
+
+	mu.Lock()
+	levels = parsed
+	mu.Unlock()
+	return nil
+}
+
+func setLevelVar(levelVar *slog.LevelVar, level string) error {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("wrong log level %q: %w", level, err)
+	}
+	levelVar.Set(parsed)
+	return nil
+}
+
+// Levels текущие уровни компонентов, для которых они заданы (и Default)
+func Levels() map[string]string {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make(map[string]string, len(levels))
+	for component, level := range levels {
+		result[component] = strings.ToLower(level.Level().String())
+	}
+	return result
+}
+
+func componentLevel(component string) slog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+	if level, ok := levels[component]; ok {
+		return level.Level()
+	}
+	return levels[Default].Level()
+}
+
+func currentHandler() slog.Handler {
+	mu.RLock()
+	defer mu.RUnlock()
+	return handler
+}
+
+// componentHandler добавляет к записи компонент и атрибуты из контекста и фильтрует по уровню компонента.
+// Вывод берётся из текущих настроек при каждой записи, поэтому логгеры можно создавать до Setup.
+type componentHandler struct {
+	component string
+	// ops атрибуты и группы в порядке добавления (With/WithGroup)
+	ops []handlerOp
+}
+
+type handlerOp struct {
+	group string
+	attrs []slog.Attr
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= componentLevel(h.component)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	out := currentHandler().WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	if ctxAttrs := contextAttrs(ctx); len(ctxAttrs) > 0 {
+		out = out.WithAttrs(ctxAttrs)
+	}
+	for _, op := range h.ops {
+		if op.group != "" {
+			out = out.WithGroup(op.group)
+		} else {
+			out = out.WithAttrs(op.attrs)
+		}
+	}
+	return out.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(handlerOp{attrs: attrs})
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(handlerOp{group: name})
+}
+
+func (h *componentHandler) with(op handlerOp) *componentHandler {
+	ops := make([]handlerOp, 0, len(h.ops)+1)
+	return &componentHandler{component: h.component, ops: append(append(ops, h.ops...), op)}
+}
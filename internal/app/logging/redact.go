@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted значение, которым заменяются чувствительные поля
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys части имён атрибутов, значения которых не должны попадать в логи
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie", "signing_key"}
+
+// redact заменяет значения чувствительных атрибутов (в том числе вложенных в группы)
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup {
+		return attr
+	}
+	if IsSensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+// IsSensitive признак того, что по имени поля его значение нельзя логировать
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// dsnPassword пароль в строке подключения вида "host=... password=..."
+var dsnPassword = regexp.MustCompile(`(?i)(password=)('[^']*'|\S+)`)
+
+// RedactURL скрывает пароль в URL или строке подключения к БД
+func RedactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(raw, "${1}"+Redacted)
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// HTTPMiddleware считает запросы и их длительность по шаблону маршрута chi (а не по пути,
+// чтобы номера заказов и id не раздували число рядов)
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil {
+			if pattern := routeCtx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{r.Method, route, strconv.Itoa(status)}
+		HTTPRequests.WithLabelValues(labels...).Inc()
+		HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,150 @@
+// Package metrics метрики Prometheus, отдаются на /metrics админского порта
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "gophermart"
+
+// Registry реестр всех метрик сервиса (вместо глобального prometheus.DefaultRegisterer)
+var Registry = prometheus.NewRegistry()
+
+// HTTP API
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by chi route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// OrderChecker
+var (
+	AccrualRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "request_duration_seconds",
+		Help:      "Accrual system order status request latency (including retries) by outcome.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"outcome"})
+
+	AccrualTooManyRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "too_many_requests_total",
+		Help:      "429 responses from accrual system.",
+	})
+
+	StatusFlushBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "order_checker",
+		Name:      "flush_batch_size",
+		Help:      "Number of order statuses saved by one UpdateOrderStatus call.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100},
+	})
+
+	StatusFlushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "order_checker",
+		Name:      "flush_duration_seconds",
+		Help:      "UpdateOrderStatus latency by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+)
+
+// Бизнес метрики
+var (
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Registered users.",
+	})
+
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by result.",
+	}, []string{"result"})
+
+	OrdersUploaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_uploaded_total",
+		Help:      "New orders accepted for processing.",
+	})
+
+	PointsAccrued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_accrued_total",
+		Help:      "Points accrued by accrual system for processed orders, before tier multiplier.",
+	})
+
+	PointsWithdrawn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Points withdrawn by users.",
+	})
+
+	FraudDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fraud_decisions_total",
+		Help:      "Fraud rules engine decisions by user action and outcome.",
+	}, []string{"action", "outcome"})
+)
+
+// Результаты входа для Logins
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginLocked  = "locked"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		AccrualRequestDuration,
+		AccrualTooManyRequests,
+		StatusFlushBatchSize,
+		StatusFlushDuration,
+		Registrations,
+		Logins,
+		OrdersUploaded,
+		PointsAccrued,
+		PointsWithdrawn,
+		FraudDecisions,
+	)
+}
+
+// RegisterQueue добавляет метрики заполненности очереди (буферизованного канала)
+func RegisterQueue(name string, length func() int, capacity int) {
+	labels := prometheus.Labels{"queue": name}
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "order_checker",
+			Name:        "queue_length",
+			Help:        "Number of items waiting in OrderChecker queue.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(length()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "order_checker",
+			Name:        "queue_capacity",
+			Help:        "OrderChecker queue capacity.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(capacity) }),
+	)
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.Component("ratelimit")
+
+// LoginGuard защита входа и регистрации от перебора паролей и от DoS через дорогой bcrypt.
+// Один экземпляр разделяется HTTP и gRPC API, чтобы лимиты были общими.
+type LoginGuard struct {
+	store Store
+
+	mu     sync.Mutex
+	limits loginLimits
+	// limiters меняются целиком при перечитывании настроек (Reconfigure)
+	limiters atomic.Pointer[loginLimiters]
+
+	// Bcrypt ограничивает число одновременных вычислений bcrypt
+	Bcrypt Semaphore
+}
+
+type loginLimits struct {
+	perIP    int
+	perLogin int
+	window   time.Duration
+}
+
+type loginLimiters struct {
+	perIP    Limiter
+	perLogin Limiter
+}
+
+// NewLoginGuard создаёт защиту по настройкам cfg. store используется, если cfg.RateLimitStore == "postgres".
+func NewLoginGuard(cfg config.Config, store Store) *LoginGuard {
+	if cfg.RateLimitStore != "postgres" {
+		store = nil
+	}
+
+	concurrency := cfg.BcryptConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	g := &LoginGuard{
+		store:  store,
+		Bcrypt: NewSemaphore(concurrency),
+	}
+	g.Reconfigure(cfg)
+	return g
+}
+
+// Reconfigure применяет новые лимиты попыток на лету. Если лимиты изменились, счётчики в памяти
+// начинаются заново (в postgres корзины общие и сохраняются). Размер очереди bcrypt и хранилище не меняются.
+func (g *LoginGuard) Reconfigure(cfg config.Config) {
+	limits := loginLimits{
+		perIP:    cfg.LoginRateLimitPerIP,
+		perLogin: cfg.LoginRateLimitPerLogin,
+		window:   cfg.LoginRateLimitWindow,
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limiters.Load() != nil && g.limits == limits {
+		return
+	}
+	g.limits = limits
+
+	newLimiter := func(limit int) Limiter {
+		if limit <= 0 {
+			return Unlimited{}
+		}
+		return NewLimiter(limit, limits.window, g.store)
+	}
+	g.limiters.Store(&loginLimiters{
+		perIP:    newLimiter(limits.perIP),
+		perLogin: newLimiter(limits.perLogin),
+	})
+}
+
+// AllowIP проверяет лимит попыток с IP клиента
+func (g *LoginGuard) AllowIP(ctx context.Context, ip string) (bool, time.Duration) {
+	return allow(ctx, g.limiters.Load().perIP, "ip:"+ip)
+}
+
+// AllowLogin проверяет лимит попыток входа под логином
+func (g *LoginGuard) AllowLogin(ctx context.Context, login string) (bool, time.Duration) {
+	return allow(ctx, g.limiters.Load().perLogin, "login:"+strings.ToLower(login))
+}
+
+func allow(ctx context.Context, limiter Limiter, key string) (bool, time.Duration) {
+	allowed, retryAfter, err := limiter.Allow(ctx, key)
+	if err != nil {
+		// хранилище лимитов недоступно - не блокируем пользователей из-за этого
+		logger.ErrorContext(ctx, "rate limit error", "key", key, "error", err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// RetryAfterSeconds округляет время до повторной попытки вверх до целых секунд (не меньше 1)
+func RetryAfterSeconds(retryAfter time.Duration) int64 {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter ограничивает частоту событий по ключу (IP, логин и т.п.).
+// Allow списывает одно событие и, если лимит исчерпан, возвращает false и время,
+// через которое стоит повторить попытку.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// Store хранилище состояния token bucket, общее для нескольких экземпляров сервиса.
+// TakeRateLimitToken возвращает количество токенов в корзине до списания
+// (с учётом пополнения со скоростью rate токенов в секунду, но не больше burst).
+// Если токенов меньше одного, списание не выполняется.
+type Store interface {
+	TakeRateLimitToken(ctx context.Context, key string, burst float64, rate float64) (float64, error)
+	DeleteStaleRateLimitTokens(ctx context.Context, olderThan time.Duration) error
+}
+
+// NewLimiter создаёт limiter, пропускающий limit событий за window на ключ (token bucket).
+// Если store == nil, состояние хранится в памяти процесса.
+func NewLimiter(limit int, window time.Duration, store Store) Limiter {
+	bucket := tokenBucket{
+		burst: float64(limit),
+		rate:  float64(limit) / window.Seconds(),
+	}
+	if store != nil {
+		return &storeLimiter{tokenBucket: bucket, store: store, window: window}
+	}
+	return &memoryLimiter{tokenBucket: bucket, window: window, buckets: make(map[string]*bucketState)}
+}
+
+// Unlimited limiter, пропускающий всё
+type Unlimited struct{}
+
+func (Unlimited) Allow(context.Context, string) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+type tokenBucket struct {
+	burst float64
+	rate  float64
+}
+
+// retryAfter через сколько в корзине накопится целый токен
+func (b tokenBucket) retryAfter(tokens float64) time.Duration {
+	seconds := (1 - tokens) / b.rate
+	return time.Duration(math.Ceil(seconds)) * time.Second
+}
+
+type bucketState struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type memoryLimiter struct {
+	tokenBucket
+	window time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucketState
+	lastSweep time.Time
+}
+
+func (l *memoryLimiter) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	state, ok := l.buckets[key]
+	if !ok {
+		state = &bucketState{tokens: l.burst, updatedAt: now}
+		l.buckets[key] = state
+	}
+	state.tokens = math.Min(l.burst, state.tokens+now.Sub(state.updatedAt).Seconds()*l.rate)
+	state.updatedAt = now
+
+	if state.tokens < 1 {
+		return false, l.retryAfter(state.tokens), nil
+	}
+	state.tokens--
+	return true, 0, nil
+}
+
+// sweep удаляет корзины, которые успели наполниться до краёв (они ничем не отличаются от отсутствующих)
+func (l *memoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for key, state := range l.buckets {
+		if now.Sub(state.updatedAt) >= l.window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type storeLimiter struct {
+	tokenBucket
+	store  Store
+	window time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func (l *storeLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.maybeSweep()
+
+	tokens, err := l.store.TakeRateLimitToken(ctx, key, l.burst, l.rate)
+	if err != nil {
+		return false, 0, err
+	}
+	if tokens < 1 {
+		return false, l.retryAfter(tokens), nil
+	}
+	return true, 0, nil
+}
+
+func (l *storeLimiter) maybeSweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = time.Now()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		_ = l.store.DeleteStaleRateLimitTokens(ctx, l.window)
+	}()
+}
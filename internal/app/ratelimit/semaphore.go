@@ -0,0 +1,26 @@
+package ratelimit
+
+import (
+	"context"
+)
+
+// Semaphore ограничивает количество одновременно выполняемых тяжёлых операций (например bcrypt)
+type Semaphore chan struct{}
+
+func NewSemaphore(size int) Semaphore {
+	return make(Semaphore, size)
+}
+
+// Acquire занимает слот, ожидая его освобождения не дольше, чем живёт ctx
+func (s Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s Semaphore) Release() {
+	<-s
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/health"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// newAdminHandler служебное API на отдельном порту, недоступном снаружи:
+// GET /metrics - метрики в формате Prometheus;
+// GET /healthz - процесс жив;
+// GET /readyz - готовность с результатами всех проверок в JSON;
+// GET /log/levels, PUT и DELETE /log/levels/{component} - уровни логов компонентов;
+// GET /config - действующие настройки, POST /config/reload - перечитать настройки.
+func newAdminHandler(healthChecks *health.Health, reloader *configReloader) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{Registry: metrics.Registry}))
+	r.Get("/healthz", health.LivenessHandler)
+	r.Get("/readyz", healthChecks.ReadinessHandler(true))
+	r.Route("/log/levels", func(r chi.Router) {
+		r.Get("/", getLogLevels)
+		r.Put("/{component}", putLogLevel)
+		r.Delete("/{component}", deleteLogLevel)
+	})
+	r.Get("/config", reloader.getConfig)
+	r.Post("/config/reload", reloader.postReload)
+	return r
+}
+
+// getConfig handles
+// GET /config - действующие настройки в YAML, секреты скрыты;
+// 200 - настройки.
+func (r *configReloader) getConfig(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	if err := config.Print(w, r.currentConfig()); err != nil {
+		logger.Error("print config error", "error", err)
+	}
+}
+
+// postReload handles
+// POST /config/reload - перечитать настройки, как по SIGHUP;
+// 200 - {"applied": [...], "restart_required": [...]}: применённые на лету изменения и изменения,
+// которые вступят в силу после перезапуска;
+// 400 - настройки не загрузились или некорректны, действующие настройки не изменились.
+func (r *configReloader) postReload(w http.ResponseWriter, req *http.Request) {
+	result, err := r.reload(req.Context())
+	if err != nil {
+		logger.WarnContext(req.Context(), "reload config error", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.ErrorContext(req.Context(), "marshal response error", "error", err)
+	}
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// getLogLevels handles
+// GET /log/levels - уровни логов: default и компоненты, для которых уровень задан отдельно;
+// 200 - {"default": "info", "storage": "debug"}.
+func getLogLevels(w http.ResponseWriter, _ *http.Request) {
+	writeLogLevels(w)
+}
+
+// putLogLevel handles
+// PUT /log/levels/{component} - смена уровня логов компонента (default - для всех остальных) без перезапуска,
+// тело {"level": "debug"};
+// 200 - уровень изменён, в ответе все уровни;
+// 400 - неверный формат запроса или неизвестный уровень.
+func putLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Level == "" {
+		http.Error(w, "cant parse request", http.StatusBadRequest)
+		return
+	}
+	component := chi.URLParam(r, "component")
+	if err := logging.SetLevel(component, req.Level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.InfoContext(r.Context(), "log level changed", "log_component", component, "level", req.Level)
+	writeLogLevels(w)
+}
+
+// deleteLogLevel handles
+// DELETE /log/levels/{component} - компонент снова логирует с уровнем default;
+// 200 - в ответе все уровни;
+// 400 - уровень default удалить нельзя.
+func deleteLogLevel(w http.ResponseWriter, r *http.Request) {
+	component := chi.URLParam(r, "component")
+	if component == logging.Default {
+		http.Error(w, "default level cant be deleted", http.StatusBadRequest)
+		return
+	}
+	_ = logging.SetLevel(component, "")
+	logger.InfoContext(r.Context(), "log level reset", "log_component", component)
+	writeLogLevels(w)
+}
+
+func writeLogLevels(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(logging.Levels()); err != nil {
+		logger.Error("marshal response error", "error", err)
+	}
+}
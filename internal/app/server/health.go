@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/health"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"net/http"
+	"strings"
+)
+
+// newHealth проверки готовности: база, версия схемы, доступность системы расчёта баллов и OrderChecker
+func newHealth(cfg config.Config, db storage.Repository, orderChecker *OrderChecker) *health.Health {
+	h := health.New(cfg.ReadinessCacheTTL, cfg.ReadinessTimeout)
+
+	h.Add("database", func(ctx context.Context) (interface{}, error) {
+		return nil, db.Ping(ctx)
+	})
+	h.Add("migrations", func(ctx context.Context) (interface{}, error) {
+		version, err := db.CheckMigrations(ctx)
+		return map[string]int{"version": version}, err
+	})
+	h.Add("accrual", func(ctx context.Context) (interface{}, error) {
+		return nil, checkAccrual(ctx, orderChecker.AccrualSystemAddress())
+	})
+	h.Add("order_checker", func(ctx context.Context) (interface{}, error) {
+		return orderChecker.health(cfg.CheckerStaleAfter)
+	})
+	return h
+}
+
+// checkAccrual система расчёта баллов доступна, если отвечает хоть чем-то, кроме 5xx
+func checkAccrual(ctx context.Context, address string) error {
+	if address == "" {
+		return errors.New("accrual system address is not configured")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(address, "/")+"/api/orders/0", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("accrual system responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -6,40 +6,142 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
-	"log"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var checkerLogger = logging.Component("order_checker")
+
 type OrderChecker struct {
-	db                   storage.Repository
-	orderCheckChan       chan storage.OrderForCheckStatus
-	orderUpdateChan      chan storage.OrderUpdateStatus
+	db              storage.Repository
+	orderCheckChan  chan orderCheckTask
+	orderUpdateChan chan orderCheckResult
+	// settings меняются на лету (Reconfigure)
+	settings      atomic.Pointer[checkerSettings]
+	batchSize     int
+	flushInterval time.Duration
+
+	// workers каналы остановки запущенных обработчиков CheckOrders, их число - CheckerConcurrency
+	workersMu   sync.Mutex
+	workers     []chan struct{}
+	workersDone sync.WaitGroup
+	stopped     bool
+
+	// время (unix nano) последних успешных и неуспешных запросов в систему расчёта и сохранений статусов,
+	// по ним /readyz определяет, что OrderChecker застрял
+	startedAt          time.Time
+	lastAccrualSuccess atomic.Int64
+	lastAccrualError   atomic.Int64
+	lastFlush          atomic.Int64
+	lastFlushError     atomic.Int64
+}
+
+// checkerSettings настройки OrderChecker, применяемые без перезапуска
+type checkerSettings struct {
 	accrualSystemAddress string
+	retryCount           int
+	pollLimit            int
+	pollInterval         time.Duration
+	concurrency          int
 }
 
-func NewOrderChecker(db storage.Repository, accrualSystemAddress string) *OrderChecker {
-	return &OrderChecker{
-		db:                   db,
-		orderCheckChan:       make(chan storage.OrderForCheckStatus, 10),
-		orderUpdateChan:      make(chan storage.OrderUpdateStatus, 10),
-		accrualSystemAddress: accrualSystemAddress,
+func NewOrderChecker(db storage.Repository, cfg config.Config) *OrderChecker {
+	c := &OrderChecker{
+		db:              db,
+		orderCheckChan:  make(chan orderCheckTask, cfg.CheckerQueueSize),
+		orderUpdateChan: make(chan orderCheckResult, cfg.CheckerQueueSize),
+		batchSize:       cfg.CheckerBatchSize,
+		flushInterval:   cfg.CheckerFlushInterval,
+		startedAt:       time.Now(),
 	}
+	c.settings.Store(newCheckerSettings(cfg))
+	return c
+}
+
+func newCheckerSettings(cfg config.Config) *checkerSettings {
+	return &checkerSettings{
+		accrualSystemAddress: cfg.AccrualSystemAddress,
+		retryCount:           cfg.AccrualRetryCount,
+		pollLimit:            cfg.CheckerPollLimit,
+		pollInterval:         cfg.CheckerPollInterval,
+		concurrency:          cfg.CheckerConcurrency,
+	}
+}
+
+// Reconfigure применяет новые настройки на лету: адрес системы расчёта и число повторов действуют
+// со следующего запроса, размер выборки и пауза - со следующей выборки. Лишние обработчики
+// завершаются, доделав текущий заказ, недостающие запускаются сразу.
+func (c *OrderChecker) Reconfigure(cfg config.Config) {
+	settings := newCheckerSettings(cfg)
+	c.settings.Store(settings)
+	c.scaleWorkers(settings.concurrency)
+}
+
+// AccrualSystemAddress текущий адрес системы расчёта баллов
+func (c *OrderChecker) AccrualSystemAddress() string {
+	return c.settings.Load().accrualSystemAddress
+}
+
+// orderCheckTask заказ на проверку; span - спан выборки, в которой он попал в очередь
+type orderCheckTask struct {
+	order storage.OrderForCheckStatus
+	span  trace.SpanContext
+}
+
+// orderCheckResult статус для сохранения; span - спан проверки заказа, пачка при сохранении
+// ссылается на спаны всех своих заказов
+type orderCheckResult struct {
+	status storage.OrderUpdateStatus
+	span   trace.SpanContext
 }
 
 func (c *OrderChecker) stop() {
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+	c.stopped = true
 	close(c.orderCheckChan)
 }
 
-func (c *OrderChecker) SelectOrders(ctx context.Context, limit int) {
-	if limit == 0 {
-		limit = 100
+// scaleWorkers запускает или останавливает обработчики CheckOrders, чтобы их стало count
+func (c *OrderChecker) scaleWorkers(count int) {
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+	if c.stopped {
+		return
+	}
+	for len(c.workers) < count {
+		quit := make(chan struct{})
+		c.workers = append(c.workers, quit)
+		c.workersDone.Add(1)
+		go c.CheckOrders(quit)
 	}
+	for len(c.workers) > count {
+		close(c.workers[len(c.workers)-1])
+		c.workers = c.workers[:len(c.workers)-1]
+	}
+}
 
+func (c *OrderChecker) SelectOrders(ctx context.Context) {
 	go c.saveOrderStatuses(ctx) // stops by context
-	go c.CheckOrders()          // stops by closing chanel
+	// обработчики останавливаются закрытием канала, после последнего закрывается канал статусов
+	c.scaleWorkers(c.settings.Load().concurrency)
+	go func() {
+		c.workersDone.Wait()
+		close(c.orderUpdateChan)
+	}()
 
 	var uploadedAfter *time.Time = nil
 
@@ -51,14 +153,26 @@ func (c *OrderChecker) SelectOrders(ctx context.Context, limit int) {
 			c.stop()
 			return
 		default:
+			settings := c.settings.Load()
+			limit := settings.pollLimit
+			start := time.Now()
 			orders, err := c.db.SelectOrdersForCheckStatus(ctx, limit, uploadedAfter)
 			if err != nil {
-				log.Println("error selecting order from check status", err)
+				checkerLogger.ErrorContext(ctx, "select orders for check status error", "error", err)
 				continue
 			}
+			// пустые выборки повторяются каждую секунду, спан есть только у выборок с заказами
+			var selectSpan trace.SpanContext
+			if len(orders) > 0 {
+				_, span := tracing.Start(ctx, "order_checker", "select orders for check",
+					trace.WithNewRoot(), trace.WithTimestamp(start),
+					trace.WithAttributes(attribute.Int("orders", len(orders))))
+				span.End()
+				selectSpan = span.SpanContext()
+			}
 			if len(orders) < limit {
 				if uploadedAfter == nil {
-					time.Sleep(time.Second * 1)
+					time.Sleep(settings.pollInterval)
 				} else {
 					uploadedAfter = nil
 				}
@@ -71,7 +185,7 @@ func (c *OrderChecker) SelectOrders(ctx context.Context, limit int) {
 					c.stop()
 					return
 				default:
-					c.orderCheckChan <- order
+					c.orderCheckChan <- orderCheckTask{order: order, span: selectSpan}
 				}
 			}
 
@@ -80,38 +194,105 @@ func (c *OrderChecker) SelectOrders(ctx context.Context, limit int) {
 
 }
 
-func (c *OrderChecker) CheckOrders() {
-	defer close(c.orderUpdateChan)
+// CheckOrders проверяет заказы из очереди, пока она не закрыта или не закрыт quit
+func (c *OrderChecker) CheckOrders(quit <-chan struct{}) {
+	defer c.workersDone.Done()
+
+	for {
+		var task orderCheckTask
+		select {
+		case <-quit:
+			return
+		case t, ok := <-c.orderCheckChan:
+			if !ok {
+				return
+			}
+			task = t
+		}
+		c.checkTask(task)
+	}
+}
 
-	for order := range c.orderCheckChan {
-		log.Println("check order status: ", order.OrderNum)
-		orderStatus, err := c.CheckOrder(order.OrderNum)
-		if err != nil {
-			log.Println("cant get status for order", err)
-			continue
+// checkTask проверяет заказ в системе расчёта баллов и отправляет окончательный статус на сохранение
+func (c *OrderChecker) checkTask(task orderCheckTask) {
+	ctx := trace.ContextWithSpanContext(context.Background(), task.span)
+	ctx = logging.WithOrder(ctx, task.order.OrderNum)
+	ctx, span := tracing.Start(ctx, "order_checker", "check order",
+		trace.WithAttributes(attribute.String("order", task.order.OrderNum)))
+	checkerLogger.DebugContext(ctx, "check order status")
+	start := time.Now()
+	orderStatus, err := c.CheckOrder(ctx, task.order.OrderNum)
+	outcome := accrualOutcome(orderStatus, err)
+	metrics.AccrualRequestDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	span.SetAttributes(attribute.String("outcome", outcome))
+	if outcome == "error" {
+		tracing.End(span, err)
+	} else {
+		span.End()
+	}
+	if outcome == "error" {
+		c.lastAccrualError.Store(time.Now().UnixNano())
+	} else {
+		c.lastAccrualSuccess.Store(time.Now().UnixNano())
+	}
+	if err != nil {
+		if errors.Is(err, ErrOrderStatusNotReady) {
+			checkerLogger.DebugContext(ctx, "order status not ready")
+		} else {
+			checkerLogger.WarnContext(ctx, "cant get status for order", "error", err)
 		}
-		log.Println("got order status: ", orderStatus.OrderNum, orderStatus.Status)
-		c.orderUpdateChan <- *orderStatus
+		return
 	}
+	checkerLogger.InfoContext(ctx, "got order status", "status", orderStatus.Status, "accrual", orderStatus.Accrual)
+	c.orderUpdateChan <- orderCheckResult{status: *orderStatus, span: span.SpanContext()}
 }
 
 var ErrOrderStatusNotReady = errors.New("order result not ready")
 
-func (c *OrderChecker) CheckOrder(order string) (*storage.OrderUpdateStatus, error) {
-	var result storage.OrderUpdateStatus
+// accrualOutcome исход запроса в систему расчёта баллов для метрик
+func accrualOutcome(orderStatus *storage.OrderUpdateStatus, err error) string {
+	switch {
+	case err == nil:
+		return strings.ToLower(orderStatus.Status)
+	case errors.Is(err, ErrOrderStatusNotReady):
+		return "not_ready"
+	default:
+		return "error"
+	}
+}
+
+func (c *OrderChecker) CheckOrder(ctx context.Context, order string) (result *storage.OrderUpdateStatus, err error) {
+	settings := c.settings.Load()
+	ctx, span := tracing.Start(ctx, "order_checker", "accrual GET /api/orders/{number}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", http.MethodGet),
+			attribute.String("server.address", settings.accrualSystemAddress),
+		))
+	defer func() {
+		if errors.Is(err, ErrOrderStatusNotReady) {
+			span.End()
+			return
+		}
+		tracing.End(span, err)
+	}()
+
 	client := resty.New().
 		AddRetryCondition(func(r *resty.Response, err error) bool {
 			if err != nil {
-				log.Println("resty get order status error: ", err)
+				checkerLogger.WarnContext(ctx, "resty get order status error", "error", err)
 			}
+			span.AddEvent("response", trace.WithAttributes(attribute.Int("http.response.status_code", r.StatusCode())))
 
 			if r.StatusCode() == http.StatusTooManyRequests {
+				metrics.AccrualTooManyRequests.Inc()
+				checkerLogger.InfoContext(ctx, "accrual system rate limit", "retry_after", r.Header().Get("Retry-After"))
 				//read Retry-After: N and sleep N seconds
 				retryAfter := r.Header().Get("Retry-After")
 				var retryAfterInt int64
 				retryAfterInt, err = strconv.ParseInt(retryAfter, 10, 64)
 				if err != nil {
-					log.Println("resty parse header Retry-After error: ", err)
+					checkerLogger.WarnContext(ctx, "resty parse header Retry-After error", "error", err)
 					retryAfterInt = 1
 				}
 				time.Sleep(time.Duration(retryAfterInt) * time.Second)
@@ -120,52 +301,63 @@ func (c *OrderChecker) CheckOrder(order string) (*storage.OrderUpdateStatus, err
 			return r.StatusCode() != http.StatusOK && r.StatusCode() != http.StatusNoContent
 
 		}).
-		SetRetryCount(2).
-		SetBaseURL(c.accrualSystemAddress).
+		SetRetryCount(settings.retryCount).
+		SetBaseURL(settings.accrualSystemAddress).
 		SetDoNotParseResponse(true)
 
-	resp, err := client.R().Get("/api/orders/" + order)
+	// traceparent, чтобы трассировка продолжилась в системе расчёта баллов
+	headers := http.Header{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(headers))
+
+	resp, err := client.R().SetContext(ctx).SetHeaderMultiValues(headers).Get("/api/orders/" + order)
 
 	if err != nil {
 		return nil, fmt.Errorf("cant get order status %w", err)
 	}
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", resp.StatusCode()),
+		attribute.Int("http.request.resend_count", resp.Request.Attempt-1),
+	)
 
 	if resp.StatusCode() == http.StatusNoContent {
 		return nil, ErrOrderStatusNotReady
 	}
 
-	if err = json.NewDecoder(resp.RawResponse.Body).Decode(&result); err != nil {
+	result = &storage.OrderUpdateStatus{}
+	if err = json.NewDecoder(resp.RawResponse.Body).Decode(result); err != nil {
 		return nil, fmt.Errorf("resty parse response error: %w", err)
 	}
 
 	if result.Status != "INVALID" && result.Status != "PROCESSED" {
 		return nil, ErrOrderStatusNotReady
 	}
+	span.SetAttributes(attribute.String("order.status", result.Status))
 	result.ProcessedAt = time.Now()
-	return &result, nil
+	return result, nil
 }
 
 func (c *OrderChecker) saveOrderStatuses(ctx context.Context) {
-	buffLen := 10
+	buffLen := c.batchSize
 	//накапливаем статусы чтобы одним запросом обновить
-	statuses := make([]storage.OrderUpdateStatus, 0, buffLen*2) // *2 чтобы не ресайзить в случае ошибок
+	statuses := make([]orderCheckResult, 0, buffLen*2) // *2 чтобы не ресайзить в случае ошибок
 	//если не набирается полный slice статусов, то по таймауту сбрасываем сколько есть
-	ticker := time.NewTicker(time.Second * 2)
+	ticker := time.NewTicker(c.flushInterval)
 L:
 	for {
 		select {
 		case <-ctx.Done():
 			break L
 		case status := <-c.orderUpdateChan:
-			if status.Status != "INVALID" && status.Status != "PROCESSED" {
-				log.Printf("wrong status to save: %v\n", status)
+			if status.status.Status != "INVALID" && status.status.Status != "PROCESSED" {
+				checkerLogger.ErrorContext(logging.WithOrder(ctx, status.status.OrderNum), "wrong status to save",
+					"status", status.status.Status)
 			} else {
 				statuses = append(statuses, status)
 			}
 
 			if len(statuses) == buffLen {
-				if err := c.db.UpdateOrderStatus(ctx, statuses); err != nil {
-					log.Println("save statuses error: ", err)
+				if err := c.flush(ctx, statuses); err != nil {
+					checkerLogger.ErrorContext(ctx, "save statuses error", "orders", len(statuses), "error", err)
 					continue
 				}
 				statuses = statuses[:0]
@@ -174,8 +366,8 @@ L:
 			if len(statuses) < 1 {
 				continue
 			}
-			if err := c.db.UpdateOrderStatus(ctx, statuses); err != nil {
-				log.Println("save statuses error: ", err)
+			if err := c.flush(ctx, statuses); err != nil {
+				checkerLogger.ErrorContext(ctx, "save statuses error", "orders", len(statuses), "error", err)
 				continue
 			}
 			statuses = statuses[:0]
@@ -185,7 +377,102 @@ L:
 	for status := range c.orderUpdateChan {
 		statuses = append(statuses, status)
 	}
-	if err := c.db.UpdateOrderStatus(ctx, statuses); err != nil {
-		log.Println("save statuses error: ", err)
+	if err := c.flush(ctx, statuses); err != nil {
+		checkerLogger.ErrorContext(ctx, "save statuses error", "orders", len(statuses), "error", err)
+	}
+}
+
+// flush сохраняет накопленные статусы одним запросом. У пачки своя трассировка,
+// она ссылается (span links) на трассировки проверки каждого заказа.
+func (c *OrderChecker) flush(ctx context.Context, results []orderCheckResult) error {
+	statuses := make([]storage.OrderUpdateStatus, 0, len(results))
+	links := make([]trace.Link, 0, len(results))
+	for _, result := range results {
+		statuses = append(statuses, result.status)
+		if result.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: result.span})
+		}
+	}
+	ctx, span := tracing.Start(ctx, "order_checker", "save order statuses",
+		trace.WithNewRoot(), trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("orders", len(statuses))))
+
+	start := time.Now()
+	err := c.db.UpdateOrderStatus(ctx, statuses)
+	tracing.End(span, err)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.StatusFlushDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	metrics.StatusFlushBatchSize.Observe(float64(len(statuses)))
+	if err != nil {
+		c.lastFlushError.Store(time.Now().UnixNano())
+		return err
+	}
+	c.lastFlush.Store(time.Now().UnixNano())
+	checkerLogger.DebugContext(ctx, "order statuses saved", "orders", len(statuses), "duration", time.Since(start))
+	for _, status := range statuses {
+		if status.Status == "PROCESSED" {
+			metrics.PointsAccrued.Add(status.Accrual)
+		}
+	}
+	return nil
+}
+
+// registerMetrics добавляет метрики очередей OrderChecker
+func (c *OrderChecker) registerMetrics() {
+	metrics.RegisterQueue("order_check", func() int { return len(c.orderCheckChan) }, cap(c.orderCheckChan))
+	metrics.RegisterQueue("order_update", func() int { return len(c.orderUpdateChan) }, cap(c.orderUpdateChan))
+}
+
+// checkerHealth состояние OrderChecker для подробного ответа /readyz
+type checkerHealth struct {
+	LastAccrualSuccess *time.Time `json:"last_accrual_success,omitempty"`
+	LastAccrualError   *time.Time `json:"last_accrual_error,omitempty"`
+	LastFlush          *time.Time `json:"last_flush,omitempty"`
+	LastFlushError     *time.Time `json:"last_flush_error,omitempty"`
+	CheckQueueLength   int        `json:"check_queue_length"`
+	UpdateQueueLength  int        `json:"update_queue_length"`
+}
+
+// health OrderChecker считается неисправным, если запросы в систему расчёта или сохранение статусов
+// не проходят дольше staleAfter. Отсутствие запросов (нет заказов на проверку) - не ошибка.
+func (c *OrderChecker) health(staleAfter time.Duration) (interface{}, error) {
+	details := checkerHealth{
+		LastAccrualSuccess: unixNanoTime(c.lastAccrualSuccess.Load()),
+		LastAccrualError:   unixNanoTime(c.lastAccrualError.Load()),
+		LastFlush:          unixNanoTime(c.lastFlush.Load()),
+		LastFlushError:     unixNanoTime(c.lastFlushError.Load()),
+		CheckQueueLength:   len(c.orderCheckChan),
+		UpdateQueueLength:  len(c.orderUpdateChan),
+	}
+	if failing := c.failingFor(c.lastAccrualSuccess.Load(), c.lastAccrualError.Load()); failing > staleAfter {
+		return details, fmt.Errorf("accrual requests failing for %s", failing.Round(time.Second))
+	}
+	if failing := c.failingFor(c.lastFlush.Load(), c.lastFlushError.Load()); failing > staleAfter {
+		return details, fmt.Errorf("saving order statuses failing for %s", failing.Round(time.Second))
+	}
+	return details, nil
+}
+
+// failingFor сколько времени операция не удаётся: с последнего успеха (или запуска),
+// если после него была ошибка
+func (c *OrderChecker) failingFor(lastSuccess int64, lastError int64) time.Duration {
+	if lastError == 0 || lastError <= lastSuccess {
+		return 0
+	}
+	since := c.startedAt
+	if lastSuccess != 0 {
+		since = time.Unix(0, lastSuccess)
+	}
+	return time.Since(since)
+}
+
+func unixNanoTime(nano int64) *time.Time {
+	if nano == 0 {
+		return nil
 	}
+	t := time.Unix(0, nano)
+	return &t
 }
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// expirePoints каждые interval сжигает просроченные партии баллов пачками по batchSize пользователей,
+// пока они не кончатся. Работает до отмены ctx. С несколькими экземплярами сервиса безопасно:
+// пользователи блокируются в базе, повторно партия не сгорит.
+func expirePoints(ctx context.Context, db storage.Repository, interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expirePointsOnce(ctx, db, batchSize)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func expirePointsOnce(ctx context.Context, db storage.Repository, batchSize int) {
+	ctx, span := tracing.Start(ctx, "server", "expire points", trace.WithNewRoot())
+	var (
+		totalUsers   int
+		totalExpired float64
+		err          error
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("users", totalUsers), attribute.Float64("expired", totalExpired))
+		tracing.End(span, err)
+	}()
+
+	for ctx.Err() == nil {
+		var (
+			users   int
+			expired float64
+		)
+		users, expired, err = db.ExpirePoints(ctx, batchSize)
+		if err != nil {
+			logger.ErrorContext(ctx, "expire points error", "error", err)
+			return
+		}
+		totalUsers += users
+		totalExpired += expired
+		if users < batchSize {
+			break
+		}
+	}
+	if totalUsers > 0 {
+		logger.InfoContext(ctx, "points expired", "users", totalUsers, "expired", totalExpired)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/ratelimit"
+	"sync"
+)
+
+// configReloader перечитывает настройки по SIGHUP или POST /config/reload и применяет к работающим
+// компонентам те, что можно менять на лету (поля Config с тегом reload)
+type configReloader struct {
+	load         func() (config.Config, error)
+	orderChecker *OrderChecker
+	loginGuard   *ratelimit.LoginGuard
+
+	mu sync.Mutex
+	// current действующие настройки: изменения, требующие перезапуска, в них не попадают
+	current config.Config
+}
+
+// reloadResult какие изменённые настройки применены, а какие вступят в силу только после перезапуска
+type reloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+func newConfigReloader(cfg config.Config, load func() (config.Config, error),
+	orderChecker *OrderChecker, loginGuard *ratelimit.LoginGuard) *configReloader {
+	return &configReloader{
+		load:         load,
+		orderChecker: orderChecker,
+		loginGuard:   loginGuard,
+		current:      cfg,
+	}
+}
+
+// currentConfig действующие настройки
+func (r *configReloader) currentConfig() config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// reload читает настройки заново и, если они корректны, применяет изменения. При ошибке
+// ничего не меняется.
+func (r *configReloader) reload(ctx context.Context) (reloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return reloadResult{}, fmt.Errorf("cant load config: %w", err)
+	}
+	if err = next.Validate(); err != nil {
+		return reloadResult{}, err
+	}
+
+	applied, restart := config.Changes(r.current, next)
+	// пустые списки, а не null в ответе
+	result := reloadResult{Applied: append([]string{}, applied...), RestartRequired: append([]string{}, restart...)}
+	if len(applied) > 0 {
+		cfg := config.Reloadable(r.current, next)
+		// уровни логов меняются, только если изменились в настройках, иначе заданные через /log/levels сохраняются;
+		// это единственное, что может не примениться, поэтому - первым
+		if cfg.LogLevel != r.current.LogLevel || cfg.LogLevels != r.current.LogLevels {
+			if err = logging.SetLevels(cfg.LogLevel, cfg.LogLevels); err != nil {
+				return reloadResult{}, err
+			}
+		}
+		r.orderChecker.Reconfigure(cfg)
+		r.loginGuard.Reconfigure(cfg)
+		r.current = cfg
+	}
+
+	logger.InfoContext(ctx, "config reloaded", "applied", applied, "restart_required", restart)
+	return result, nil
+}
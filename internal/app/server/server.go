@@ -2,23 +2,208 @@ package server
 
 import (
 	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/polosaty/go-dev-final/internal/app/auth"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/credentials"
+	"github.com/polosaty/go-dev-final/internal/app/fraud"
+	"github.com/polosaty/go-dev-final/internal/app/grpcserver"
 	"github.com/polosaty/go-dev-final/internal/app/handlers"
+	"github.com/polosaty/go-dev-final/internal/app/health"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"github.com/polosaty/go-dev-final/internal/app/metrics"
+	"github.com/polosaty/go-dev-final/internal/app/ratelimit"
 	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"google.golang.org/grpc"
+	grpccredentials "google.golang.org/grpc/credentials"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
-func Serve(addr string, accrualSystemAddress string, db storage.Repository) error {
-	handler := handlers.NewMainHandler(db)
+var logger = logging.Component("server")
 
+// Serve запускает HTTP API, gRPC API, служебный порт и OrderChecker и работает до ошибки сервера
+// или до SIGINT/SIGTERM. SIGHUP перечитывает сертификаты TLS и настройки (load), применяя на лету те,
+// что можно менять без перезапуска. При остановке /readyz сначала ShutdownDrainDelay отвечает 503,
+// затем серверы дожидаются текущих запросов (не дольше ShutdownTimeout).
+func Serve(cfg config.Config, db storage.Repository, load func() (config.Config, error)) error {
 	ctx, cancel := context.WithCancel(context.Background())
-	orderChecker := NewOrderChecker(db, accrualSystemAddress)
-	go orderChecker.SelectOrders(ctx, 10)
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		Protocol:    cfg.TracingProtocol,
+		Insecure:    cfg.TracingInsecure,
+		File:        cfg.TracingFile,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// дописать спаны, накопленные в батчах экспортёра
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelFlush()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Error("tracing shutdown error", "error", err)
+		}
+	}()
+
+	tokens := auth.NewTokenManager(db, cfg.AccessTokenTTL, cfg.SigningKeyRotation)
+	if err := tokens.Start(ctx); err != nil {
+		return err
+	}
+	policy, err := credentials.NewPolicy(cfg.LoginMinLength, cfg.LoginMaxLength, cfg.LoginPattern,
+		cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.PasswordRejectCommon)
+	if err != nil {
+		return err
+	}
+	// лимиты попыток входа общие для HTTP и gRPC API
+	loginGuard := ratelimit.NewLoginGuard(cfg, db)
+	fraudEngine := fraud.NewDefaultEngine(cfg, db)
+	handler := handlers.NewMainHandler(db, cfg, tokens, loginGuard, policy, fraudEngine)
+
+	orderChecker := NewOrderChecker(db, cfg)
+	orderChecker.registerMetrics()
+	if pool, ok := db.(interface{ Stat() *pgxpool.Stat }); ok {
+		metrics.RegisterPool(pool.Stat)
+	}
+	go orderChecker.SelectOrders(ctx)
+	go expirePoints(ctx, db, cfg.PointsExpiryInterval, cfg.PointsExpiryBatchSize)
+	go recalculateTiers(ctx, db, cfg.TierRecalcAt, cfg.TierRecalcBatchSize)
+	reloader := newConfigReloader(cfg, load, orderChecker, loginGuard)
+
+	healthChecks := newHealth(cfg, db, orderChecker)
+	// на публичном порту - только статус, подробности - на служебном
+	handler.Get("/healthz", health.LivenessHandler)
+	handler.Get("/readyz", healthChecks.ReadinessHandler(false))
+
+	certs, err := newServerTLS(cfg)
+	if err != nil {
+		return err
+	}
+	if certs.reloader != nil {
+		go certs.reloader.Watch(ctx, cfg.TLSReloadInterval)
+	}
+
+	serveErrors := make(chan error, 3)
+
+	var adminServer *http.Server
+	if cfg.AdminAddress != "" {
+		adminServer = &http.Server{
+			Addr:    cfg.AdminAddress,
+			Handler: newAdminHandler(healthChecks, reloader),
+		}
+		go func() {
+			if err := listenAndServe(adminServer, certs.admin); err != nil && err != http.ErrServerClosed {
+				serveErrors <- err
+			}
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			return err
+		}
+		var opts []grpc.ServerOption
+		if certs.grpc != nil {
+			opts = append(opts, grpc.Creds(grpccredentials.NewTLS(certs.grpc)))
+		}
+		grpcServer = grpcserver.NewServer(db, cfg, tokens, loginGuard, policy, fraudEngine, opts...)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				serveErrors <- err
+			}
+		}()
+	}
+
 	server := &http.Server{
-		Addr:    addr,
+		Addr:    cfg.RunAddress,
 		Handler: handler,
 	}
+	go func() {
+		if err := listenAndServe(server, certs.public); err != nil && err != http.ErrServerClosed {
+			serveErrors <- err
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+L:
+	for {
+		select {
+		case err = <-serveErrors:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				certs.reload(ctx)
+				if _, err := reloader.reload(ctx); err != nil {
+					logger.ErrorContext(ctx, "reload config error", "error", err)
+				}
+				continue
+			}
+			logger.Info("shutting down", "signal", sig.String())
+			break L
+		}
+	}
+
+	healthChecks.SetShuttingDown()
+	time.Sleep(cfg.ShutdownDrainDelay)
+
+	// серверы останавливаются параллельно, каждый со своим таймаутом: открытые потоки WatchOrders
+	// не должны съедать время, отведённое на завершение текущих HTTP запросов
+	var wg sync.WaitGroup
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopGRPC(grpcServer, cfg.ShutdownTimeout)
+		}()
+	}
+	if adminServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if adminErr := shutdownHTTP(adminServer, cfg.ShutdownTimeout); adminErr != nil {
+				logger.Error("admin server shutdown error", "error", adminErr)
+			}
+		}()
+	}
+	err = shutdownHTTP(server, cfg.ShutdownTimeout)
+	wg.Wait()
+	return err
+}
 
-	return server.ListenAndServe()
+// shutdownHTTP дожидается текущих запросов не дольше timeout
+func shutdownHTTP(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// stopGRPC дожидается текущих вызовов не дольше timeout. GracefulStop ждёт и потоки WatchOrders,
+// которые сами не завершаются, поэтому по истечении timeout оставшиеся вызовы обрываются.
+func stopGRPC(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		server.Stop()
+		<-stopped
+	}
 }
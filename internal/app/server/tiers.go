@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"github.com/polosaty/go-dev-final/internal/app/storage"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// recalculateTiers пересчитывает уровни всех пользователей при запуске и затем ежедневно в at (ЧЧ:ММ по UTC):
+// при начислениях уровень пересчитывается сразу, а понижение из-за выхода старых начислений из окна
+// 12 месяцев видно только здесь. Работает до отмены ctx.
+func recalculateTiers(ctx context.Context, db storage.Repository, at string, batchSize int) {
+	for {
+		recalculateTiersOnce(ctx, db, batchSize)
+		timer := time.NewTimer(time.Until(nextDailyRun(time.Now(), at)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// nextDailyRun ближайший после now момент времени суток at (ЧЧ:ММ по UTC); at проверен при загрузке настроек
+func nextDailyRun(now time.Time, at string) time.Time {
+	clock, _ := time.Parse("15:04", at)
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func recalculateTiersOnce(ctx context.Context, db storage.Repository, batchSize int) {
+	ctx, span := tracing.Start(ctx, "server", "recalculate tiers", trace.WithNewRoot())
+	var (
+		totalUsers   int
+		totalChanged int
+		err          error
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("users", totalUsers), attribute.Int("changed", totalChanged))
+		tracing.End(span, err)
+	}()
+
+	var afterUserID int64
+	for ctx.Err() == nil {
+		var users, changed int
+		afterUserID, users, changed, err = db.RecalculateTiers(ctx, afterUserID, batchSize)
+		if err != nil {
+			logger.ErrorContext(ctx, "recalculate tiers error", "error", err)
+			return
+		}
+		totalUsers += users
+		totalChanged += changed
+		if users < batchSize {
+			break
+		}
+	}
+	logger.InfoContext(ctx, "tiers recalculated", "users", totalUsers, "changed", totalChanged)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/polosaty/go-dev-final/internal/app/config"
+	"github.com/polosaty/go-dev-final/internal/app/tlsconfig"
+	"net/http"
+)
+
+// serverTLS конфигурации TLS серверов; nil - сервер работает без TLS
+type serverTLS struct {
+	reloader *tlsconfig.Reloader
+	public   *tls.Config
+	admin    *tls.Config
+	grpc     *tls.Config
+}
+
+// newServerTLS TLS включается заданием TLS_CERT_FILE и TLS_KEY_FILE; проверка клиентских сертификатов
+// настраивается отдельно для служебного порта и gRPC и без сертификата сервера невозможна
+func newServerTLS(cfg config.Config) (*serverTLS, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.AdminClientCAFile != "" || cfg.GRPCClientCAFile != "" {
+			return nil, errors.New("client certificate verification requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return &serverTLS{}, nil
+	}
+
+	reloader, err := tlsconfig.New(tlsconfig.Options{
+		CertFile:     cfg.TLSCertFile,
+		KeyFile:      cfg.TLSKeyFile,
+		MinVersion:   cfg.TLSMinVersion,
+		CipherSuites: cfg.TLSCipherSuites,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &serverTLS{reloader: reloader, public: reloader.ServerConfig()}
+	if result.admin, err = reloader.MutualConfig(cfg.AdminClientCAFile); err != nil {
+		return nil, err
+	}
+	if result.grpc, err = reloader.MutualConfig(cfg.GRPCClientCAFile); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reload перечитывает сертификаты по SIGHUP
+func (t *serverTLS) reload(ctx context.Context) {
+	if t.reloader == nil {
+		return
+	}
+	if err := t.reloader.Reload(); err != nil {
+		logger.ErrorContext(ctx, "reload tls certificates error", "error", err)
+		return
+	}
+	logger.InfoContext(ctx, "tls certificates reloaded")
+}
+
+// listenAndServe запускает HTTP сервер с TLS (и HTTP/2), если config задан, иначе - без TLS
+func listenAndServe(server *http.Server, config *tls.Config) error {
+	if config == nil {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = config
+	// сертификат отдаёт GetCertificate
+	return server.ListenAndServeTLS("", "")
+}
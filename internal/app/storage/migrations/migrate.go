@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
-	"log"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
 )
 
+var logger = logging.Component("migrations")
+
 type DBInterface interface {
 	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
@@ -16,28 +18,57 @@ type DBInterface interface {
 
 type migration func(ctx context.Context, db DBInterface) error
 
-func Migrate(ctx context.Context, db DBInterface) error {
-	_, err := db.Exec(
-		ctx, `CREATE TABLE IF NOT EXISTS "revision" (version BIGSERIAL CONSTRAINT revision_version_pk PRIMARY KEY)`)
-	if err != nil {
-		return fmt.Errorf("cannot get or create table revision: %w", err)
-	}
+var migrations = []migration{
+	migration01,
+	migration02,
+	migration03,
+	migration04,
+	migration05,
+	migration06,
+	migration07,
+	migration08,
+	migration09,
+	migration10,
+	migration11,
+	migration12,
+	migration13,
+	migration14,
+	migration15,
+	migration16,
+}
+
+// Version версия схемы, которую ожидает этот код
+func Version() int {
+	return len(migrations)
+}
+
+// CurrentVersion версия схемы в базе (0 - миграции ещё не применялись)
+func CurrentVersion(ctx context.Context, db DBInterface) (int, error) {
 	var version int
-	err = db.QueryRow(
+	err := db.QueryRow(
 		ctx, "SELECT version FROM revision ORDER BY version DESC LIMIT 1").Scan(&version)
 
 	if err != nil &&
 		!(errors.Is(err, pgx.ErrNoRows)) {
-		return fmt.Errorf("cannot get version: %w", err)
+		return 0, fmt.Errorf("cannot get version: %w", err)
 	}
+	return version, nil
+}
 
-	migrations := []migration{
-		migration01,
+func Migrate(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx, `CREATE TABLE IF NOT EXISTS "revision" (version BIGSERIAL CONSTRAINT revision_version_pk PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("cannot get or create table revision: %w", err)
+	}
+	version, err := CurrentVersion(ctx, db)
+	if err != nil {
+		return err
 	}
 
 	for v, m := range migrations {
 		if version < (v + 1) {
-			log.Println("migrate database to version: ", v+1)
+			logger.InfoContext(ctx, "migrate database", "version", v+1)
 			if err = m(ctx, db); err != nil {
 				return err
 			}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration02(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table user_session
+   add column if not exists id           bigserial not null,
+   add column if not exists last_seen_at timestamp with time zone,
+   add column if not exists ip           varchar(64),
+   add column if not exists user_agent   varchar(512);
+
+create unique index if not exists user_session_id_uindex
+   on user_session (id);
+
+create index if not exists user_session_user_id_expires_at_index
+   on user_session (user_id, expires_at);
+
+update user_session set last_seen_at = created_at where last_seen_at is null;
+
+INSERT INTO revision VALUES(2);
+`)
+	return err
+}
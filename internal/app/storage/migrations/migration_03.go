@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration03(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create table if not exists signing_key
+(
+   kid        varchar(64)              not null
+       constraint signing_key_pk primary key,
+   secret     bytea                    not null,
+   created_at timestamp with time zone not null,
+   expires_at timestamp with time zone not null
+);
+
+create table if not exists refresh_token
+(
+   id          bigserial constraint refresh_token_pk primary key,
+   user_id     bigint                   not null
+       constraint refresh_token_user_id_fk
+           references "user"
+           on update cascade on delete cascade,
+   token_hash  varchar(64)              not null,
+   created_at  timestamp with time zone not null,
+   expires_at  timestamp with time zone not null,
+   revoked_at  timestamp with time zone
+);
+
+create unique index if not exists refresh_token_token_hash_uindex
+   on refresh_token (token_hash);
+
+create index if not exists refresh_token_user_id_index
+   on refresh_token (user_id);
+
+INSERT INTO revision VALUES(3);
+`)
+	return err
+}
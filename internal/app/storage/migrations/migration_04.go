@@ -0,0 +1,18 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration04(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table "user"
+   add column if not exists created_at timestamp with time zone default now(),
+   add column if not exists deleted_at timestamp with time zone;
+
+INSERT INTO revision VALUES(4);
+`)
+	return err
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration05(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table "user"
+   add column if not exists failed_logins integer default 0 not null,
+   add column if not exists locked_until  timestamp with time zone;
+
+create unlogged table if not exists rate_limit
+(
+   key        varchar(255)             not null
+       constraint rate_limit_pk primary key,
+   tokens     double precision         not null,
+   updated_at timestamp with time zone not null
+);
+
+create index if not exists rate_limit_updated_at_index
+   on rate_limit (updated_at);
+
+INSERT INTO revision VALUES(5);
+`)
+	return err
+}
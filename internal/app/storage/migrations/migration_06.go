@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration06(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create type user_role_enum as enum ('user', 'support', 'admin');
+
+alter table "user"
+   add column if not exists role user_role_enum default 'user'::user_role_enum not null;
+
+update "user" set is_active = deleted_at is null where is_active is null;
+
+alter table "user"
+   alter column is_active set default true,
+   alter column is_active set not null;
+
+create table if not exists balance_adjustment
+(
+   id         bigserial constraint balance_adjustment_pk primary key,
+   user_id    bigint                   not null
+       constraint balance_adjustment_user_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   amount     numeric(10, 2)           not null,
+   reason     text                     not null,
+   actor_id   bigint
+       constraint balance_adjustment_actor_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   created_at timestamp with time zone not null default now()
+);
+
+create index if not exists balance_adjustment_user_id_created_at_index
+   on balance_adjustment (user_id, created_at);
+
+create table if not exists admin_audit
+(
+   id             bigserial constraint admin_audit_pk primary key,
+   actor_id       bigint                   not null
+       constraint admin_audit_actor_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   action         varchar(64)              not null,
+   target_user_id bigint,
+   target_order   varchar(255),
+   reason         text,
+   details        jsonb,
+   created_at     timestamp with time zone not null default now()
+);
+
+create index if not exists admin_audit_created_at_index
+   on admin_audit (created_at);
+
+create index if not exists admin_audit_target_user_id_index
+   on admin_audit (target_user_id);
+
+INSERT INTO revision VALUES(6);
+`)
+	return err
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration07(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create table if not exists order_status_history
+(
+   id         bigserial constraint order_status_history_pk primary key,
+   "order"    varchar(255)             not null
+       constraint order_status_history_order_fk
+           references "order"
+           on update cascade on delete cascade,
+   status     order_status_enum        not null,
+   accrual    numeric(10, 2),
+   changed_at timestamp with time zone not null
+);
+
+create index if not exists order_status_history_order_changed_at_index
+   on order_status_history ("order", changed_at);
+
+insert into order_status_history ("order", status, changed_at)
+select "order", 'NEW', uploaded_at from "order";
+
+insert into order_status_history ("order", status, accrual, changed_at)
+select "order", status, accrual, coalesce(processed_at, uploaded_at) from "order" where status != 'NEW';
+
+INSERT INTO revision VALUES(7);
+`)
+	return err
+}
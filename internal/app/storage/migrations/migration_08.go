@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration08(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create unique index if not exists users_login_lower_uindex
+   on "user" (lower(login));
+
+INSERT INTO revision VALUES(8);
+`)
+	return err
+}
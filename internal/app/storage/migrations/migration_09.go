@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"context"
+)
+
+func migration09(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table "user"
+   add if not exists change_version bigint default 0 not null;
+
+alter table "user"
+   add if not exists changed_at timestamp with time zone default now() not null;
+
+INSERT INTO revision VALUES(9);
+`)
+	return err
+}
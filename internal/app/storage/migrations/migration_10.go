@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+)
+
+// migration10 партии начисленных баллов со сроком действия. Текущий баланс каждого пользователя
+// становится одной партией, которая сгорит через 12 месяцев после миграции.
+func migration10(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create table if not exists point_lot
+(
+   id          bigserial constraint point_lot_pk primary key,
+   user_id     bigint                   not null
+       constraint point_lot_user_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   "order"     varchar(255),
+   amount      numeric(10, 2)           not null,
+   remaining   numeric(10, 2)           not null,
+   credited_at timestamp with time zone not null default now(),
+   expires_at  timestamp with time zone not null
+);
+
+create index if not exists point_lot_user_id_expires_at_index
+   on point_lot (user_id, expires_at) where remaining > 0;
+
+create index if not exists point_lot_expires_at_index
+   on point_lot (expires_at) where remaining > 0;
+
+create table if not exists point_expiration
+(
+   id         bigserial constraint point_expiration_pk primary key,
+   user_id    bigint                   not null
+       constraint point_expiration_user_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   lot_id     bigint                   not null
+       constraint point_expiration_lot_id_fk
+           references point_lot
+           on update restrict on delete restrict,
+   amount     numeric(10, 2)           not null,
+   expired_at timestamp with time zone not null
+);
+
+create index if not exists point_expiration_user_id_expired_at_index
+   on point_expiration (user_id, expired_at);
+
+insert into point_lot (user_id, amount, remaining, credited_at, expires_at)
+   select id, balance, balance, now(), now() + interval '12 months'
+   from "user" where balance > 0;
+
+INSERT INTO revision VALUES(10);
+`)
+	return err
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+)
+
+// migration11 персональные ограничения списаний; NULL в колонке - действует общее ограничение
+func migration11(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create table if not exists withdrawal_limit
+(
+   user_id         bigint                   not null
+       constraint withdrawal_limit_pk primary key
+       constraint withdrawal_limit_user_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   min_sum         numeric(10, 2),
+   per_transaction numeric(10, 2),
+   per_day         numeric(10, 2),
+   per_month       numeric(10, 2),
+   actor_id        bigint
+       constraint withdrawal_limit_actor_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   updated_at      timestamp with time zone not null default now()
+);
+
+create index if not exists withdrawal_user_id_processed_at_index
+   on withdrawal (user_id, processed_at);
+
+INSERT INTO revision VALUES(11);
+`)
+	return err
+}
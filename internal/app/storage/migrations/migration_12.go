@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"context"
+)
+
+// migration12 решения правил антифрода и попытки загрузить чужой номер заказа (ответы 409)
+func migration12(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+create table if not exists fraud_decision
+(
+   id            bigserial
+       constraint fraud_decision_pk primary key,
+   user_id       bigint                   not null
+       constraint fraud_decision_user_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   action        varchar(32)              not null,
+   "order"       varchar(255),
+   sum           numeric(10, 2),
+   outcome       varchar(16)              not null,
+   rules         jsonb                    not null,
+   ip            varchar(64),
+   created_at    timestamp with time zone not null default now(),
+   reviewer_id   bigint
+       constraint fraud_decision_reviewer_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   resolution    varchar(16),
+   review_reason text,
+   reviewed_at   timestamp with time zone
+);
+
+create index if not exists fraud_decision_created_at_index
+   on fraud_decision (created_at);
+
+create index if not exists fraud_decision_user_id_created_at_index
+   on fraud_decision (user_id, created_at);
+
+create index if not exists fraud_decision_unreviewed_index
+   on fraud_decision (created_at) where reviewed_at is null;
+
+create table if not exists order_conflict
+(
+   id         bigserial
+       constraint order_conflict_pk primary key,
+   user_id    bigint                   not null
+       constraint order_conflict_user_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   "order"    varchar(255)             not null,
+   created_at timestamp with time zone not null default now()
+);
+
+create index if not exists order_conflict_user_id_created_at_index
+   on order_conflict (user_id, created_at);
+
+create index if not exists order_user_id_uploaded_at_index
+   on "order" (user_id, uploaded_at);
+
+INSERT INTO revision VALUES(12);
+`)
+	return err
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+)
+
+// migration13 уровни программы лояльности: текущий уровень пользователя, базовое начисление
+// системы расчёта и уровень, по которому начислены баллы за заказ
+func migration13(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table "user"
+   add column if not exists tier            varchar(16) default 'bronze' not null,
+   add column if not exists tier_updated_at timestamp with time zone;
+
+alter table "order"
+   add column if not exists base_accrual numeric(10, 2),
+   add column if not exists tier         varchar(16);
+
+update "order" set base_accrual = accrual where status = 'PROCESSED' and base_accrual is null;
+
+create index if not exists order_user_id_processed_at_index
+   on "order" (user_id, processed_at) where status = 'PROCESSED';
+
+INSERT INTO revision VALUES(13);
+`)
+	return err
+}
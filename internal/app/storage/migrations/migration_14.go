@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"context"
+)
+
+// migration14 реферальная программа: код пользователя, кто его пригласил и бонусы за приглашения
+// (не больше одного на приглашённого)
+func migration14(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table "user"
+   add column if not exists referral_code varchar(16),
+   add column if not exists referred_by   bigint
+       constraint user_referred_by_fk
+           references "user"
+           on update restrict on delete restrict;
+
+update "user" set referral_code = upper(substr(md5(random()::text || id::text || clock_timestamp()::text), 1, 10))
+   where referral_code is null;
+
+alter table "user"
+   alter column referral_code set not null;
+
+create unique index if not exists user_referral_code_uindex
+   on "user" (referral_code);
+
+create index if not exists user_referred_by_index
+   on "user" (referred_by) where referred_by is not null;
+
+create table if not exists referral_bonus
+(
+   id             bigserial
+       constraint referral_bonus_pk primary key,
+   referrer_id    bigint                   not null
+       constraint referral_bonus_referrer_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   referred_id    bigint                   not null
+       constraint referral_bonus_referred_id_fk
+           references "user"
+           on update restrict on delete restrict,
+   "order"        varchar(255)             not null,
+   referrer_bonus numeric(10, 2)           not null,
+   referred_bonus numeric(10, 2)           not null,
+   capped         boolean                  not null default false,
+   created_at     timestamp with time zone not null default now()
+);
+
+create unique index if not exists referral_bonus_referred_id_uindex
+   on referral_bonus (referred_id);
+
+create index if not exists referral_bonus_referrer_id_index
+   on referral_bonus (referrer_id);
+
+INSERT INTO revision VALUES(14);
+`)
+	return err
+}
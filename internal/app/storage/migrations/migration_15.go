@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+)
+
+// migration15 сумма списания должна быть положительной. Ограничение not valid: проверяются только новые
+// строки, старые списания не мешают миграции.
+func migration15(ctx context.Context, db DBInterface) error {
+	_, err := db.Exec(
+		ctx,
+		`
+alter table withdrawal
+   drop constraint if exists withdrawal_sum_positive_check;
+
+alter table withdrawal
+   add constraint withdrawal_sum_positive_check check (sum > 0) not valid;
+
+INSERT INTO revision VALUES(15);
+`)
+	return err
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+)
+
+// normalizedLogin логин в виде credentials.NormalizeLogin: нижний регистр, без пробельных символов по краям
+const normalizedLogin = `lower(btrim(login, E' \t\n\r\v\f'))`
+
+// migration16 приводит логины к нормализованному виду, иначе владельцы логинов с заглавными буквами
+// или пробелами по краям не смогут войти (логин при входе нормализуется)
+func migration16(ctx context.Context, db DBInterface) error {
+	if err := normalizeLogins(ctx, db); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, `INSERT INTO revision VALUES(16);`)
+	return err
+}
+
+// normalizeLogins приводит логины к нормализованному виду (служебные логины удалённых пользователей
+// не трогает, они нарочно не нормализованы). Если после этого логины разных пользователей
+// совпадут, ничего не меняет и возвращает ошибку со списком совпадений: какой из аккаунтов переименовать,
+// решает администратор.
+func normalizeLogins(ctx context.Context, db DBInterface) error {
+	var collisions string
+	err := db.QueryRow(ctx,
+		`SELECT coalesce(string_agg(logins, '; '), '') FROM (
+			SELECT string_agg(id || ':' || quote_literal(login), ', ' ORDER BY id) AS logins
+			FROM "user" WHERE deleted_at IS NULL GROUP BY `+normalizedLogin+` HAVING count(*) > 1) collision`).
+		Scan(&collisions)
+	if err != nil {
+		return fmt.Errorf("cannot check login collisions: %w", err)
+	}
+	if collisions != "" {
+		return fmt.Errorf("cannot normalize logins: logins differ only by case or surrounding spaces, "+
+			"rename all but one user in each group (id:login) and restart: %s", collisions)
+	}
+
+	tag, err := db.Exec(ctx,
+		`UPDATE "user" SET login = `+normalizedLogin+` WHERE deleted_at IS NULL AND login <> `+normalizedLogin)
+	if err != nil {
+		return fmt.Errorf("cannot normalize logins: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		logger.InfoContext(ctx, "logins normalized", "users", tag.RowsAffected())
+	}
+	return nil
+}
@@ -6,17 +6,42 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jackc/pgerrcode"
-	"log"
 	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
 	"github.com/polosaty/go-dev-final/internal/app/storage/migrations"
 )
 
+var logger = logging.Component("storage")
+
 type PG struct {
-	db dbInterface
+	db                 dbInterface
+	bcryptCost         int
+	pointsExpiryMonths int
+	withdrawalLimits   WithdrawalLimits
+	tiers              Tiers
+	referralBonus      ReferralBonus
+}
+
+// PGOptions настройки подключения к базе
+type PGOptions struct {
+	// Password заменяет пароль из строки подключения, если задан
+	Password string
+	// MaxConns размер пула, 0 - значение pgxpool по умолчанию
+	MaxConns int
+	// BcryptCost сложность bcrypt для новых хэшей паролей
+	BcryptCost int
+	// PointsExpiryMonths через сколько месяцев после начисления сгорают баллы
+	PointsExpiryMonths int
+	// WithdrawalLimits общие ограничения списаний
+	WithdrawalLimits WithdrawalLimits
+	// Tiers пороги и множители уровней программы лояльности
+	Tiers Tiers
+	// ReferralBonus бонусы и лимит реферальной программы
+	ReferralBonus ReferralBonus
 }
 
 var _ Repository = (*PG)(nil)
@@ -31,22 +56,34 @@ type dbInterface interface {
 	Close()
 }
 
-func NewStoragePG(uri string) (*PG, error) {
+func NewStoragePG(uri string, options PGOptions) (*PG, error) {
 	ctx := context.Background()
 	conf, err := pgxpool.ParseConfig(uri)
 	if err != nil {
-		return nil, fmt.Errorf("unable to connect: parse dsn problem (dsn=%v): %w", uri, err)
+		return nil, fmt.Errorf("unable to connect: parse dsn problem (dsn=%v): %w", logging.RedactURL(uri), err)
 	}
 
-	//conf.MaxConns = 10
+	if options.Password != "" {
+		conf.ConnConfig.Password = options.Password
+	}
+	if options.MaxConns > 0 {
+		conf.MaxConns = int32(options.MaxConns)
+	}
+	conf.ConnConfig.Logger = pgTracer{}
+	conf.ConnConfig.LogLevel = pgx.LogLevelInfo
 	conn, err := pgxpool.ConnectConfig(ctx, conf)
 
 	if err != nil {
-		return nil, fmt.Errorf("unable to connect to database(uri=%v): %w", uri, err)
+		return nil, fmt.Errorf("unable to connect to database(uri=%v): %w", logging.RedactURL(uri), err)
 	}
 
 	repo := &PG{
-		db: conn,
+		db:                 conn,
+		bcryptCost:         options.BcryptCost,
+		pointsExpiryMonths: options.PointsExpiryMonths,
+		withdrawalLimits:   options.WithdrawalLimits,
+		tiers:              options.Tiers,
+		referralBonus:      options.ReferralBonus,
 	}
 
 	err = migrations.Migrate(ctx, conn)
@@ -57,90 +94,398 @@ func NewStoragePG(uri string) (*PG, error) {
 	return repo, nil
 }
 
-func (s *PG) CreateUser(ctx context.Context, login string, password string) (userID int64, err error) {
-	passwordHash, err := HashPassword(password)
+// Ping проверяет соединение с базой
+func (s *PG) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+// CheckMigrations проверяет, что схема базы не старее той, которую ожидает код. Более новая схема - норма
+// при обновлении: первый новый экземпляр уже применил миграции, а старые продолжают работать, пока их не заменят.
+func (s *PG) CheckMigrations(ctx context.Context) (int, error) {
+	version, err := migrations.CurrentVersion(ctx, s.db)
 	if err != nil {
-		return
+		return 0, err
+	}
+	if version < migrations.Version() {
+		return version, fmt.Errorf("%w: database %d, expected %d", ErrSchemaVersion, version, migrations.Version())
 	}
+	return version, nil
+}
 
-	err = s.db.QueryRow(ctx,
-		`INSERT INTO "user" (login, password) VALUES($1, $2)
-			RETURNING id`, login, passwordHash).
-		Scan(&userID)
+// Stat статистика пула соединений для метрик (nil, если соединение не из pgxpool)
+func (s *PG) Stat() *pgxpool.Stat {
+	if pool, ok := s.db.(*pgxpool.Pool); ok {
+		return pool.Stat()
+	}
+	return nil
+}
 
-	//https://github.com/jackc/pgconn/issues/15#issuecomment-867082415
-	var pge *pgconn.PgError
-	if errors.As(err, &pge) {
+// referralCodeAttempts сколько раз генерировать реферальный код заново, если он совпал с существующим
+const referralCodeAttempts = 3
 
-		if pgerrcode.IsIntegrityConstraintViolation(pge.SQLState()) {
-			// user already exists
-			// Handle  duplicate key value violates
-			return 0, ErrDuplicateUser
+// CreateUser создаёт пользователя с собственным реферальным кодом. Если указан referralCode,
+// запоминается пригласивший пользователь; неизвестный код - ErrReferralCodeNotFound.
+func (s *PG) CreateUser(ctx context.Context, login string, password string, referralCode string) (int64, error) {
+	passwordHash, err := HashPassword(ctx, password, s.bcryptCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var referredBy *int64
+	if referralCode != "" {
+		var referrerID int64
+		err = s.db.QueryRow(ctx,
+			`SELECT id FROM "user" WHERE referral_code = upper($1) AND deleted_at IS NULL`, referralCode).
+			Scan(&referrerID)
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return 0, ErrReferralCodeNotFound
+			}
+			return 0, fmt.Errorf("cant select referrer: %w", err)
 		}
-		return 0, fmt.Errorf("create user error: %w", err)
+		referredBy = &referrerID
 	}
 
-	return
+	for attempt := 1; ; attempt++ {
+		code, err := generateReferralCode()
+		if err != nil {
+			return 0, fmt.Errorf("cant generate referral code: %w", err)
+		}
+
+		var userID int64
+		err = s.db.QueryRow(ctx,
+			`INSERT INTO "user" (login, password, referral_code, referred_by) VALUES($1, $2, $3, $4)
+				RETURNING id`, login, passwordHash, code, referredBy).
+			Scan(&userID)
+
+		//https://github.com/jackc/pgconn/issues/15#issuecomment-867082415
+		var pge *pgconn.PgError
+		if errors.As(err, &pge) {
+			if pge.ConstraintName == "user_referral_code_uindex" && attempt < referralCodeAttempts {
+				continue
+			}
+			if pgerrcode.IsIntegrityConstraintViolation(pge.SQLState()) {
+				// user already exists
+				// Handle  duplicate key value violates
+				return 0, ErrDuplicateUser
+			}
+			return 0, fmt.Errorf("create user error: %w", err)
+		}
+
+		return userID, err
+	}
 }
 
-func (s *PG) LoginUser(ctx context.Context, login string, password string) (*Session, error) {
+// LoginUser проверяет пару логин/пароль.
+// Для временно заблокированного пользователя пароль не проверяется, возвращается *UserLockedError.
+func (s *PG) LoginUser(ctx context.Context, login string, password string) (int64, error) {
 	var (
 		userID       int64
 		passwordHash string
+		lockedUntil  sql.NullTime
+		isActive     bool
 	)
 	err := s.db.QueryRow(ctx,
-		`SELECT id, password FROM  "user" WHERE login = $1`, login).
-		Scan(&userID, &passwordHash)
+		`SELECT id, password, locked_until, is_active FROM  "user" WHERE lower(login) = lower($1)`, login).
+		Scan(&userID, &passwordHash, &lockedUntil, &isActive)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, ErrWrongLogin
+			return 0, ErrWrongLogin
 		}
-		return nil, err
+		return 0, err
+	}
+	if !isActive {
+		return 0, ErrUserBlocked
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return 0, &UserLockedError{Until: lockedUntil.Time}
+	}
+	if !CheckPasswordHash(ctx, password, passwordHash) {
+		return 0, ErrWrongPassword
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE "user" SET failed_logins = 0, locked_until = NULL WHERE id = $1 AND failed_logins > 0`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("reset failed logins error: %w", err)
 	}
-	if !CheckPasswordHash(password, passwordHash) {
-		return nil, ErrWrongPassword
+
+	return userID, nil
+}
+
+// RegisterLoginFailure учитывает неудачную попытку входа;
+// после maxFailures неудачных попыток подряд пользователь блокируется на lockFor
+func (s *PG) RegisterLoginFailure(ctx context.Context, login string, maxFailures int, lockFor time.Duration) error {
+	if maxFailures <= 0 {
+		return nil
 	}
+	_, err := s.db.Exec(ctx,
+		`UPDATE "user" SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $2
+				THEN now() + $3::float8 * interval '1 second'
+				ELSE locked_until END
+		WHERE lower(login) = lower($1)`,
+		login, maxFailures, lockFor.Seconds())
+	if err != nil {
+		return fmt.Errorf("register login failure error: %w", err)
+	}
+	return nil
+}
 
-	return s.CreateSession(ctx, userID)
+func (s *PG) GetProfile(ctx context.Context, userID int64) (*Profile, error) {
+	profile := &Profile{ID: userID}
+	var createdAt sql.NullTime
+	err := s.db.QueryRow(ctx,
+		`SELECT login, balance, withdrawn, created_at FROM "user" WHERE id = $1`, userID).
+		Scan(&profile.Login, &profile.Balance, &profile.Withdrawn, &createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("cant select user: %w", err)
+	}
+	profile.CreatedAt = RFC3339DateTime(createdAt)
+	return profile, nil
 }
 
-func (s *PG) CreateSession(ctx context.Context, userID int64) (*Session, error) {
-	session := &Session{
-		UserID:    userID,
-		Token:     generateToken(),
-		ExpiresAt: time.Now().Add(time.Hour * 10),
+// checkPasswordTx блокирует строку пользователя до конца транзакции и проверяет его пароль
+func checkPasswordTx(ctx context.Context, tx pgx.Tx, userID int64, password string) error {
+	var passwordHash string
+	err := tx.QueryRow(ctx,
+		`SELECT password FROM "user" WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, userID).
+		Scan(&passwordHash)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrWrongLogin
+		}
+		return fmt.Errorf("cant select user: %w", err)
+	}
+	if !CheckPasswordHash(ctx, password, passwordHash) {
+		return ErrWrongPassword
 	}
+	return nil
+}
+
+// ChangePassword меняет пароль пользователя после проверки текущего,
+// завершает все его сессии кроме keepSessionID и отзывает все refresh токены
+func (s *PG) ChangePassword(ctx context.Context, userID int64, currentPassword string, newPassword string, keepSessionID int64) error {
+	passwordHash, err := HashPassword(ctx, newPassword, s.bcryptCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	_, err := s.db.Exec(ctx, `
-		INSERT INTO user_session (user_id, token, created_at, expires_at)
-		VALUES ($1, $2, $3, $4)`,
-		userID, session.Token, time.Now(), session.ExpiresAt)
+	if err = checkPasswordTx(ctx, tx, userID, currentPassword); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE "user" SET password = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("update password error: %w", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM user_session WHERE user_id = $1 AND id != $2`, userID, keepSessionID)
+	if err != nil {
+		return fmt.Errorf("delete sessions error: %w", err)
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_token SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh tokens error: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cant commit tx %w", err)
+	}
+	return nil
+}
+
+// deletedLoginPrefix служебный логин удалённого пользователя - префикс и id. Начальный пробел не даёт
+// зарегистрировать такой логин: при регистрации пробелы по краям логина обрезаются (NormalizeLogin)
+const deletedLoginPrefix = " deleted-"
+
+// DeleteUser обезличивает пользователя: логин заменяется на служебный, пароль стирается,
+// сессии, refresh токены и конфликты заказов удаляются, из решений антифрода стирается IP.
+// Заказы и списания остаются (на них ссылаются внешние ключи и они нужны для финансовой отчётности),
+// но больше не связаны с персональными данными.
+func (s *PG) DeleteUser(ctx context.Context, userID int64, password string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = checkPasswordTx(ctx, tx, userID, password); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE "user" SET login = $2::text || id, password = '', is_active = false, deleted_at = now()
+		WHERE id = $1`, userID, deletedLoginPrefix)
+	if err != nil {
+		return fmt.Errorf("anonymize user error: %w", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM user_session WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete sessions error: %w", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM refresh_token WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete refresh tokens error: %w", err)
+	}
+	// решения антифрода остаются для разбора, но без адреса; конфликты заказов нужны только правилам антифрода
+	_, err = tx.Exec(ctx, `UPDATE fraud_decision SET ip = NULL WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("anonymize fraud decisions error: %w", err)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM order_conflict WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete order conflicts error: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cant commit tx %w", err)
+	}
+	return nil
+}
+
+func (s *PG) CreateSession(ctx context.Context, session Session, ttl time.Duration) (*Session, error) {
+	now := time.Now()
+	session.Token = generateToken()
+	session.ExpiresAt = now.Add(ttl)
+
+	//заодно подчищаем протухшие сессии пользователя
+	_, err := s.db.Exec(ctx,
+		`DELETE FROM user_session WHERE user_id = $1 AND expires_at <= now()`, session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("delete expired sessions error: %w", err)
+	}
+
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO user_session (user_id, token, created_at, last_seen_at, expires_at, ip, user_agent)
+		VALUES ($1, $2, $3, $3, $4, $5, $6)
+		RETURNING id`,
+		session.UserID, session.Token, now, session.ExpiresAt, session.IP, session.UserAgent).
+		Scan(&session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("create session error: %w", err)
 	}
 
-	return session, nil
+	return &session, nil
 }
 
-func (s *PG) GetUserByToken(ctx context.Context, token string) (int64, error) {
-	var userID int64
+// GetSessionByToken возвращает живую сессию по токену и отмечает время последнего обращения.
+// Если prolong > 0, срок жизни сессии сдвигается на prolong от текущего момента (sliding expiry).
+// Чтобы не писать в базу на каждый запрос, last_seen_at и expires_at обновляются не чаще раза в минуту.
+func (s *PG) GetSessionByToken(ctx context.Context, token string, prolong time.Duration) (*Session, error) {
+	session := &Session{Token: token}
+	var (
+		ip        sql.NullString
+		userAgent sql.NullString
+	)
 
-	err := s.db.QueryRow(ctx,
-		`SELECT user_id FROM  "user_session" WHERE token = $1 and expires_at > now()`, token).
-		Scan(&userID)
+	err := s.db.QueryRow(ctx, `
+		WITH current_session AS (
+			SELECT id, user_id, expires_at, ip, user_agent FROM user_session
+			WHERE token = $1 AND expires_at > now()
+				AND EXISTS(SELECT 1 FROM "user" WHERE "user".id = user_session.user_id AND "user".is_active)),
+		touched AS (
+			UPDATE user_session SET
+				last_seen_at = now(),
+				expires_at = CASE WHEN $2::float8 > 0
+					THEN greatest(user_session.expires_at, now() + $2::float8 * interval '1 second')
+					ELSE user_session.expires_at END
+			FROM current_session
+			WHERE user_session.id = current_session.id
+				AND (user_session.last_seen_at IS NULL
+					OR user_session.last_seen_at < now() - interval '1 minute')
+			RETURNING user_session.id, user_session.expires_at)
+		SELECT current_session.id, current_session.user_id,
+			coalesce(touched.expires_at, current_session.expires_at),
+			current_session.ip, current_session.user_agent
+		FROM current_session LEFT JOIN touched ON touched.id = current_session.id`,
+		token, prolong.Seconds()).
+		Scan(&session.ID, &session.UserID, &session.ExpiresAt, &ip, &userAgent)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return 0, ErrWrongToken
+			return nil, ErrWrongToken
 		}
-		return 0, err
+		return nil, err
 	}
+	session.IP = ip.String
+	session.UserAgent = userAgent.String
 
-	return userID, nil
+	return session, nil
+}
+
+func (s *PG) GetSessions(ctx context.Context, userID int64) ([]SessionInfo, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, created_at, last_seen_at, expires_at, ip, user_agent
+		FROM user_session WHERE user_id = $1 AND expires_at > now() ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("cant select sessions: %w", err)
+	}
+	defer rows.Close()
+	var sessions []SessionInfo
+
+	for rows.Next() {
+		var (
+			v          SessionInfo
+			createdAt  sql.NullTime
+			lastSeenAt sql.NullTime
+			expiresAt  sql.NullTime
+			ip         sql.NullString
+			userAgent  sql.NullString
+		)
+		err = rows.Scan(&v.ID, &createdAt, &lastSeenAt, &expiresAt, &ip, &userAgent)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse row from select sessions: %w", err)
+		}
+		v.CreatedAt = RFC3339DateTime(createdAt)
+		v.LastSeenAt = RFC3339DateTime(lastSeenAt)
+		v.ExpiresAt = RFC3339DateTime(expiresAt)
+		v.IP = ip.String
+		v.UserAgent = userAgent.String
+		sessions = append(sessions, v)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PG) DeleteSession(ctx context.Context, userID int64, sessionID int64) error {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM user_session WHERE user_id = $1 AND id = $2`, userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("delete session error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PG) DeleteOtherSessions(ctx context.Context, userID int64, currentSessionID int64) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM user_session WHERE user_id = $1 AND id != $2`, userID, currentSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("delete sessions error: %w", err)
+	}
+	return tag.RowsAffected(), nil
 }
 
 func (s *PG) CreateOrder(ctx context.Context, userID int64, order string) error {
-	_, err := s.db.Exec(ctx,
-		`INSERT INTO "order"("order", "user_id", "uploaded_at") VALUES($1, $2, $3)`,
+	ctx = logging.WithOrder(ctx, order)
+	// заблокированный или удалённый пользователь не может загружать заказы, даже если его access токен ещё действует
+	tag, err := s.db.Exec(ctx,
+		`WITH active AS (`+
+			` SELECT id FROM "user" WHERE id = $2 AND is_active AND deleted_at IS NULL FOR UPDATE), `+
+			`created AS (`+
+			` INSERT INTO "order"("order", "user_id", "uploaded_at") SELECT $1, id, $3 FROM active `+
+			` RETURNING "order", "status", "uploaded_at"), `+
+			`bumped AS (UPDATE "user" SET `+bumpChangeVersion+` WHERE id IN (SELECT id FROM active)) `+
+			`INSERT INTO order_status_history ("order", "status", "changed_at") `+
+			` SELECT "order", "status", "uploaded_at" FROM created`,
 		order, userID, time.Now())
 
 	if err != nil {
@@ -159,14 +504,52 @@ func (s *PG) CreateOrder(ctx context.Context, userID int64, order string) error
 				// одрер уже есть - пользователь тот же -> already uploaded 200
 				return ErrOrderDuplicate
 			} else {
-				// одрер уже есть - пользователь другой -> conflict 409
+				// одрер уже есть - пользователь другой -> conflict 409;
+				// попытка запоминается для правила антифрода о переборе чужих номеров,
+				// ошибка записи не должна менять ответ пользователю
+				_, insErr := s.db.Exec(ctx,
+					`INSERT INTO order_conflict (user_id, "order", created_at) VALUES ($1, $2, now())`, userID, order)
+				if insErr != nil {
+					logger.ErrorContext(ctx, "cant save order conflict", "error", insErr)
+				}
 				return ErrOrderConflict
 			}
 
 		}
 		return fmt.Errorf("create order error: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserBlocked
+	}
+
+	logger.DebugContext(ctx, "order created")
+	return nil
+}
+
+// IsOrderUploaded загружал ли пользователь этот номер заказа
+func (s *PG) IsOrderUploaded(ctx context.Context, userID int64, order string) (bool, error) {
+	var uploaded bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM "order" WHERE "order" = $1 AND user_id = $2)`, order, userID).
+		Scan(&uploaded)
+	if err != nil {
+		return false, fmt.Errorf("cant select order: %w", err)
+	}
+	return uploaded, nil
+}
 
+// CheckUserActive возвращает ErrUserBlocked, если пользователь заблокирован или удалён
+func (s *PG) CheckUserActive(ctx context.Context, userID int64) error {
+	var active bool
+	err := s.db.QueryRow(ctx,
+		`SELECT is_active AND deleted_at IS NULL FROM "user" WHERE id = $1`, userID).
+		Scan(&active)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("cant select user: %w", err)
+	}
+	if !active {
+		return ErrUserBlocked
+	}
 	return nil
 }
 
@@ -192,13 +575,79 @@ func (s *PG) GetOrders(ctx context.Context, userID int64) ([]Order, error) {
 			return nil, fmt.Errorf("cant parse row from select orders: %w", err)
 		}
 		v.UploadedAt = RFC3339DateTime(uploadedAt)
-		v.processedAt = RFC3339DateTime(processedAt)
+		v.ProcessedAt = RFC3339DateTime(processedAt)
 		orders = append(orders, v)
 	}
 	return orders, nil
 
 }
 
+// GetOrder заказ пользователя с историей статусов; чужой заказ не отличается от несуществующего (ErrOrderNotFound)
+func (s *PG) GetOrder(ctx context.Context, userID int64, order string) (*OrderDetail, error) {
+	var (
+		v           OrderDetail
+		uploadedAt  sql.NullTime
+		processedAt sql.NullTime
+	)
+	err := s.db.QueryRow(ctx,
+		`SELECT "order", "accrual", "status", "processed_at", "uploaded_at"
+		FROM "order" WHERE "order" = $1 AND user_id = $2`, order, userID).
+		Scan(&v.OrderNum, &v.Accrual, &v.Status, &processedAt, &uploadedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("cant select order: %w", err)
+	}
+	v.UploadedAt = RFC3339DateTime(uploadedAt)
+	if processedAt.Valid {
+		p := RFC3339DateTime(processedAt)
+		v.ProcessedAt = &p
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT "status", "accrual", "changed_at" FROM order_status_history
+		WHERE "order" = $1 ORDER BY "changed_at", "id"`, order)
+	if err != nil {
+		return nil, fmt.Errorf("cant select order history: %w", err)
+	}
+	defer rows.Close()
+	v.History = []OrderStatusChange{}
+
+	for rows.Next() {
+		var (
+			change    OrderStatusChange
+			changedAt sql.NullTime
+		)
+		if err = rows.Scan(&change.Status, &change.Accrual, &changedAt); err != nil {
+			return nil, fmt.Errorf("cant parse row from select order history: %w", err)
+		}
+		change.ChangedAt = RFC3339DateTime(changedAt)
+		v.History = append(v.History, change)
+	}
+	return &v, rows.Err()
+}
+
+// bumpChangeVersion фрагмент UPDATE "user" SET, отмечающий изменение заказов, списаний или баланса пользователя
+// (по версии изменений строятся ETag и Last-Modified, см. GetChangeVersion)
+const bumpChangeVersion = `change_version = change_version + 1, changed_at = now()`
+
+// GetChangeVersion версия изменений данных пользователя - дешёвый запрос по первичному ключу
+func (s *PG) GetChangeVersion(ctx context.Context, userID int64) (*ChangeVersion, error) {
+	var version ChangeVersion
+	err := s.db.QueryRow(ctx,
+		`SELECT change_version, changed_at FROM "user" WHERE id = $1`, userID).
+		Scan(&version.Version, &version.ChangedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("cant select change version: %w", err)
+	}
+	return &version, nil
+}
+
+// GetBalance баланс с ближайшими сгораниями баллов и остатком ограничений списаний
 func (s *PG) GetBalance(ctx context.Context, userID int64) (*Balance, error) {
 	balance := &Balance{}
 	err := s.db.QueryRow(ctx,
@@ -207,14 +656,31 @@ func (s *PG) GetBalance(ctx context.Context, userID int64) (*Balance, error) {
 	if err != nil {
 		return nil, err
 	}
+	if balance.Expiring, err = s.getUpcomingExpirations(ctx, userID); err != nil {
+		return nil, err
+	}
+	if balance.WithdrawalAllowance, err = s.getWithdrawalAllowance(ctx, s.db, userID); err != nil {
+		return nil, err
+	}
+	if balance.Tier, err = s.getTierStatus(ctx, userID); err != nil {
+		return nil, err
+	}
 
 	return balance, nil
 }
 
 func (s *PG) CreateWithdrawal(ctx context.Context, userID int64, withdrawal Withdrawal) error {
+	ctx = logging.WithOrder(ctx, withdrawal.OrderNum)
+	if !WithdrawalSumIsValid(withdrawal.Sum) {
+		return ErrInvalidWithdrawalSum
+	}
 	//под транзакцией
+	// - проверить, что пользователь не заблокирован и не удалён
+	// - проверить ограничения списаний (строка пользователя заблокирована, параллельные списания ждут)
+	// - сжечь просроченные баллы, чтобы их нельзя было потратить до запуска ExpirePoints
 	// - вычесть сумму из баланса пользователя и добавить сумму в списания пользователя
 	// - если баланс окажется меньше 0 откатить транзакцию
+	// - списать сумму с партий баллов, начиная с ближайших к сгоранию
 	// - зарегистрировать списание
 
 	tx, err := s.db.Begin(ctx)
@@ -223,15 +689,37 @@ func (s *PG) CreateWithdrawal(ctx context.Context, userID int64, withdrawal With
 	}
 	defer func(ctx context.Context, tx pgx.Tx) {
 		err := tx.Rollback(ctx)
-		if err != nil {
-			log.Println("create withdrawal tx rollback error: ", err)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			logger.ErrorContext(ctx, "create withdrawal tx rollback error", "error", err)
 		}
 	}(ctx, tx)
 
+	// блокировка действует сразу, даже если access токен пользователя ещё не истёк
+	var active bool
+	err = tx.QueryRow(ctx,
+		`SELECT is_active AND deleted_at IS NULL FROM "user" WHERE id = $1 FOR UPDATE`, userID).
+		Scan(&active)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("lock user error: %w", err)
+	}
+	if !active {
+		return ErrUserBlocked
+	}
+	allowance, err := s.getWithdrawalAllowance(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err = checkWithdrawalLimits(allowance, withdrawal.Sum); err != nil {
+		return err
+	}
+	if _, err = expireLots(ctx, tx, []int64{userID}); err != nil {
+		return err
+	}
+
 	var newBalance float64
 	err = tx.QueryRow(ctx,
-		`UPDATE "user" SET balance = balance - $1, withdrawn = withdrawn + $1 WHERE id = $2
-         RETURNING balance`,
+		`UPDATE "user" SET balance = balance - $1, withdrawn = withdrawn + $1, `+bumpChangeVersion+`
+         WHERE id = $2 RETURNING balance`,
 		withdrawal.Sum, userID).
 		Scan(&newBalance)
 
@@ -241,6 +729,9 @@ func (s *PG) CreateWithdrawal(ctx context.Context, userID int64, withdrawal With
 	if newBalance < 0 {
 		return ErrInsufficientBalance
 	}
+	if err = consumeLots(ctx, tx, userID, withdrawal.Sum); err != nil {
+		return err
+	}
 
 	_, err = tx.Exec(ctx,
 		`INSERT INTO "withdrawal"("order", "sum", "user_id", "processed_at") VALUES($1, $2, $3, now())`,
@@ -254,6 +745,7 @@ func (s *PG) CreateWithdrawal(ctx context.Context, userID int64, withdrawal With
 		return fmt.Errorf("cant commit tx %w", err)
 	}
 
+	logger.InfoContext(ctx, "withdrawal created", "sum", withdrawal.Sum)
 	return nil
 
 }
@@ -323,7 +815,12 @@ func (s *PG) SelectOrdersForCheckStatus(ctx context.Context, limit int, uploaded
 
 	if len(ordersInRegisteredStatus) > 0 {
 		_, err = tx.Exec(ctx,
-			`UPDATE "order" SET "status" = 'PROCESSING' WHERE "order" = ANY($1)`, ordersInRegisteredStatus)
+			`WITH updates AS (`+
+				` UPDATE "order" SET "status" = 'PROCESSING' WHERE "order" = ANY($1) RETURNING "order", "user_id"), `+
+				`bumped AS (UPDATE "user" SET `+bumpChangeVersion+` WHERE id IN (SELECT "user_id" FROM updates)) `+
+				`INSERT INTO order_status_history ("order", "status", "changed_at") `+
+				` SELECT "order", 'PROCESSING', now() FROM updates`,
+			ordersInRegisteredStatus)
 		if err != nil {
 			return nil, fmt.Errorf("cant change orders status from NEW to PROCESSING: %w", err)
 		}
@@ -342,6 +839,7 @@ func (s *PG) UpdateOrderStatus(ctx context.Context, orders []OrderUpdateStatus)
 	if err != nil {
 		return fmt.Errorf("cannot begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx)
 
 	_, err = tx.Exec(ctx,
 		`CREATE TEMP TABLE tmp_table ON COMMIT DROP AS `+
@@ -362,34 +860,231 @@ func (s *PG) UpdateOrderStatus(ctx context.Context, orders []OrderUpdateStatus)
 		return fmt.Errorf("cannot insert rows to temp table: %w", err)
 	}
 
+	// начисление умножается на множитель текущего уровня пользователя, после начисления
+	// уровень пересчитывается по сумме базовых начислений за 12 месяцев
 	_, err = tx.Exec(ctx,
 		`WITH last_status as ( `+
 			` SELECT * FROM tmp_table `+
 			` WHERE NOT EXISTS(`+
-			`  SELECT 1 FROM tmp_table following WHERE tmp_table.processed_at < following.processed_at)), `+
+			`  SELECT 1 FROM tmp_table following `+
+			`  WHERE following.order = tmp_table.order AND tmp_table.processed_at < following.processed_at)), `+
 			`updates as (`+
 			` UPDATE "order" SET `+
 			`  "status" = last_status.status, `+
 			`  "processed_at" = last_status.processed_at, `+
-			`  "accrual" = last_status.accrual `+
-			` FROM last_status WHERE last_status.order = "order"."order" AND "order".status != last_status.status `+
-			` RETURNING "user_id", last_status."accrual", last_status."status"), `+
+			`  "base_accrual" = last_status.accrual, `+
+			`  "accrual" = round(last_status.accrual * `+multiplierSQL(`"user".tier`, 2, 3, 4)+`, 2), `+
+			`  "tier" = CASE WHEN last_status.status = 'PROCESSED' THEN "user".tier END `+
+			` FROM last_status, "user" `+
+			` WHERE last_status.order = "order"."order" AND "user".id = "order".user_id `+
+			`  AND "order".status != last_status.status `+
+			` RETURNING "order"."order", "order"."user_id", "order"."accrual", "order"."base_accrual", `+
+			`  "order"."status", "order"."processed_at"), `+
+			`history as ( `+
+			` INSERT INTO order_status_history ("order", "status", "accrual", "changed_at") `+
+			`  SELECT "order", "status", "accrual", "processed_at" FROM updates), `+
+			`lots as ( `+
+			` INSERT INTO point_lot (user_id, "order", amount, remaining, credited_at, expires_at) `+
+			`  SELECT user_id, "order", accrual, accrual, processed_at, processed_at + make_interval(months => $1) `+
+			`  FROM updates WHERE status = 'PROCESSED' AND accrual > 0), `+
 			`grouped_updates as ( `+
-			` SELECT sum(accrual) AS accrual_sum, user_id `+
+			` SELECT coalesce(sum(accrual) FILTER (WHERE status = 'PROCESSED'), 0) AS accrual_sum, `+
+			`  coalesce(sum(base_accrual) FILTER (WHERE status = 'PROCESSED' AND processed_at >= `+tierWindowStart+`), 0) `+
+			`   AS base_accrual_sum, `+
+			`  user_id `+
 			`  FROM updates `+
-			`  WHERE status = 'PROCESSED' `+
-			`  GROUP BY updates.user_id) `+
+			`  GROUP BY updates.user_id), `+
+			// в снимке запроса только что обработанные заказы ещё не PROCESSED, их сумма добавляется отдельно
+			`rolling as ( `+
+			` SELECT grouped_updates.*, base_accrual_sum + (`+rollingAccrualSQL(`grouped_updates.user_id`)+`) AS rolling_sum `+
+			`  FROM grouped_updates) `+
 			`UPDATE "user" `+
-			` SET balance = balance + accrual_sum `+
-			` FROM grouped_updates `+
-			` WHERE "user"."id" = grouped_updates.user_id`)
+			` SET balance = balance + accrual_sum, `+
+			`  tier = `+tierSQL(`rolling_sum`, 5, 6)+`, `+
+			`  tier_updated_at = CASE WHEN tier != `+tierSQL(`rolling_sum`, 5, 6)+` THEN now() ELSE tier_updated_at END, `+
+			bumpChangeVersion+
+			` FROM rolling `+
+			` WHERE "user"."id" = rolling.user_id`,
+		s.pointsExpiryMonths,
+		s.tiers.BronzeMultiplier, s.tiers.SilverMultiplier, s.tiers.GoldMultiplier,
+		s.tiers.SilverThreshold, s.tiers.GoldThreshold)
 	if err != nil {
 		return fmt.Errorf("cannot update order from temp table: %w", err)
 	}
+	if err = s.applyReferralBonuses(ctx, tx); err != nil {
+		return err
+	}
 
 	if err = tx.Commit(ctx); err != nil {
 		return fmt.Errorf("commit transaction: %w", err)
 	}
 
+	logger.DebugContext(ctx, "order statuses updated", "orders", len(orders))
+	return nil
+}
+
+func (s *PG) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT kid, secret, created_at, expires_at FROM signing_key
+		WHERE expires_at > now() ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("cant select signing keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []SigningKey
+
+	for rows.Next() {
+		var v SigningKey
+		err = rows.Scan(&v.KID, &v.Secret, &v.CreatedAt, &v.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse row from select signing keys: %w", err)
+		}
+		keys = append(keys, v)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PG) CreateSigningKey(ctx context.Context, key SigningKey) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM signing_key WHERE expires_at <= now()`)
+	if err != nil {
+		return fmt.Errorf("delete expired signing keys error: %w", err)
+	}
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO signing_key (kid, secret, created_at, expires_at) VALUES ($1, $2, $3, $4)`,
+		key.KID, key.Secret, key.CreatedAt, key.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("create signing key error: %w", err)
+	}
+	return nil
+}
+
+func (s *PG) CreateRefreshToken(ctx context.Context, userID int64, ttl time.Duration) (*RefreshToken, error) {
+	return s.createRefreshToken(ctx, s.db, userID, ttl)
+}
+
+type execer interface {
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+}
+
+func (s *PG) createRefreshToken(ctx context.Context, db execer, userID int64, ttl time.Duration) (*RefreshToken, error) {
+	token, err := generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token error: %w", err)
+	}
+	now := time.Now()
+	refreshToken := &RefreshToken{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	_, err = db.Exec(ctx,
+		`INSERT INTO refresh_token (user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, hashToken(token), now, refreshToken.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("create refresh token error: %w", err)
+	}
+	return refreshToken, nil
+}
+
+// RotateRefreshToken отзывает предъявленный refresh токен и выпускает вместо него новый.
+// Повторное предъявление уже отозванного токена считается признаком утечки:
+// в этом случае отзываются все refresh токены пользователя.
+func (s *PG) RotateRefreshToken(ctx context.Context, token string, ttl time.Duration) (*RefreshToken, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		id        int64
+		userID    int64
+		expiresAt time.Time
+		revokedAt sql.NullTime
+		isActive  bool
+	)
+	err = tx.QueryRow(ctx,
+		`SELECT refresh_token.id, user_id, expires_at, revoked_at, "user".is_active
+		FROM refresh_token JOIN "user" ON "user".id = refresh_token.user_id
+		WHERE token_hash = $1 FOR UPDATE OF refresh_token`,
+		hashToken(token)).
+		Scan(&id, &userID, &expiresAt, &revokedAt, &isActive)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrWrongToken
+		}
+		return nil, fmt.Errorf("cant select refresh token: %w", err)
+	}
+
+	if revokedAt.Valid {
+		_, err = tx.Exec(ctx,
+			`UPDATE refresh_token SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		if err != nil {
+			return nil, fmt.Errorf("revoke refresh tokens error: %w", err)
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("cant commit tx %w", err)
+		}
+		return nil, ErrWrongToken
+	}
+	if !expiresAt.After(time.Now()) || !isActive {
+		return nil, ErrWrongToken
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_token SET revoked_at = now() WHERE id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("revoke refresh token error: %w", err)
+	}
+	refreshToken, err := s.createRefreshToken(ctx, tx, userID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cant commit tx %w", err)
+	}
+	return refreshToken, nil
+}
+
+func (s *PG) RevokeRefreshToken(ctx context.Context, token string) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE refresh_token SET revoked_at = now() WHERE token_hash = $1 AND revoked_at IS NULL`,
+		hashToken(token))
+	if err != nil {
+		return fmt.Errorf("revoke refresh token error: %w", err)
+	}
+	return nil
+}
+
+func (s *PG) TakeRateLimitToken(ctx context.Context, key string, burst float64, rate float64) (float64, error) {
+	var tokens float64
+	err := s.db.QueryRow(ctx, `
+		WITH prev AS (
+			SELECT least($2::float8,
+				tokens + extract(epoch from now() - updated_at)::float8 * $3::float8) AS tokens
+			FROM rate_limit WHERE key = $1 FOR UPDATE),
+		cur AS (
+			SELECT coalesce((SELECT tokens FROM prev), $2::float8) AS tokens)
+		INSERT INTO rate_limit (key, tokens, updated_at)
+		SELECT $1, CASE WHEN cur.tokens >= 1 THEN cur.tokens - 1 ELSE cur.tokens END, now() FROM cur
+		ON CONFLICT (key) DO UPDATE SET tokens = EXCLUDED.tokens, updated_at = EXCLUDED.updated_at
+		RETURNING (SELECT tokens FROM cur)`,
+		key, burst, rate).
+		Scan(&tokens)
+	if err != nil {
+		return 0, fmt.Errorf("take rate limit token error: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *PG) DeleteStaleRateLimitTokens(ctx context.Context, olderThan time.Duration) error {
+	_, err := s.db.Exec(ctx,
+		`DELETE FROM rate_limit WHERE updated_at < now() - $1::float8 * interval '1 second'`,
+		olderThan.Seconds())
+	if err != nil {
+		return fmt.Errorf("delete stale rate limit tokens error: %w", err)
+	}
 	return nil
 }
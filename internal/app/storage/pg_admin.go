@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const userInfoColumns = `id, login, role, is_active, balance, withdrawn, created_at, deleted_at, locked_until`
+
+func scanUserInfo(row pgx.Row) (UserInfo, error) {
+	var (
+		v           UserInfo
+		role        string
+		createdAt   sql.NullTime
+		deletedAt   sql.NullTime
+		lockedUntil sql.NullTime
+	)
+	err := row.Scan(&v.ID, &v.Login, &role, &v.IsActive, &v.Balance, &v.Withdrawn,
+		&createdAt, &deletedAt, &lockedUntil)
+	if err != nil {
+		return v, err
+	}
+	v.Role = Role(role)
+	v.CreatedAt = RFC3339DateTime(createdAt)
+	v.DeletedAt = RFC3339DateTime(deletedAt)
+	v.LockedUntil = RFC3339DateTime(lockedUntil)
+	return v, nil
+}
+
+// GetUserRole возвращает роль пользователя, для заблокированного пользователя - ErrUserBlocked
+func (s *PG) GetUserRole(ctx context.Context, userID int64) (Role, error) {
+	var (
+		role     string
+		isActive bool
+	)
+	err := s.db.QueryRow(ctx, `SELECT role, is_active FROM "user" WHERE id = $1`, userID).
+		Scan(&role, &isActive)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrUserNotFound
+		}
+		return "", fmt.Errorf("cant select user role: %w", err)
+	}
+	if !isActive {
+		return "", ErrUserBlocked
+	}
+	return Role(role), nil
+}
+
+// SearchUsers ищет пользователей по части логина (или по точному id, если query - число)
+func (s *PG) SearchUsers(ctx context.Context, query string, limit int, offset int) ([]UserInfo, error) {
+	userID, _ := strconv.ParseInt(query, 10, 64)
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+userInfoColumns+` FROM "user"
+		WHERE login ILIKE $1 OR id = $2
+		ORDER BY id LIMIT $3 OFFSET $4`,
+		pattern, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("cant select users: %w", err)
+	}
+	defer rows.Close()
+	var users []UserInfo
+
+	for rows.Next() {
+		v, err := scanUserInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse row from select users: %w", err)
+		}
+		users = append(users, v)
+	}
+	return users, rows.Err()
+}
+
+func (s *PG) GetUser(ctx context.Context, userID int64) (*UserInfo, error) {
+	v, err := scanUserInfo(s.db.QueryRow(ctx,
+		`SELECT `+userInfoColumns+` FROM "user" WHERE id = $1`, userID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("cant select user: %w", err)
+	}
+	return &v, nil
+}
+
+// AdjustBalance вручную изменяет баланс пользователя на adjustment.Amount (может быть отрицательным).
+// Баланс не может уйти в минус. Начисление становится новой партией баллов со сроком действия,
+// списание расходует партии, как обычное списание.
+func (s *PG) AdjustBalance(ctx context.Context, adjustment BalanceAdjustment) (*Balance, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// как и при списании, просроченные баллы сжигаются под блокировкой строки пользователя,
+	// иначе ручное списание могло бы израсходовать их до запуска ExpirePoints
+	var userID int64
+	err = tx.QueryRow(ctx, `SELECT id FROM "user" WHERE id = $1 FOR UPDATE`, adjustment.UserID).Scan(&userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("lock user error: %w", err)
+	}
+	if adjustment.Amount < 0 {
+		if _, err = expireLots(ctx, tx, []int64{adjustment.UserID}); err != nil {
+			return nil, err
+		}
+	}
+
+	balance := &Balance{}
+	err = tx.QueryRow(ctx,
+		`UPDATE "user" SET balance = balance + $1, `+bumpChangeVersion+` WHERE id = $2 RETURNING balance, withdrawn`,
+		adjustment.Amount, adjustment.UserID).
+		Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("update user balance error: %w", err)
+	}
+	if balance.Current < 0 {
+		return nil, ErrInsufficientBalance
+	}
+	if adjustment.Amount > 0 {
+		_, err = tx.Exec(ctx, insertAdjustmentLot, adjustment.UserID, adjustment.Amount, s.pointsExpiryMonths)
+		if err != nil {
+			return nil, fmt.Errorf("create point lot error: %w", err)
+		}
+	} else if err = consumeLots(ctx, tx, adjustment.UserID, -adjustment.Amount); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO balance_adjustment (user_id, amount, reason, actor_id, created_at)
+		VALUES ($1, $2, $3, $4, now())`,
+		adjustment.UserID, adjustment.Amount, adjustment.Reason, adjustment.ActorID)
+	if err != nil {
+		return nil, fmt.Errorf("create balance adjustment error: %w", err)
+	}
+
+	err = insertAuditRecord(ctx, tx, AuditRecord{
+		ActorID:      adjustment.ActorID,
+		Action:       "balance.adjust",
+		TargetUserID: &adjustment.UserID,
+		Reason:       adjustment.Reason,
+	}, map[string]interface{}{"amount": adjustment.Amount, "balance": balance.Current})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cant commit tx %w", err)
+	}
+	return balance, nil
+}
+
+// SetUserActive блокирует или разблокирует пользователя.
+// При блокировке все сессии пользователя завершаются, а refresh токены отзываются.
+// Блокировать и разблокировать администраторов может только администратор: попытка другого сотрудника
+// записывается в журнал аудита (действие с суффиксом .denied) и возвращает ErrPermissionDenied.
+func (s *PG) SetUserActive(ctx context.Context, actorID int64, userID int64, active bool, reason string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	action := "user.unblock"
+	if !active {
+		action = "user.block"
+	}
+
+	var targetRole, actorRole string
+	err = tx.QueryRow(ctx,
+		`SELECT role FROM "user" WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, userID).Scan(&targetRole)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("lock user error: %w", err)
+	}
+	err = tx.QueryRow(ctx, `SELECT role FROM "user" WHERE id = $1`, actorID).Scan(&actorRole)
+	if err != nil && err != pgx.ErrNoRows {
+		return fmt.Errorf("cant select actor role: %w", err)
+	}
+	if Role(targetRole) == RoleAdmin && Role(actorRole) != RoleAdmin {
+		err = insertAuditRecord(ctx, tx, AuditRecord{
+			ActorID:      actorID,
+			Action:       action + ".denied",
+			TargetUserID: &userID,
+			Reason:       reason,
+		}, nil)
+		if err != nil {
+			return err
+		}
+		if err = tx.Commit(ctx); err != nil {
+			return fmt.Errorf("cant commit tx %w", err)
+		}
+		return ErrPermissionDenied
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE "user" SET is_active = $1 WHERE id = $2`, active, userID)
+	if err != nil {
+		return fmt.Errorf("update user error: %w", err)
+	}
+
+	if !active {
+		_, err = tx.Exec(ctx, `DELETE FROM user_session WHERE user_id = $1`, userID)
+		if err != nil {
+			return fmt.Errorf("delete sessions error: %w", err)
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE refresh_token SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		if err != nil {
+			return fmt.Errorf("revoke refresh tokens error: %w", err)
+		}
+	}
+
+	err = insertAuditRecord(ctx, tx, AuditRecord{
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: &userID,
+		Reason:       reason,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cant commit tx %w", err)
+	}
+	return nil
+}
+
+func (s *PG) SetUserRole(ctx context.Context, actorID int64, userID int64, role Role, reason string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE "user" SET role = $1 WHERE id = $2 AND deleted_at IS NULL`, string(role), userID)
+	if err != nil {
+		return fmt.Errorf("update user role error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	err = insertAuditRecord(ctx, tx, AuditRecord{
+		ActorID:      actorID,
+		Action:       "user.role",
+		TargetUserID: &userID,
+		Reason:       reason,
+	}, map[string]interface{}{"role": role})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cant commit tx %w", err)
+	}
+	return nil
+}
+
+// RecheckOrder возвращает заказ в статус NEW, чтобы OrderChecker заново запросил его в системе расчёта баллов.
+// Заказы в статусе PROCESSED не перепроверяются: начисление по ним уже зачислено на баланс.
+func (s *PG) RecheckOrder(ctx context.Context, actorID int64, order string, reason string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		userID int64
+		status string
+	)
+	err = tx.QueryRow(ctx,
+		`SELECT user_id, status FROM "order" WHERE "order" = $1 FOR UPDATE`, order).
+		Scan(&userID, &status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("cant select order: %w", err)
+	}
+	if status == "PROCESSED" {
+		return ErrOrderAlreadyProcessed
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE "order" SET status = 'NEW', accrual = NULL, processed_at = NULL WHERE "order" = $1`, order)
+	if err != nil {
+		return fmt.Errorf("update order error: %w", err)
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO order_status_history ("order", "status", "changed_at") VALUES ($1, 'NEW', now())`, order)
+	if err != nil {
+		return fmt.Errorf("create order status history error: %w", err)
+	}
+	_, err = tx.Exec(ctx, `UPDATE "user" SET `+bumpChangeVersion+` WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("update user change version error: %w", err)
+	}
+
+	err = insertAuditRecord(ctx, tx, AuditRecord{
+		ActorID:      actorID,
+		Action:       "order.recheck",
+		TargetUserID: &userID,
+		TargetOrder:  &order,
+		Reason:       reason,
+	}, map[string]interface{}{"previous_status": status})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cant commit tx %w", err)
+	}
+	return nil
+}
+
+func (s *PG) CreateAuditRecord(ctx context.Context, record AuditRecord) error {
+	return insertAuditRecord(ctx, s.db, record, record.Details)
+}
+
+func insertAuditRecord(ctx context.Context, db execer, record AuditRecord, details interface{}) error {
+	var detailsJSON []byte
+	switch d := details.(type) {
+	case nil:
+	case json.RawMessage:
+		if len(d) > 0 {
+			detailsJSON = d
+		}
+	default:
+		var err error
+		if detailsJSON, err = json.Marshal(d); err != nil {
+			return fmt.Errorf("marshal audit details error: %w", err)
+		}
+	}
+
+	var reason *string
+	if record.Reason != "" {
+		reason = &record.Reason
+	}
+
+	_, err := db.Exec(ctx,
+		`INSERT INTO admin_audit (actor_id, action, target_user_id, target_order, reason, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now())`,
+		record.ActorID, record.Action, record.TargetUserID, record.TargetOrder, reason, detailsJSON)
+	if err != nil {
+		return fmt.Errorf("create audit record error: %w", err)
+	}
+	return nil
+}
+
+func (s *PG) GetAuditRecords(ctx context.Context, targetUserID *int64, limit int, offset int) ([]AuditRecord, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, actor_id, action, target_user_id, target_order, reason, details, created_at
+		FROM admin_audit WHERE $1::bigint IS NULL OR target_user_id = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`,
+		targetUserID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("cant select audit records: %w", err)
+	}
+	defer rows.Close()
+	var records []AuditRecord
+
+	for rows.Next() {
+		var (
+			v         AuditRecord
+			reason    sql.NullString
+			details   []byte
+			createdAt sql.NullTime
+		)
+		err = rows.Scan(&v.ID, &v.ActorID, &v.Action, &v.TargetUserID, &v.TargetOrder, &reason, &details, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse row from select audit records: %w", err)
+		}
+		v.Reason = reason.String
+		v.Details = details
+		v.CreatedAt = RFC3339DateTime(createdAt)
+		records = append(records, v)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// exportFetchSize сколько строк за раз забирать из курсора при выгрузке
+const exportFetchSize = 500
+
+// streamCursor выполняет query через серверный курсор и вызывает scan для каждой строки,
+// забирая строки пачками по exportFetchSize: выгрузка любого размера не собирается в памяти целиком.
+// Если scan вернул ошибку, выгрузка прерывается.
+func (s *PG) streamCursor(ctx context.Context, query string, args []interface{}, scan func(pgx.Rows) error) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly, IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DECLARE export_cursor NO SCROLL CURSOR FOR `+query, args...)
+	if err != nil {
+		return fmt.Errorf("declare cursor error: %w", err)
+	}
+
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf(`FETCH %d FROM export_cursor`, exportFetchSize))
+		if err != nil {
+			return fmt.Errorf("fetch cursor error: %w", err)
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			if err = scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("fetch cursor error: %w", err)
+		}
+		if fetched < exportFetchSize {
+			break
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PG) ExportOrders(ctx context.Context, userID int64, fn func(Order) error) error {
+	return s.streamCursor(ctx,
+		`SELECT "order", "accrual", "status", "processed_at", "uploaded_at"
+		FROM "order" WHERE user_id = $1 ORDER BY "uploaded_at"`,
+		[]interface{}{userID},
+		func(rows pgx.Rows) error {
+			var (
+				v           Order
+				uploadedAt  sql.NullTime
+				processedAt sql.NullTime
+			)
+			if err := rows.Scan(&v.OrderNum, &v.Accrual, &v.Status, &processedAt, &uploadedAt); err != nil {
+				return fmt.Errorf("cant parse row from export orders: %w", err)
+			}
+			v.UploadedAt = RFC3339DateTime(uploadedAt)
+			v.ProcessedAt = RFC3339DateTime(processedAt)
+			return fn(v)
+		})
+}
+
+func (s *PG) ExportWithdrawals(ctx context.Context, userID int64, fn func(Withdrawal) error) error {
+	return s.streamCursor(ctx,
+		`SELECT "order", "sum", "processed_at"
+		FROM "withdrawal" WHERE "user_id" = $1 ORDER BY "processed_at"`,
+		[]interface{}{userID},
+		func(rows pgx.Rows) error {
+			var (
+				v           Withdrawal
+				processedAt sql.NullTime
+			)
+			if err := rows.Scan(&v.OrderNum, &v.Sum, &processedAt); err != nil {
+				return fmt.Errorf("cant parse row from export withdrawals: %w", err)
+			}
+			v.ProcessedAt = RFC3339DateTime(processedAt)
+			return fn(v)
+		})
+}
+
+// ExportHistory все движения по счёту баллов (как в выписке) в порядке проведения
+func (s *PG) ExportHistory(ctx context.Context, userID int64, fn func(StatementEntry) error) error {
+	return s.streamCursor(ctx,
+		`WITH `+statementEntriesCTE+`
+		SELECT type, order_num, reason, amount, balance, at
+		FROM running ORDER BY at, type, ref`,
+		[]interface{}{userID},
+		func(rows pgx.Rows) error {
+			var (
+				v           StatementEntry
+				processedAt sql.NullTime
+			)
+			if err := rows.Scan(&v.Type, &v.OrderNum, &v.Reason, &v.Amount, &v.Balance, &processedAt); err != nil {
+				return fmt.Errorf("cant parse row from export history: %w", err)
+			}
+			v.ProcessedAt = RFC3339DateTime(processedAt)
+			return fn(v)
+		})
+}
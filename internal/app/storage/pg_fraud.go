@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// selectFraudDecision колонки fraud_decision в порядке scanFraudDecision
+const selectFraudDecision = `SELECT id, user_id, action, "order", sum, outcome, rules, ip, created_at,
+	reviewer_id, resolution, review_reason, reviewed_at FROM fraud_decision`
+
+// CountOrderUploads сколько номеров заказов пользователь загрузил начиная с since
+func (s *PG) CountOrderUploads(ctx context.Context, userID int64, since time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow(ctx,
+		`SELECT count(*) FROM "order" WHERE user_id = $1 AND uploaded_at >= $2`, userID, since).
+		Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("cant count order uploads: %w", err)
+	}
+	return count, nil
+}
+
+// CountOrderConflicts сколько раз начиная с since пользователь пытался загрузить номер заказа другого пользователя
+func (s *PG) CountOrderConflicts(ctx context.Context, userID int64, since time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow(ctx,
+		`SELECT count(*) FROM order_conflict WHERE user_id = $1 AND created_at >= $2`, userID, since).
+		Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("cant count order conflicts: %w", err)
+	}
+	return count, nil
+}
+
+// GetUserCreatedAt время регистрации пользователя
+func (s *PG) GetUserCreatedAt(ctx context.Context, userID int64) (time.Time, error) {
+	var createdAt time.Time
+	err := s.db.QueryRow(ctx, `SELECT created_at FROM "user" WHERE id = $1`, userID).Scan(&createdAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return time.Time{}, ErrUserNotFound
+		}
+		return time.Time{}, fmt.Errorf("cant select user: %w", err)
+	}
+	return createdAt, nil
+}
+
+// GetLastAccrualAt время последнего начисления баллов за заказ (nil - начислений не было)
+func (s *PG) GetLastAccrualAt(ctx context.Context, userID int64) (*time.Time, error) {
+	var processedAt sql.NullTime
+	err := s.db.QueryRow(ctx,
+		`SELECT max(processed_at) FROM "order"
+		WHERE user_id = $1 AND status = 'PROCESSED' AND accrual IS NOT NULL AND accrual != 0`,
+		userID).
+		Scan(&processedAt)
+	if err != nil {
+		return nil, fmt.Errorf("cant select last accrual: %w", err)
+	}
+	if !processedAt.Valid {
+		return nil, nil
+	}
+	return &processedAt.Time, nil
+}
+
+// CreateFraudDecision сохраняет отмеченное или отклонённое действие для проверки сотрудником
+func (s *PG) CreateFraudDecision(ctx context.Context, decision FraudDecision) (int64, error) {
+	rules, err := json.Marshal(decision.Rules)
+	if err != nil {
+		return 0, fmt.Errorf("marshal fraud rules error: %w", err)
+	}
+	nullable := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+
+	var id int64
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO fraud_decision (user_id, action, "order", sum, outcome, rules, ip, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now()) RETURNING id`,
+		decision.UserID, string(decision.Action), nullable(decision.OrderNum), decision.Sum, string(decision.Outcome), rules,
+		nullable(decision.IP)).
+		Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("create fraud decision error: %w", err)
+	}
+	return id, nil
+}
+
+// GetFraudDecisions решения антифрода по фильтру, новые первыми
+func (s *PG) GetFraudDecisions(ctx context.Context, filter FraudDecisionFilter, limit int, offset int) ([]FraudDecision, error) {
+	rows, err := s.db.Query(ctx,
+		selectFraudDecision+`
+		WHERE ($1::bigint IS NULL OR user_id = $1)
+			AND ($2 = '' OR outcome = $2)
+			AND ($3::boolean IS NULL OR (reviewed_at IS NOT NULL) = $3)
+		ORDER BY created_at DESC, id DESC LIMIT $4 OFFSET $5`,
+		filter.UserID, string(filter.Outcome), filter.Reviewed, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("cant select fraud decisions: %w", err)
+	}
+	defer rows.Close()
+	decisions := []FraudDecision{}
+
+	for rows.Next() {
+		decision, err := scanFraudDecision(rows)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse row from select fraud decisions: %w", err)
+		}
+		decisions = append(decisions, *decision)
+	}
+	return decisions, rows.Err()
+}
+
+// ReviewFraudDecision решение сотрудника по отмеченному действию: confirmed - нарушение подтверждено,
+// dismissed - ложное срабатывание. Повторно пересмотреть решение нельзя.
+func (s *PG) ReviewFraudDecision(ctx context.Context, actorID int64, id int64, resolution string, reason string) (*FraudDecision, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	decision, err := scanFraudDecision(tx.QueryRow(ctx, selectFraudDecision+` WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrFraudDecisionNotFound
+		}
+		return nil, fmt.Errorf("cant select fraud decision: %w", err)
+	}
+	if decision.ReviewedAt != nil {
+		return nil, ErrFraudDecisionReviewed
+	}
+
+	var reviewedAt time.Time
+	err = tx.QueryRow(ctx,
+		`UPDATE fraud_decision SET reviewer_id = $2, resolution = $3, review_reason = $4, reviewed_at = now()
+		WHERE id = $1 RETURNING reviewed_at`,
+		id, actorID, resolution, reason).
+		Scan(&reviewedAt)
+	if err != nil {
+		return nil, fmt.Errorf("update fraud decision error: %w", err)
+	}
+
+	var targetOrder *string
+	if decision.OrderNum != "" {
+		targetOrder = &decision.OrderNum
+	}
+	err = insertAuditRecord(ctx, tx, AuditRecord{
+		ActorID:      actorID,
+		Action:       "fraud_decision.review",
+		TargetUserID: &decision.UserID,
+		TargetOrder:  targetOrder,
+		Reason:       reason,
+	}, map[string]interface{}{"decision_id": id, "resolution": resolution})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("cant commit tx %w", err)
+	}
+	decision.ReviewerID = &actorID
+	decision.Resolution = resolution
+	decision.ReviewReason = reason
+	decision.ReviewedAt = &RFC3339DateTime{Time: reviewedAt, Valid: true}
+	return decision, nil
+}
+
+func scanFraudDecision(row pgx.Row) (*FraudDecision, error) {
+	var (
+		v                                   FraudDecision
+		action, outcome                     string
+		order, ip, resolution, reviewReason sql.NullString
+		rules                               []byte
+		createdAt, reviewedAt               sql.NullTime
+	)
+	err := row.Scan(&v.ID, &v.UserID, &action, &order, &v.Sum, &outcome, &rules, &ip, &createdAt,
+		&v.ReviewerID, &resolution, &reviewReason, &reviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(rules, &v.Rules); err != nil {
+		return nil, fmt.Errorf("cant parse fraud rules: %w", err)
+	}
+	v.Action = FraudAction(action)
+	v.Outcome = FraudOutcome(outcome)
+	v.OrderNum = order.String
+	v.IP = ip.String
+	v.Resolution = resolution.String
+	v.ReviewReason = reviewReason.String
+	v.CreatedAt = RFC3339DateTime(createdAt)
+	if reviewedAt.Valid {
+		at := RFC3339DateTime(reviewedAt)
+		v.ReviewedAt = &at
+	}
+	return &v, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Начисленные баллы хранятся партиями (point_lot) со сроком действия, сумма остатков партий равна балансу.
+// Списания расходуют партии начиная с ближайших к сгоранию (FIFO), непотраченный остаток сгорает
+// в expires_at и попадает в выписку как движение point_expiration.
+// Партии пользователя меняются только под блокировкой его строки в "user" (UPDATE или SELECT FOR UPDATE
+// перед изменением партий), поэтому списания, начисления и сгорание по одному пользователю не пересекаются.
+
+// upcomingExpirationsLimit сколько ближайших сгораний показывать в балансе
+const upcomingExpirationsLimit = 5
+
+// insertAdjustmentLot партия для положительной ручной корректировки
+const insertAdjustmentLot = `INSERT INTO point_lot (user_id, amount, remaining, credited_at, expires_at)
+	VALUES ($1, $2, $2, now(), now() + make_interval(months => $3))`
+
+// consumeLots списывает amount с партий пользователя, начиная с тех, что сгорят раньше.
+// Строка пользователя должна быть заблокирована в tx.
+func consumeLots(ctx context.Context, tx pgx.Tx, userID int64, amount float64) error {
+	_, err := tx.Exec(ctx,
+		`WITH ordered AS (
+			SELECT id, remaining, sum(remaining) OVER (ORDER BY expires_at, id) AS running
+			FROM point_lot WHERE user_id = $1 AND remaining > 0)
+		UPDATE point_lot SET remaining = greatest(0, least(ordered.remaining, ordered.running - $2))
+		FROM ordered
+		WHERE point_lot.id = ordered.id AND ordered.running - ordered.remaining < $2`,
+		userID, amount)
+	if err != nil {
+		return fmt.Errorf("cant consume point lots: %w", err)
+	}
+	return nil
+}
+
+// expireLots сжигает остатки просроченных партий пользователей userIDs и уменьшает их балансы.
+// Строки пользователей должны быть заблокированы в tx. Возвращает сгоревшую сумму.
+func expireLots(ctx context.Context, tx pgx.Tx, userIDs []int64) (float64, error) {
+	var expired float64
+	err := tx.QueryRow(ctx,
+		`WITH due AS (
+			SELECT id, user_id, remaining, expires_at FROM point_lot
+			WHERE user_id = ANY($1) AND remaining > 0 AND expires_at <= now()),
+		expired AS (
+			UPDATE point_lot SET remaining = 0 FROM due WHERE point_lot.id = due.id
+			RETURNING due.id, due.user_id, due.remaining AS amount, due.expires_at),
+		movements AS (
+			INSERT INTO point_expiration (user_id, lot_id, amount, expired_at)
+			SELECT user_id, id, amount, expires_at FROM expired),
+		totals AS (
+			SELECT user_id, sum(amount) AS amount FROM expired GROUP BY user_id),
+		updated AS (
+			UPDATE "user" SET balance = greatest(balance - totals.amount, 0), `+bumpChangeVersion+`
+			FROM totals WHERE "user".id = totals.user_id
+			RETURNING totals.amount)
+		SELECT coalesce(sum(amount), 0) FROM updated`,
+		userIDs).
+		Scan(&expired)
+	if err != nil {
+		return 0, fmt.Errorf("cant expire point lots: %w", err)
+	}
+	return expired, nil
+}
+
+// ExpirePoints сжигает просроченные партии баллов не более чем у limit пользователей.
+// Возвращает число обработанных пользователей (меньше limit - больше просроченных партий нет)
+// и сгоревшую сумму.
+func (s *PG) ExpirePoints(ctx context.Context, limit int) (int, float64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// сначала блокируем пользователей, как и списания, иначе возможна взаимная блокировка
+	rows, err := tx.Query(ctx,
+		`SELECT id FROM "user"
+		WHERE id IN (
+			SELECT DISTINCT user_id FROM point_lot WHERE remaining > 0 AND expires_at <= now() LIMIT $1)
+		ORDER BY id FOR UPDATE`,
+		limit)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cant select users with expired points: %w", err)
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err = rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("cant parse row from select users with expired points: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("cant select users with expired points: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return 0, 0, nil
+	}
+
+	expired, err := expireLots(ctx, tx, userIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("cant commit tx %w", err)
+	}
+	return len(userIDs), expired, nil
+}
+
+// getUpcomingExpirations ближайшие сгорания баллов пользователя
+func (s *PG) getUpcomingExpirations(ctx context.Context, userID int64) ([]PointsExpiration, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT sum(remaining), expires_at FROM point_lot
+		WHERE user_id = $1 AND remaining > 0
+		GROUP BY expires_at ORDER BY expires_at LIMIT $2`,
+		userID, upcomingExpirationsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("cant select upcoming expirations: %w", err)
+	}
+	defer rows.Close()
+
+	expirations := []PointsExpiration{}
+	for rows.Next() {
+		var (
+			v         PointsExpiration
+			expiresAt sql.NullTime
+		)
+		if err = rows.Scan(&v.Amount, &expiresAt); err != nil {
+			return nil, fmt.Errorf("cant parse row from select upcoming expirations: %w", err)
+		}
+		v.ExpiresAt = RFC3339DateTime(expiresAt)
+		expirations = append(expirations, v)
+	}
+	return expirations, rows.Err()
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type referralCandidate struct {
+	referredID int64
+	referrerID int64
+	order      string
+}
+
+// applyReferralBonuses начисляет бонусы за приглашённых пользователей, у которых в транзакции
+// UpdateOrderStatus обработан первый заказ (заказы из tmp_table). На приглашённого бонус начисляется
+// не больше одного раза (уникальный referral_bonus.referred_id), поэтому повторная обработка ничего не меняет.
+// Строки приглашённых уже заблокированы начислением за заказ.
+func (s *PG) applyReferralBonuses(ctx context.Context, tx pgx.Tx) error {
+	rows, err := tx.Query(ctx,
+		`SELECT u.id, u.referred_by, first."order"
+		FROM "user" u
+		JOIN "user" referrer ON referrer.id = u.referred_by AND referrer.deleted_at IS NULL
+		JOIN LATERAL (
+			SELECT "order" FROM "order" o WHERE o.user_id = u.id AND o.status = 'PROCESSED'
+			ORDER BY o.processed_at, o."order" LIMIT 1) first ON true
+		WHERE u.id IN (
+				SELECT o.user_id FROM tmp_table t JOIN "order" o ON o."order" = t."order"
+				WHERE t.status = 'PROCESSED')
+			AND NOT EXISTS (SELECT 1 FROM referral_bonus b WHERE b.referred_id = u.id)
+		ORDER BY u.referred_by, u.id`)
+	if err != nil {
+		return fmt.Errorf("cant select referral candidates: %w", err)
+	}
+	var (
+		candidates  []referralCandidate
+		referrerIDs []int64
+	)
+	for rows.Next() {
+		var v referralCandidate
+		if err = rows.Scan(&v.referredID, &v.referrerID, &v.order); err != nil {
+			rows.Close()
+			return fmt.Errorf("cant parse row from select referral candidates: %w", err)
+		}
+		candidates = append(candidates, v)
+		referrerIDs = append(referrerIDs, v.referrerID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("cant select referral candidates: %w", err)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// под блокировкой пригласивших параллельные начисления не превысят лимит вознаграждаемых приглашений
+	_, err = tx.Exec(ctx, `SELECT id FROM "user" WHERE id = ANY($1) ORDER BY id FOR UPDATE`, referrerIDs)
+	if err != nil {
+		return fmt.Errorf("cant lock referrers: %w", err)
+	}
+
+	rewarded := make(map[int64]int)
+	for _, candidate := range candidates {
+		count, ok := rewarded[candidate.referrerID]
+		if !ok {
+			err = tx.QueryRow(ctx,
+				`SELECT count(*) FROM referral_bonus WHERE referrer_id = $1 AND NOT capped`, candidate.referrerID).
+				Scan(&count)
+			if err != nil {
+				return fmt.Errorf("cant count referral bonuses: %w", err)
+			}
+		}
+		capped := s.referralBonus.MaxPerReferrer > 0 && count >= s.referralBonus.MaxPerReferrer
+		referrerBonus, referredBonus := s.referralBonus.Referrer, s.referralBonus.Referred
+		if capped {
+			referrerBonus, referredBonus = 0, 0
+		}
+
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO referral_bonus (referrer_id, referred_id, "order", referrer_bonus, referred_bonus, capped, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, now())
+			ON CONFLICT (referred_id) DO NOTHING`,
+			candidate.referrerID, candidate.referredID, candidate.order, referrerBonus, referredBonus, capped)
+		if err != nil {
+			return fmt.Errorf("create referral bonus error: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			rewarded[candidate.referrerID] = count
+			continue
+		}
+		if !capped {
+			count++
+		}
+		rewarded[candidate.referrerID] = count
+
+		if err = s.creditBonus(ctx, tx, candidate.referrerID, referrerBonus); err != nil {
+			return err
+		}
+		if err = s.creditBonus(ctx, tx, candidate.referredID, referredBonus); err != nil {
+			return err
+		}
+	}
+	logger.DebugContext(ctx, "referral bonuses applied", "referrals", len(candidates))
+	return nil
+}
+
+// creditBonus зачисляет бонус партией баллов со сроком действия. Строка пользователя должна быть заблокирована в tx.
+func (s *PG) creditBonus(ctx context.Context, tx pgx.Tx, userID int64, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx,
+		`UPDATE "user" SET balance = balance + $2, `+bumpChangeVersion+` WHERE id = $1`, userID, amount)
+	if err != nil {
+		return fmt.Errorf("update user balance error: %w", err)
+	}
+	if _, err = tx.Exec(ctx, insertAdjustmentLot, userID, amount, s.pointsExpiryMonths); err != nil {
+		return fmt.Errorf("create point lot error: %w", err)
+	}
+	return nil
+}
+
+// GetReferrals реферальный код пользователя, условия программы и приглашённые им пользователи, новые первыми
+func (s *PG) GetReferrals(ctx context.Context, userID int64, limit int, offset int) (*Referrals, error) {
+	referrals := &Referrals{
+		ReferrerBonus: s.referralBonus.Referrer,
+		ReferredBonus: s.referralBonus.Referred,
+		Referrals:     []Referral{},
+	}
+	if maxRewarded := s.referralBonus.MaxPerReferrer; maxRewarded > 0 {
+		referrals.MaxRewarded = &maxRewarded
+	}
+	err := s.db.QueryRow(ctx,
+		`SELECT referral_code,
+			(SELECT count(*) FROM referral_bonus WHERE referrer_id = $1 AND NOT capped),
+			(SELECT coalesce(sum(referrer_bonus), 0) FROM referral_bonus WHERE referrer_id = $1)
+		FROM "user" WHERE id = $1`, userID).
+		Scan(&referrals.Code, &referrals.Rewarded, &referrals.Earned)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("cant select referral code: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT left(u.login, 2) || '***', u.created_at, b.capped, b.referrer_bonus, b.created_at
+		FROM "user" u LEFT JOIN referral_bonus b ON b.referred_id = u.id
+		WHERE u.referred_by = $1
+		ORDER BY u.created_at DESC, u.id DESC LIMIT $2 OFFSET $3`,
+		userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("cant select referrals: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			v                        Referral
+			capped                   sql.NullBool
+			bonus                    *float64
+			registeredAt, rewardedAt sql.NullTime
+		)
+		if err = rows.Scan(&v.Login, &registeredAt, &capped, &bonus, &rewardedAt); err != nil {
+			return nil, fmt.Errorf("cant parse row from select referrals: %w", err)
+		}
+		v.RegisteredAt = RFC3339DateTime(registeredAt)
+		switch {
+		case !capped.Valid:
+			v.Status = ReferralPending
+		case capped.Bool:
+			v.Status = ReferralCapped
+		default:
+			v.Status = ReferralRewarded
+			v.Bonus = bonus
+			at := RFC3339DateTime(rewardedAt)
+			v.RewardedAt = &at
+		}
+		referrals.Referrals = append(referrals.Referrals, v)
+	}
+	return referrals, rows.Err()
+}
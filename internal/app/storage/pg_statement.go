@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// statementEntriesCTE все движения по счёту пользователя $1 в порядке их проведения
+// с балансом после каждого движения (balance). Порядок однозначный: при равном времени
+// движения упорядочиваются по типу и ссылке (ref).
+const statementEntriesCTE = `
+	entries AS (
+		SELECT processed_at AS at, '` + StatementAccrual + `' AS type, "order" AS ref,
+			"order" AS order_num, NULL::text AS reason, accrual AS amount
+		FROM "order"
+		WHERE user_id = $1 AND status = 'PROCESSED' AND accrual IS NOT NULL AND accrual != 0
+		UNION ALL
+		SELECT processed_at, '` + StatementWithdrawal + `', id::text, "order", NULL, -sum
+		FROM withdrawal WHERE user_id = $1
+		UNION ALL
+		SELECT created_at, '` + StatementAdjustment + `', id::text, NULL, reason, amount
+		FROM balance_adjustment WHERE user_id = $1
+		UNION ALL
+		SELECT created_at, '` + StatementReferral + `', id::text, NULL, NULL, referrer_bonus
+		FROM referral_bonus WHERE referrer_id = $1 AND referrer_bonus != 0
+		UNION ALL
+		SELECT created_at, '` + StatementReferral + `', id::text, "order", NULL, referred_bonus
+		FROM referral_bonus WHERE referred_id = $1 AND referred_bonus != 0
+		UNION ALL
+		SELECT e.expired_at, '` + StatementExpiration + `', e.id::text, l."order", NULL, -e.amount
+		FROM point_expiration e JOIN point_lot l ON l.id = e.lot_id WHERE e.user_id = $1),
+	running AS (
+		SELECT *, sum(amount) OVER (ORDER BY at, type, ref ROWS UNBOUNDED PRECEDING) AS balance
+		FROM entries)`
+
+// GetStatement выписка по счёту баллов за период [from, to) с балансом после каждого движения,
+// входящим и исходящим остатком за период
+func (s *PG) GetStatement(ctx context.Context, userID int64, from time.Time, to time.Time, limit int, offset int) (*Statement, error) {
+	statement := &Statement{
+		From: RFC3339DateTime{Time: from, Valid: true},
+		To:   RFC3339DateTime{Time: to, Valid: true},
+	}
+
+	err := s.db.QueryRow(ctx,
+		`WITH `+statementEntriesCTE+`
+		SELECT
+			coalesce(sum(amount) FILTER (WHERE at < $2), 0),
+			coalesce(sum(amount) FILTER (WHERE at < $3), 0),
+			count(*) FILTER (WHERE at >= $2 AND at < $3)
+		FROM running`,
+		userID, from, to).
+		Scan(&statement.OpeningBalance, &statement.ClosingBalance, &statement.Total)
+	if err != nil {
+		return nil, fmt.Errorf("cant select statement totals: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx,
+		`WITH `+statementEntriesCTE+`
+		SELECT type, order_num, reason, amount, balance, at
+		FROM running WHERE at >= $2 AND at < $3
+		ORDER BY at, type, ref LIMIT $4 OFFSET $5`,
+		userID, from, to, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("cant select statement: %w", err)
+	}
+	defer rows.Close()
+	statement.Entries = []StatementEntry{}
+
+	for rows.Next() {
+		var (
+			v           StatementEntry
+			processedAt sql.NullTime
+		)
+		err = rows.Scan(&v.Type, &v.OrderNum, &v.Reason, &v.Amount, &v.Balance, &processedAt)
+		if err != nil {
+			return nil, fmt.Errorf("cant parse row from select statement: %w", err)
+		}
+		v.ProcessedAt = RFC3339DateTime(processedAt)
+		statement.Entries = append(statement.Entries, v)
+	}
+	return statement, rows.Err()
+}
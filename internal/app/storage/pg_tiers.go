@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Уровень пользователя ("user".tier) определяется суммой базовых начислений (order.base_accrual,
+// до множителя уровня) за 12 месяцев. Уровень пересчитывается в UpdateOrderStatus при каждом начислении
+// и ночным пересчётом (RecalculateTiers), когда старые начисления выходят из окна.
+
+// tierWindowStart начало окна уровня - 12 месяцев до начала текущего дня UTC,
+// поэтому без новых начислений прогресс меняется не чаще раза в сутки
+const tierWindowStart = `(` + utcDayStart + ` - interval '12 months')`
+
+// rollingAccrualSQL подзапрос суммы базовых начислений пользователя userID за окно уровня
+func rollingAccrualSQL(userID string) string {
+	return `SELECT coalesce(sum(base_accrual), 0) FROM "order" ` +
+		`WHERE user_id = ` + userID + ` AND status = 'PROCESSED' AND processed_at >= ` + tierWindowStart
+}
+
+// tierSQL выражение уровня по сумме начислений sum; silver и gold - номера параметров с порогами
+func tierSQL(sum string, silver int, gold int) string {
+	return fmt.Sprintf(`(CASE WHEN %[1]s >= $%[3]d::numeric THEN '`+TierGold+`' `+
+		`WHEN %[1]s >= $%[2]d::numeric THEN '`+TierSilver+`' ELSE '`+TierBronze+`' END)`,
+		sum, silver, gold)
+}
+
+// multiplierSQL выражение множителя уровня tier; bronze, silver и gold - номера параметров с множителями
+func multiplierSQL(tier string, bronze int, silver int, gold int) string {
+	return fmt.Sprintf(`(CASE %[1]s WHEN '`+TierGold+`' THEN $%[4]d::numeric `+
+		`WHEN '`+TierSilver+`' THEN $%[3]d::numeric ELSE $%[2]d::numeric END)`,
+		tier, bronze, silver, gold)
+}
+
+// getTierStatus уровень пользователя и прогресс до следующего
+func (s *PG) getTierStatus(ctx context.Context, userID int64) (*TierStatus, error) {
+	status := &TierStatus{}
+	err := s.db.QueryRow(ctx,
+		`SELECT tier, (`+rollingAccrualSQL(`$1`)+`) FROM "user" WHERE id = $1`, userID).
+		Scan(&status.Tier, &status.RollingAccrual)
+	if err != nil {
+		return nil, fmt.Errorf("cant select user tier: %w", err)
+	}
+	status.Multiplier = s.tiers.Multiplier(status.Tier)
+	if next, threshold := s.tiers.Next(status.Tier); next != "" {
+		remaining := math.Max(threshold-status.RollingAccrual, 0)
+		status.NextTier = next
+		status.NextTierThreshold = &threshold
+		status.RemainingToNextTier = &remaining
+	}
+	return status, nil
+}
+
+// RecalculateTiers пересчитывает уровни не более чем limit пользователей с id больше afterUserID.
+// Возвращает последний обработанный id, число обработанных пользователей (меньше limit - пользователи
+// кончились) и число пользователей, у которых уровень изменился.
+func (s *PG) RecalculateTiers(ctx context.Context, afterUserID int64, limit int) (int64, int, int, error) {
+	var (
+		lastUserID     int64
+		users, changed int
+	)
+	err := s.db.QueryRow(ctx,
+		`WITH batch AS (
+			SELECT id FROM "user" WHERE id > $1 ORDER BY id LIMIT $2),
+		rolling AS (
+			SELECT batch.id, coalesce(sum(o.base_accrual), 0) AS rolling_sum
+			FROM batch LEFT JOIN "order" o
+				ON o.user_id = batch.id AND o.status = 'PROCESSED' AND o.processed_at >= `+tierWindowStart+`
+			GROUP BY batch.id),
+		updated AS (
+			UPDATE "user" SET tier = `+tierSQL(`rolling.rolling_sum`, 3, 4)+`, tier_updated_at = now(), `+bumpChangeVersion+`
+			FROM rolling
+			WHERE "user".id = rolling.id AND "user".tier != `+tierSQL(`rolling.rolling_sum`, 3, 4)+`
+			RETURNING "user".id)
+		SELECT coalesce(max(batch.id), 0), count(*), (SELECT count(*) FROM updated) FROM batch`,
+		afterUserID, limit, s.tiers.SilverThreshold, s.tiers.GoldThreshold).
+		Scan(&lastUserID, &users, &changed)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("cant recalculate tiers: %w", err)
+	}
+	return lastUserID, users, changed, nil
+}
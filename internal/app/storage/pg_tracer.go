@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+// pgTracer спаны на запросы к базе. В pgx v4 нет хуков до и после запроса, зато логгер соединения
+// вызывается после каждого Query/Exec/CopyFrom с длительностью, поэтому спан создаётся задним числом.
+// Запросы вне трассировки (проверки готовности, фоновые очистки) спанов не получают.
+type pgTracer struct{}
+
+var _ pgx.Logger = pgTracer{}
+
+// tracedMessages сообщения логгера pgx, соответствующие запросам
+var tracedMessages = map[string]bool{
+	"Query":             true,
+	"Exec":              true,
+	"CopyFrom":          true,
+	"BatchResult.Exec":  true,
+	"BatchResult.Query": true,
+}
+
+func (pgTracer) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	if !tracedMessages[msg] || !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return
+	}
+
+	end := time.Now()
+	start := end
+	if duration, ok := data["time"].(time.Duration); ok {
+		start = end.Add(-duration)
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("db.system", "postgresql")}
+	name := msg
+	if sql, ok := data["sql"].(string); ok {
+		name = sqlOperation(sql)
+		attrs = append(attrs, attribute.String("db.query.text", sql))
+	}
+	if table, ok := data["tableName"].(pgx.Identifier); ok {
+		name = "COPY"
+		attrs = append(attrs, attribute.String("db.collection.name", table.Sanitize()))
+	}
+	if rows, ok := data["rowCount"].(int64); ok {
+		attrs = append(attrs, attribute.Int64("db.response.returned_rows", rows))
+	}
+	if rows, ok := data["rowCount"].(int); ok {
+		attrs = append(attrs, attribute.Int("db.response.returned_rows", rows))
+	}
+
+	_, span := tracing.Start(ctx, "storage", "pg "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...))
+	if err, ok := data["err"].(error); ok && level == pgx.LogLevelError {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// sqlOperation первое слово запроса (SELECT, INSERT, WITH...) для имени спана, текст запроса - в атрибуте
+func sqlOperation(sql string) string {
+	fields := strings.Fields(strings.Trim(sql, "; \t\n"))
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
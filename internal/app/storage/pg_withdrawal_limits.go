@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// queryRower пул соединений или транзакция
+type queryRower interface {
+	QueryRow(context.Context, string, ...interface{}) pgx.Row
+}
+
+// Начало текущих календарных дня и месяца в UTC, от них считаются ограничения per_day и per_month
+const (
+	utcDayStart   = `date_trunc('day', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`
+	utcMonthStart = `date_trunc('month', now() AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`
+)
+
+// getWithdrawalLimits общие, персональные и действующие ограничения пользователя
+func (s *PG) getWithdrawalLimits(ctx context.Context, db queryRower, userID int64) (*UserWithdrawalLimits, error) {
+	limits := &UserWithdrawalLimits{Default: s.withdrawalLimits}
+	err := db.QueryRow(ctx,
+		`SELECT min_sum, per_transaction, per_day, per_month FROM withdrawal_limit WHERE user_id = $1`, userID).
+		Scan(&limits.Override.Min, &limits.Override.PerTransaction, &limits.Override.PerDay, &limits.Override.PerMonth)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("cant select withdrawal limits: %w", err)
+	}
+
+	limits.Effective = limits.Default
+	override := func(value *float64, effective *float64) {
+		if value != nil {
+			*effective = *value
+		}
+	}
+	override(limits.Override.Min, &limits.Effective.Min)
+	override(limits.Override.PerTransaction, &limits.Effective.PerTransaction)
+	override(limits.Override.PerDay, &limits.Effective.PerDay)
+	override(limits.Override.PerMonth, &limits.Effective.PerMonth)
+	return limits, nil
+}
+
+// getWithdrawalAllowance действующие ограничения и остаток на сегодня и месяц
+func (s *PG) getWithdrawalAllowance(ctx context.Context, db queryRower, userID int64) (*WithdrawalAllowance, error) {
+	limits, err := s.getWithdrawalLimits(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	allowance := &WithdrawalAllowance{WithdrawalLimits: limits.Effective}
+	if allowance.PerDay == 0 && allowance.PerMonth == 0 {
+		return allowance, nil
+	}
+
+	var withdrawnToday, withdrawnThisMonth float64
+	err = db.QueryRow(ctx,
+		`SELECT coalesce(sum(sum) FILTER (WHERE processed_at >= `+utcDayStart+`), 0), coalesce(sum(sum), 0)
+		FROM withdrawal WHERE user_id = $1 AND processed_at >= `+utcMonthStart,
+		userID).
+		Scan(&withdrawnToday, &withdrawnThisMonth)
+	if err != nil {
+		return nil, fmt.Errorf("cant select withdrawn sums: %w", err)
+	}
+	remaining := func(limit float64, withdrawn float64) *float64 {
+		if limit == 0 {
+			return nil
+		}
+		value := math.Max(limit-withdrawn, 0)
+		return &value
+	}
+	allowance.RemainingToday = remaining(allowance.PerDay, withdrawnToday)
+	allowance.RemainingThisMonth = remaining(allowance.PerMonth, withdrawnThisMonth)
+	return allowance, nil
+}
+
+// checkWithdrawalLimits *WithdrawalLimitError, если списание sum нарушает ограничения
+func checkWithdrawalLimits(allowance *WithdrawalAllowance, sum float64) error {
+	switch {
+	case allowance.Min > 0 && sum < allowance.Min:
+		return &WithdrawalLimitError{Limit: WithdrawalLimitMin, Value: allowance.Min}
+	case allowance.PerTransaction > 0 && sum > allowance.PerTransaction:
+		return &WithdrawalLimitError{Limit: WithdrawalLimitPerTransaction, Value: allowance.PerTransaction}
+	case allowance.RemainingToday != nil && sum > *allowance.RemainingToday:
+		return &WithdrawalLimitError{Limit: WithdrawalLimitPerDay, Value: allowance.PerDay, Remaining: *allowance.RemainingToday}
+	case allowance.RemainingThisMonth != nil && sum > *allowance.RemainingThisMonth:
+		return &WithdrawalLimitError{Limit: WithdrawalLimitPerMonth, Value: allowance.PerMonth, Remaining: *allowance.RemainingThisMonth}
+	}
+	return nil
+}
+
+// GetWithdrawalLimits ограничения списаний пользователя для сотрудников
+func (s *PG) GetWithdrawalLimits(ctx context.Context, userID int64) (*UserWithdrawalLimits, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM "user" WHERE id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("cant select user: %w", err)
+	}
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return s.getWithdrawalLimits(ctx, s.db, userID)
+}
+
+// SetWithdrawalLimits задаёт персональные ограничения списаний пользователя;
+// пустой override возвращает пользователю общие ограничения
+func (s *PG) SetWithdrawalLimits(ctx context.Context, actorID int64, userID int64, override WithdrawalLimitsOverride, reason string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// остаток на сегодня и месяц показывается в балансе, его ETag должен смениться
+	tag, err := tx.Exec(ctx,
+		`UPDATE "user" SET `+bumpChangeVersion+` WHERE id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("update user error: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	if override.IsEmpty() {
+		_, err = tx.Exec(ctx, `DELETE FROM withdrawal_limit WHERE user_id = $1`, userID)
+	} else {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO withdrawal_limit (user_id, min_sum, per_transaction, per_day, per_month, actor_id, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, now())
+			ON CONFLICT (user_id) DO UPDATE SET
+				min_sum = excluded.min_sum, per_transaction = excluded.per_transaction,
+				per_day = excluded.per_day, per_month = excluded.per_month,
+				actor_id = excluded.actor_id, updated_at = excluded.updated_at`,
+			userID, override.Min, override.PerTransaction, override.PerDay, override.PerMonth, actorID)
+	}
+	if err != nil {
+		return fmt.Errorf("save withdrawal limits error: %w", err)
+	}
+
+	err = insertAuditRecord(ctx, tx, AuditRecord{
+		ActorID:      actorID,
+		Action:       "withdrawal_limits.set",
+		TargetUserID: &userID,
+		Reason:       reason,
+	}, map[string]interface{}{"override": override})
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cant commit tx %w", err)
+	}
+	return nil
+}
@@ -2,25 +2,83 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/polosaty/go-dev-final/internal/app/tracing"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrWrongPassword = errors.New("wrong password")
 var ErrWrongLogin = errors.New("wrong login")
 var ErrDuplicateUser = errors.New("duplicate user")
+var ErrReferralCodeNotFound = errors.New("referral code not found")
 var ErrWrongToken = errors.New("wrong token")
+var ErrSessionNotFound = errors.New("session not found")
+var ErrUserLocked = errors.New("user temporarily locked")
+var ErrUserBlocked = errors.New("user blocked")
+var ErrUserNotFound = errors.New("user not found")
+var ErrPermissionDenied = errors.New("permission denied")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrOrderAlreadyProcessed = errors.New("order already processed")
+
+// UserLockedError пользователь временно заблокирован после серии неудачных попыток входа
+type UserLockedError struct {
+	Until time.Time
+}
+
+func (e *UserLockedError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrUserLocked, e.Until.Format(time.RFC3339))
+}
+
+func (e *UserLockedError) Is(target error) bool {
+	return target == ErrUserLocked
+}
 
 var ErrOrderDuplicate = errors.New("order already uploaded")
 var ErrOrderConflict = errors.New("order conflict")
 
+var ErrSchemaVersion = errors.New("unexpected database schema version")
 var ErrInsufficientBalance = errors.New("insufficient balance for withdrawn")
+var ErrInvalidWithdrawalSum = errors.New("withdrawal sum must be positive")
+var ErrWithdrawalLimit = errors.New("withdrawal limit exceeded")
+var ErrFraudDecisionNotFound = errors.New("fraud decision not found")
+var ErrFraudDecisionReviewed = errors.New("fraud decision already reviewed")
+
+// Виды ограничений списаний (WithdrawalLimitError.Limit)
+const (
+	WithdrawalLimitMin            = "min"
+	WithdrawalLimitPerTransaction = "per_transaction"
+	WithdrawalLimitPerDay         = "per_day"
+	WithdrawalLimitPerMonth       = "per_month"
+)
+
+// WithdrawalLimitError списание нарушает ограничение Limit со значением Value;
+// Remaining - сколько ещё можно списать за период (для per_day и per_month)
+type WithdrawalLimitError struct {
+	Limit     string
+	Value     float64
+	Remaining float64
+}
+
+func (e *WithdrawalLimitError) Error() string {
+	return fmt.Sprintf("%s: %s %.2f", ErrWithdrawalLimit, e.Limit, e.Value)
+}
+
+func (e *WithdrawalLimitError) Is(target error) bool {
+	return target == ErrWithdrawalLimit
+}
 
 type RFC3339DateTime sql.NullTime
 
@@ -46,13 +104,32 @@ func (c RFC3339DateTime) MarshalJSON() ([]byte, error) {
 }
 
 type Order struct {
-	OrderNum    string   `json:"number"`
-	Status      string   `json:"status"`
-	Accrual     *float64 `json:"accrual,omitempty"`
-	processedAt RFC3339DateTime
+	OrderNum string   `json:"number"`
+	Status   string   `json:"status"`
+	Accrual  *float64 `json:"accrual,omitempty"`
+	// ProcessedAt время получения окончательного статуса, в списке заказов не отдаётся
+	// (есть в OrderDetail и выгрузке заказов)
+	ProcessedAt RFC3339DateTime `json:"-"`
 	UploadedAt  RFC3339DateTime `json:"uploaded_at"`
 }
 
+// OrderStatusChange запись истории статусов заказа
+type OrderStatusChange struct {
+	Status    string          `json:"status"`
+	Accrual   *float64        `json:"accrual,omitempty"`
+	ChangedAt RFC3339DateTime `json:"changed_at"`
+}
+
+// OrderDetail заказ с полной историей смены статусов
+type OrderDetail struct {
+	OrderNum    string              `json:"number"`
+	Status      string              `json:"status"`
+	Accrual     *float64            `json:"accrual,omitempty"`
+	UploadedAt  RFC3339DateTime     `json:"uploaded_at"`
+	ProcessedAt *RFC3339DateTime    `json:"processed_at,omitempty"`
+	History     []OrderStatusChange `json:"history"`
+}
+
 type OrderForCheckStatus struct {
 	OrderNum   string
 	Status     string
@@ -66,9 +143,227 @@ type OrderUpdateStatus struct {
 	ProcessedAt time.Time
 }
 
+// Profile данные пользователя для выгрузки
+type Profile struct {
+	ID        int64           `json:"id"`
+	Login     string          `json:"login"`
+	Balance   float64         `json:"balance"`
+	Withdrawn float64         `json:"withdrawn"`
+	CreatedAt RFC3339DateTime `json:"created_at"`
+}
+
+// UserInfo пользователь глазами поддержки
+type UserInfo struct {
+	ID          int64           `json:"id"`
+	Login       string          `json:"login"`
+	Role        Role            `json:"role"`
+	IsActive    bool            `json:"is_active"`
+	Balance     float64         `json:"balance"`
+	Withdrawn   float64         `json:"withdrawn"`
+	CreatedAt   RFC3339DateTime `json:"created_at"`
+	DeletedAt   RFC3339DateTime `json:"deleted_at"`
+	LockedUntil RFC3339DateTime `json:"locked_until"`
+}
+
+// AuditRecord запись журнала действий сотрудников
+type AuditRecord struct {
+	ID           int64           `json:"id"`
+	ActorID      int64           `json:"actor_id"`
+	Action       string          `json:"action"`
+	TargetUserID *int64          `json:"target_user_id,omitempty"`
+	TargetOrder  *string         `json:"target_order,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"`
+	CreatedAt    RFC3339DateTime `json:"created_at"`
+}
+
+// BalanceAdjustment ручная корректировка баланса сотрудником
+type BalanceAdjustment struct {
+	UserID  int64   `json:"user_id"`
+	Amount  float64 `json:"amount"`
+	Reason  string  `json:"reason"`
+	ActorID int64   `json:"actor_id"`
+}
+
+// Типы движений по счёту баллов в выписке
+const (
+	StatementAccrual    = "accrual"
+	StatementWithdrawal = "withdrawal"
+	StatementAdjustment = "adjustment"
+	StatementExpiration = "expiration"
+	StatementReferral   = "referral"
+)
+
+// StatementEntry движение по счёту баллов: начисление за заказ, списание, ручная корректировка,
+// реферальный бонус или сгорание баллов (order - заказ, за который они были начислены)
+type StatementEntry struct {
+	Type        string          `json:"type"`
+	OrderNum    *string         `json:"order,omitempty"`
+	Reason      *string         `json:"reason,omitempty"`
+	Amount      float64         `json:"amount"`
+	Balance     float64         `json:"balance"`
+	ProcessedAt RFC3339DateTime `json:"processed_at"`
+}
+
+// Statement выписка по счёту баллов за период [From, To)
+type Statement struct {
+	From           RFC3339DateTime  `json:"from"`
+	To             RFC3339DateTime  `json:"to"`
+	OpeningBalance float64          `json:"opening_balance"`
+	ClosingBalance float64          `json:"closing_balance"`
+	Total          int64            `json:"total"`
+	Entries        []StatementEntry `json:"entries"`
+}
+
+// ChangeVersion версия изменений заказов, списаний и баланса пользователя
+type ChangeVersion struct {
+	Version   int64
+	ChangedAt time.Time
+}
+
 type Balance struct {
 	Current   float64 `json:"current"`
 	Withdrawn float64 `json:"withdrawn"`
+	// Expiring ближайшие сгорания баллов (заполняется только GetBalance)
+	Expiring []PointsExpiration `json:"expiring,omitempty"`
+	// WithdrawalAllowance ограничения списаний и остаток на сегодня и месяц (заполняется только GetBalance)
+	WithdrawalAllowance *WithdrawalAllowance `json:"withdrawal_allowance,omitempty"`
+	// Tier уровень программы лояльности и прогресс до следующего (заполняется только GetBalance)
+	Tier *TierStatus `json:"tier,omitempty"`
+}
+
+// WithdrawalLimits ограничения списаний: минимальная сумма, максимум за одно списание,
+// за календарный день и месяц (UTC); 0 - без ограничения
+type WithdrawalLimits struct {
+	Min            float64 `json:"min"`
+	PerTransaction float64 `json:"per_transaction"`
+	PerDay         float64 `json:"per_day"`
+	PerMonth       float64 `json:"per_month"`
+}
+
+// WithdrawalLimitsOverride персональные ограничения пользователя; nil - действует общее ограничение
+type WithdrawalLimitsOverride struct {
+	Min            *float64 `json:"min"`
+	PerTransaction *float64 `json:"per_transaction"`
+	PerDay         *float64 `json:"per_day"`
+	PerMonth       *float64 `json:"per_month"`
+}
+
+// IsEmpty персональных ограничений нет
+func (o WithdrawalLimitsOverride) IsEmpty() bool {
+	return o.Min == nil && o.PerTransaction == nil && o.PerDay == nil && o.PerMonth == nil
+}
+
+// UserWithdrawalLimits ограничения списаний пользователя: общие, персональные и действующие
+type UserWithdrawalLimits struct {
+	Default   WithdrawalLimits         `json:"default"`
+	Override  WithdrawalLimitsOverride `json:"override"`
+	Effective WithdrawalLimits         `json:"effective"`
+}
+
+// WithdrawalAllowance действующие ограничения списаний и сколько ещё можно списать
+// сегодня и в этом месяце (nil - без ограничения)
+type WithdrawalAllowance struct {
+	WithdrawalLimits
+	RemainingToday     *float64 `json:"remaining_today"`
+	RemainingThisMonth *float64 `json:"remaining_this_month"`
+}
+
+// PointsExpiration сколько баллов сгорит в момент ExpiresAt, если их не потратить
+// Уровни программы лояльности
+const (
+	TierBronze = "bronze"
+	TierSilver = "silver"
+	TierGold   = "gold"
+)
+
+// Tiers пороги уровней по сумме базовых начислений (до множителя) за 12 месяцев
+// и множители начислений на каждом уровне
+type Tiers struct {
+	SilverThreshold  float64
+	GoldThreshold    float64
+	BronzeMultiplier float64
+	SilverMultiplier float64
+	GoldMultiplier   float64
+}
+
+// Multiplier множитель начислений уровня tier
+func (t Tiers) Multiplier(tier string) float64 {
+	switch tier {
+	case TierGold:
+		return t.GoldMultiplier
+	case TierSilver:
+		return t.SilverMultiplier
+	}
+	return t.BronzeMultiplier
+}
+
+// Next следующий уровень после tier и его порог; для высшего уровня - пустая строка
+func (t Tiers) Next(tier string) (string, float64) {
+	switch tier {
+	case TierBronze:
+		return TierSilver, t.SilverThreshold
+	case TierSilver:
+		return TierGold, t.GoldThreshold
+	}
+	return "", 0
+}
+
+// TierStatus уровень пользователя и прогресс до следующего
+type TierStatus struct {
+	Tier       string  `json:"tier"`
+	Multiplier float64 `json:"multiplier"`
+	// RollingAccrual базовые начисления за 12 месяцев, по ним определяется уровень
+	RollingAccrual float64 `json:"rolling_accrual"`
+	// NextTier, NextTierThreshold и RemainingToNextTier не заполняются на высшем уровне
+	NextTier            string   `json:"next_tier,omitempty"`
+	NextTierThreshold   *float64 `json:"next_tier_threshold,omitempty"`
+	RemainingToNextTier *float64 `json:"remaining_to_next_tier,omitempty"`
+}
+
+// ReferralBonus бонусы реферальной программы за первый обработанный заказ приглашённого
+type ReferralBonus struct {
+	// Referrer бонус пригласившему, Referred - приглашённому
+	Referrer float64
+	Referred float64
+	// MaxPerReferrer сколько приглашений одного пользователя вознаграждается, 0 - без ограничения
+	MaxPerReferrer int
+}
+
+// Состояния приглашения (Referral.Status)
+const (
+	// ReferralPending приглашённый ещё не получил начисление ни за один заказ
+	ReferralPending = "pending"
+	// ReferralRewarded бонусы начислены
+	ReferralRewarded = "rewarded"
+	// ReferralCapped бонусы не начислены: достигнут лимит вознаграждаемых приглашений
+	ReferralCapped = "capped"
+)
+
+// Referral приглашённый пользователь; логин показывается замаскированным
+type Referral struct {
+	Login        string           `json:"login"`
+	RegisteredAt RFC3339DateTime  `json:"registered_at"`
+	Status       string           `json:"status"`
+	Bonus        *float64         `json:"bonus,omitempty"`
+	RewardedAt   *RFC3339DateTime `json:"rewarded_at,omitempty"`
+}
+
+// Referrals реферальный код пользователя, условия программы и его приглашения
+type Referrals struct {
+	Code          string  `json:"code"`
+	ReferrerBonus float64 `json:"referrer_bonus"`
+	ReferredBonus float64 `json:"referred_bonus"`
+	// MaxRewarded лимит вознаграждаемых приглашений (null - без ограничения)
+	MaxRewarded *int       `json:"max_rewarded"`
+	Rewarded    int        `json:"rewarded"`
+	Earned      float64    `json:"earned"`
+	Referrals   []Referral `json:"referrals"`
+}
+
+type PointsExpiration struct {
+	Amount    float64         `json:"amount"`
+	ExpiresAt RFC3339DateTime `json:"expires_at"`
 }
 
 type Withdrawal struct {
@@ -77,36 +372,210 @@ type Withdrawal struct {
 	ProcessedAt RFC3339DateTime `json:"processed_at,omitempty"`
 }
 
+// WithdrawalSumIsValid сумма списания положительна и конечна: отрицательная сумма увеличила бы баланс
+// и уменьшила списанное за день и месяц
+func WithdrawalSumIsValid(sum float64) bool {
+	return sum > 0 && !math.IsInf(sum, 0)
+}
+
+// FraudAction действие пользователя, проверяемое правилами антифрода
+type FraudAction string
+
+const (
+	FraudActionOrderUpload FraudAction = "order_upload"
+	FraudActionWithdrawal  FraudAction = "withdrawal"
+)
+
+// FraudOutcome результат проверки: пропустить, пропустить и отметить для проверки сотрудником, отклонить.
+// Значения упорядочены по строгости.
+type FraudOutcome string
+
+const (
+	FraudAllow FraudOutcome = "allow"
+	FraudFlag  FraudOutcome = "flag"
+	FraudBlock FraudOutcome = "block"
+)
+
+// Severity строгость результата для выбора самого строгого из сработавших правил
+func (o FraudOutcome) Severity() int {
+	switch o {
+	case FraudFlag:
+		return 1
+	case FraudBlock:
+		return 2
+	}
+	return 0
+}
+
+// Решения сотрудника по отмеченному действию (FraudDecision.Resolution)
+const (
+	FraudResolutionConfirmed = "confirmed"
+	FraudResolutionDismissed = "dismissed"
+)
+
+// FraudRuleHit сработавшее правило антифрода
+type FraudRuleHit struct {
+	Rule    string       `json:"rule"`
+	Outcome FraudOutcome `json:"outcome"`
+	Reason  string       `json:"reason"`
+}
+
+// FraudDecision отмеченное или отклонённое правилами антифрода действие пользователя
+// и решение сотрудника по нему
+type FraudDecision struct {
+	ID           int64            `json:"id"`
+	UserID       int64            `json:"user_id"`
+	Action       FraudAction      `json:"action"`
+	OrderNum     string           `json:"order,omitempty"`
+	Sum          *float64         `json:"sum,omitempty"`
+	Outcome      FraudOutcome     `json:"outcome"`
+	Rules        []FraudRuleHit   `json:"rules"`
+	IP           string           `json:"ip,omitempty"`
+	CreatedAt    RFC3339DateTime  `json:"created_at"`
+	ReviewerID   *int64           `json:"reviewer_id,omitempty"`
+	Resolution   string           `json:"resolution,omitempty"`
+	ReviewReason string           `json:"review_reason,omitempty"`
+	ReviewedAt   *RFC3339DateTime `json:"reviewed_at,omitempty"`
+}
+
+// FraudDecisionFilter отбор решений антифрода; пустые поля не ограничивают выборку
+type FraudDecisionFilter struct {
+	UserID   *int64
+	Outcome  FraudOutcome
+	Reviewed *bool
+}
+
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleSupport Role = "support"
+	RoleAdmin   Role = "admin"
+)
+
+func (r Role) IsValid() bool {
+	return r == RoleUser || r == RoleSupport || r == RoleAdmin
+}
+
 type Session struct {
+	ID     int64
+	Token  string
+	UserID int64
+	// Role заполняется только там, где она нужна (см. handlers.requireRole)
+	Role      Role
+	ExpiresAt time.Time
+	IP        string
+	UserAgent string
+}
+
+// SigningKey ключ подписи access токенов (JWT)
+type SigningKey struct {
+	KID       string
+	Secret    []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// RefreshToken токен для выпуска новой пары access/refresh токенов.
+// В базе хранится только хеш токена, сам токен отдаётся клиенту один раз.
+type RefreshToken struct {
 	Token     string
 	UserID    int64
 	ExpiresAt time.Time
 }
 
+// SessionInfo сессия пользователя для показа в списке активных сессий
+type SessionInfo struct {
+	ID         int64           `json:"id"`
+	CreatedAt  RFC3339DateTime `json:"created_at"`
+	LastSeenAt RFC3339DateTime `json:"last_seen_at"`
+	ExpiresAt  RFC3339DateTime `json:"expires_at"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"user_agent"`
+	Current    bool            `json:"current"`
+}
+
 type Repository interface {
-	CreateUser(ctx context.Context, login string, password string) (int64, error)
-	LoginUser(ctx context.Context, login string, password string) (*Session, error)
-	CreateSession(ctx context.Context, userID int64) (*Session, error)
-	GetUserByToken(ctx context.Context, token string) (int64, error)
+	CreateUser(ctx context.Context, login string, password string, referralCode string) (int64, error)
+	LoginUser(ctx context.Context, login string, password string) (int64, error)
+	RegisterLoginFailure(ctx context.Context, login string, maxFailures int, lockFor time.Duration) error
+	GetProfile(ctx context.Context, userID int64) (*Profile, error)
+	ChangePassword(ctx context.Context, userID int64, currentPassword string, newPassword string, keepSessionID int64) error
+	DeleteUser(ctx context.Context, userID int64, password string) error
+
+	CreateSession(ctx context.Context, session Session, ttl time.Duration) (*Session, error)
+	GetSessionByToken(ctx context.Context, token string, prolong time.Duration) (*Session, error)
+	GetSessions(ctx context.Context, userID int64) ([]SessionInfo, error)
+	DeleteSession(ctx context.Context, userID int64, sessionID int64) error
+	DeleteOtherSessions(ctx context.Context, userID int64, currentSessionID int64) (int64, error)
+
+	GetSigningKeys(ctx context.Context) ([]SigningKey, error)
+	CreateSigningKey(ctx context.Context, key SigningKey) error
+
+	CreateRefreshToken(ctx context.Context, userID int64, ttl time.Duration) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, token string, ttl time.Duration) (*RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, token string) error
+
+	GetUserRole(ctx context.Context, userID int64) (Role, error)
+	SearchUsers(ctx context.Context, query string, limit int, offset int) ([]UserInfo, error)
+	GetUser(ctx context.Context, userID int64) (*UserInfo, error)
+	AdjustBalance(ctx context.Context, adjustment BalanceAdjustment) (*Balance, error)
+	SetUserActive(ctx context.Context, actorID int64, userID int64, active bool, reason string) error
+	SetUserRole(ctx context.Context, actorID int64, userID int64, role Role, reason string) error
+	RecheckOrder(ctx context.Context, actorID int64, order string, reason string) error
+	CreateAuditRecord(ctx context.Context, record AuditRecord) error
+	GetAuditRecords(ctx context.Context, targetUserID *int64, limit int, offset int) ([]AuditRecord, error)
+
+	TakeRateLimitToken(ctx context.Context, key string, burst float64, rate float64) (float64, error)
+	DeleteStaleRateLimitTokens(ctx context.Context, olderThan time.Duration) error
 
 	CreateOrder(ctx context.Context, userID int64, order string) error
+	IsOrderUploaded(ctx context.Context, userID int64, order string) (bool, error)
+	CheckUserActive(ctx context.Context, userID int64) error
 	GetOrders(ctx context.Context, userID int64) ([]Order, error)
+	GetOrder(ctx context.Context, userID int64, order string) (*OrderDetail, error)
 
 	GetBalance(ctx context.Context, userID int64) (*Balance, error)
+	GetReferrals(ctx context.Context, userID int64, limit int, offset int) (*Referrals, error)
+	GetChangeVersion(ctx context.Context, userID int64) (*ChangeVersion, error)
+	ExportOrders(ctx context.Context, userID int64, fn func(Order) error) error
+	ExportWithdrawals(ctx context.Context, userID int64, fn func(Withdrawal) error) error
+	ExportHistory(ctx context.Context, userID int64, fn func(StatementEntry) error) error
+	GetStatement(ctx context.Context, userID int64, from time.Time, to time.Time, limit int, offset int) (*Statement, error)
 
 	CreateWithdrawal(ctx context.Context, userID int64, withdrawal Withdrawal) error
+	GetWithdrawalLimits(ctx context.Context, userID int64) (*UserWithdrawalLimits, error)
+	SetWithdrawalLimits(ctx context.Context, actorID int64, userID int64, override WithdrawalLimitsOverride, reason string) error
 	GetWithdrawals(ctx context.Context, userID int64) ([]Withdrawal, error)
 
+	CountOrderUploads(ctx context.Context, userID int64, since time.Time) (int, error)
+	CountOrderConflicts(ctx context.Context, userID int64, since time.Time) (int, error)
+	GetUserCreatedAt(ctx context.Context, userID int64) (time.Time, error)
+	GetLastAccrualAt(ctx context.Context, userID int64) (*time.Time, error)
+	CreateFraudDecision(ctx context.Context, decision FraudDecision) (int64, error)
+	GetFraudDecisions(ctx context.Context, filter FraudDecisionFilter, limit int, offset int) ([]FraudDecision, error)
+	ReviewFraudDecision(ctx context.Context, actorID int64, id int64, resolution string, reason string) (*FraudDecision, error)
+
 	SelectOrdersForCheckStatus(ctx context.Context, limit int, uploadedAfter *time.Time) ([]OrderForCheckStatus, error)
 	UpdateOrderStatus(ctx context.Context, orders []OrderUpdateStatus) error
+	ExpirePoints(ctx context.Context, limit int) (int, float64, error)
+	RecalculateTiers(ctx context.Context, afterUserID int64, limit int) (int64, int, int, error)
+
+	Ping(ctx context.Context) error
+	CheckMigrations(ctx context.Context) (int, error)
 }
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+// HashPassword хэш пароля для хранения; cost меньше bcrypt.MinCost заменяется на bcrypt.DefaultCost
+func HashPassword(ctx context.Context, password string, cost int) (string, error) {
+	_, span := tracing.Start(ctx, "storage", "bcrypt hash")
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	tracing.End(span, err)
 	return string(bytes), err
 }
 
-func CheckPasswordHash(password, hash string) bool {
+func CheckPasswordHash(ctx context.Context, password, hash string) bool {
+	_, span := tracing.Start(ctx, "storage", "bcrypt compare")
+	defer span.End()
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
@@ -114,3 +583,25 @@ func CheckPasswordHash(password, hash string) bool {
 func generateToken() string {
 	return uuid.New().String()
 }
+
+func generateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// generateReferralCode реферальный код из 8 символов base32 (A-Z, 2-7)
+func generateReferralCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
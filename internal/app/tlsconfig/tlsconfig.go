@@ -0,0 +1,247 @@
+// Package tlsconfig настройки TLS для HTTP, gRPC и служебного порта: минимальная версия и шифры,
+// перечитывание сертификата без перезапуска, проверка клиентских сертификатов (mTLS).
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/polosaty/go-dev-final/internal/app/logging"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.Component("tls")
+
+var ErrNoCertificate = errors.New("tls certificate is not configured")
+
+// Options настройки TLS
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion 1.2 или 1.3
+	MinVersion string
+	// CipherSuites имена шифров для TLS 1.2 (tls.CipherSuiteName), пустой - выбор Go по умолчанию
+	CipherSuites []string
+}
+
+// Reloader держит текущий сертификат и CA для клиентских сертификатов и перечитывает их
+// при изменении файлов (Watch) или по запросу (Reload, например по SIGHUP).
+// Новые соединения сразу получают новый сертификат, текущие не разрываются.
+type Reloader struct {
+	options      Options
+	minVersion   uint16
+	cipherSuites []uint16
+
+	cert atomic.Pointer[tls.Certificate]
+
+	mu sync.Mutex
+	// clientCAs пулы CA для mTLS по файлам
+	clientCAs map[string]*atomic.Pointer[x509.CertPool]
+	modTimes  map[string]time.Time
+}
+
+// New проверяет настройки и загружает сертификат
+func New(options Options) (*Reloader, error) {
+	if options.CertFile == "" || options.KeyFile == "" {
+		return nil, ErrNoCertificate
+	}
+	minVersion, err := parseVersion(options.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := parseCipherSuites(options.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{
+		options:      options,
+		minVersion:   minVersion,
+		cipherSuites: cipherSuites,
+		clientCAs:    map[string]*atomic.Pointer[x509.CertPool]{},
+		modTimes:     map[string]time.Time{},
+	}
+	if err = r.loadCertificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ServerConfig конфигурация сервера без проверки клиентских сертификатов;
+// h2 включается http.Server и gRPC сами по NextProtos
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   r.minVersion,
+		CipherSuites: r.cipherSuites,
+		NextProtos:   []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+	}
+}
+
+// MutualConfig конфигурация сервера, требующая клиентский сертификат, подписанный CA из clientCAFile
+// (пустой clientCAFile - без mTLS, как ServerConfig)
+func (r *Reloader) MutualConfig(clientCAFile string) (*tls.Config, error) {
+	config := r.ServerConfig()
+	if clientCAFile == "" {
+		return config, nil
+	}
+	pool, err := r.addClientCA(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	// пул CA берётся на каждое подключение, чтобы подхватывать перечитанный файл
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		perConn := config.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.ClientAuth = tls.RequireAndVerifyClientCert
+		perConn.ClientCAs = pool.Load()
+		return perConn, nil
+	}
+	return config, nil
+}
+
+// Reload перечитывает сертификат и CA; при ошибке остаются прежние
+func (r *Reloader) Reload() error {
+	var errs []error
+	if err := r.loadCertificate(); err != nil {
+		errs = append(errs, err)
+	}
+	r.mu.Lock()
+	files := make([]string, 0, len(r.clientCAs))
+	for file := range r.clientCAs {
+		files = append(files, file)
+	}
+	r.mu.Unlock()
+	for _, file := range files {
+		if _, err := r.addClientCA(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Watch раз в interval проверяет время изменения файлов и перечитывает их, если они изменились
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				logger.ErrorContext(ctx, "reload tls certificates error", "error", err)
+				continue
+			}
+			logger.InfoContext(ctx, "tls certificates reloaded")
+		}
+	}
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for file, modTime := range r.modTimes {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) loadCertificate() error {
+	certModTime, keyModTime := modTime(r.options.CertFile), modTime(r.options.KeyFile)
+	cert, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+	if err != nil {
+		return fmt.Errorf("cant load tls certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.mu.Lock()
+	r.modTimes[r.options.CertFile] = certModTime
+	r.modTimes[r.options.KeyFile] = keyModTime
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Reloader) addClientCA(file string) (*atomic.Pointer[x509.CertPool], error) {
+	fileModTime := modTime(file)
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cant read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client CA file %s", file)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.clientCAs[file]
+	if !ok {
+		current = &atomic.Pointer[x509.CertPool]{}
+		r.clientCAs[file] = current
+	}
+	current.Store(pool)
+	r.modTimes[file] = fileModTime
+	return current, nil
+}
+
+func modTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func parseVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "1.2", "":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls min version %q, expected 1.2 or 1.3", version)
+	}
+}
+
+// parseCipherSuites разрешены только шифры, которые Go считает безопасными
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure tls cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	// без них клиенты HTTP/2 на TLS 1.2 не подключатся (RFC 7540, 9.2.2)
+	for _, id := range ids {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return ids, nil
+		}
+	}
+	return nil, errors.New("tls cipher suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 " +
+		"or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 required by HTTP/2")
+}
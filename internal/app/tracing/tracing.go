@@ -0,0 +1,159 @@
+// Package tracing трассировка OpenTelemetry: настройка экспорта (OTLP, stdout или файл) и сэмплирования,
+// спаны на HTTP маршруты chi.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"os"
+	"strings"
+)
+
+// Экспортёры спанов
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+const serviceName = "gophermart"
+
+const instrumentationPrefix = "github.com/polosaty/go-dev-final/internal/app/"
+
+// Options настройки трассировки
+type Options struct {
+	// Exporter none, otlp, stdout или file
+	Exporter string
+	// Endpoint адрес OTLP коллектора; пустой - из OTEL_EXPORTER_OTLP_ENDPOINT или localhost:4317 (4318 для http)
+	Endpoint string
+	// Protocol OTLP протокол: grpc или http
+	Protocol string
+	// Insecure OTLP без TLS
+	Insecure bool
+	// File куда писать спаны для экспортёра file
+	File string
+	// SampleRatio доля трассировок, которые сохраняются (для запросов без родительского спана)
+	SampleRatio float64
+}
+
+// Setup настраивает глобальный TracerProvider и распространение контекста (W3C traceparent).
+// Возвращает функцию, которая дописывает накопленные спаны при остановке.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closer, err := newExporter(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("cant create trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, options Options) (sdktrace.SpanExporter, io.Closer, error) {
+	switch strings.ToLower(options.Exporter) {
+	case ExporterNone, "":
+		return nil, nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+	case ExporterFile:
+		file, err := os.OpenFile(options.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cant open traces file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, err
+		}
+		return exporter, file, nil
+	case ExporterOTLP:
+		exporter, err := newOTLPExporter(ctx, options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cant create otlp exporter: %w", err)
+		}
+		return exporter, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q, expected none, otlp, stdout or file", options.Exporter)
+	}
+}
+
+func newOTLPExporter(ctx context.Context, options Options) (*otlptrace.Exporter, error) {
+	switch strings.ToLower(options.Protocol) {
+	case "grpc", "":
+		var opts []otlptracegrpc.Option
+		if options.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "http", "http/protobuf":
+		var opts []otlptracehttp.Option
+		if options.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(options.Endpoint))
+		}
+		if options.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol %q, expected grpc or http", options.Protocol)
+	}
+}
+
+// Tracer трейсер компонента (storage, order_checker, http...)
+func Tracer(component string) trace.Tracer {
+	return otel.Tracer(instrumentationPrefix + component)
+}
+
+// Start начинает спан компонента
+func Start(ctx context.Context, component string, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer(component).Start(ctx, name, opts...)
+}
+
+// End завершает спан, отмечая ошибку, если она есть
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}